@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/rmmh/cubeographer/go/region"
+)
+
+type blockPos struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	Z int `json:"z"`
+}
+
+type dimensionInfo struct {
+	Key string `json:"key"`
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+type worldInfo struct {
+	Name       string           `json:"name"`
+	Level      *region.LevelDat `json:"level"` // or null, without a level.dat
+	Dimensions []dimensionInfo  `json:"dimensions"`
+}
+
+type regionInfo struct {
+	X int `json:"x"`
+	Z int `json:"z"`
+	// the quadrants with any chunks, numbered like the r.X.Z.N.cmt tiles
+	Quadrants []int `json:"quadrants"`
+	// whether the tiles are up to date, rather than rendered on request
+	Rendered bool    `json:"rendered"`
+	YBounds  *[2]int `json:"y_bounds,omitempty"`
+}
+
+type regionsInfo struct {
+	Key           string `json:"key"`
+	ID            string `json:"id"`
+	RenderVersion int    `json:"render_version"`
+	// the lowest and highest (exclusive) Y of the rendered regions, or null
+	// if none have been rendered
+	YBounds *[2]int      `json:"y_bounds"`
+	Spawn   *blockPos    `json:"spawn"` // only in the overworld
+	Regions []regionInfo `json:"regions"`
+}
+
+// blockInfo describes the block at a position.
+type blockInfo struct {
+	blockPos
+	Name        string              `json:"name"`
+	DisplayName string              `json:"display_name"`
+	Properties  map[string]string   `json:"properties,omitempty"`
+	BlockLight  int                 `json:"block_light"`
+	SkyLight    int                 `json:"sky_light"`
+	Biome       string              `json:"biome,omitempty"`
+	BlockEntity *region.BlockEntity `json:"block_entity,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("unable to write response: %v", err)
+	}
+}
+
+// readLevelDat reads a world's level.dat, which is read again for each
+// request since the game keeps it up to date.
+func readLevelDat(worldDir string) *region.LevelDat {
+	ld, err := region.ReadLevelDat(path.Join(worldDir, "level.dat"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("unable to read level.dat: %v", err)
+		}
+		return nil
+	}
+	return ld
+}
+
+// worldsHandler lists the configured worlds and their dimensions.
+func (s *server) worldsHandler(w http.ResponseWriter, r *http.Request) {
+	worlds := []worldInfo{}
+	byName := map[string]int{}
+	for _, key := range s.dimKeys {
+		dim := s.dims[key]
+		i, ok := byName[dim.world]
+		if !ok {
+			world := worldInfo{
+				Name:       dim.displayName,
+				Level:      readLevelDat(dim.worldDir),
+				Dimensions: []dimensionInfo{},
+			}
+			if world.Name == "" && world.Level != nil {
+				world.Name = world.Level.LevelName
+			}
+			if world.Name == "" {
+				world.Name = filepath.Base(dim.worldDir)
+			}
+			i = len(worlds)
+			byName[dim.world] = i
+			worlds = append(worlds, world)
+		}
+		worlds[i].Dimensions = append(worlds[i].Dimensions, dimensionInfo{
+			Key: dim.key,
+			ID:  dim.id,
+			URL: "/" + dim.key + "/",
+		})
+	}
+	writeJSON(w, struct {
+		Worlds []worldInfo `json:"worlds"`
+	}{worlds})
+}
+
+// regionsHandler lists the regions of a dimension that have any chunks,
+// and where the viewer should start.
+func (s *server) regionsHandler(w http.ResponseWriter, r *http.Request) {
+	dim, ok := s.dims[mux.Vars(r)["world"]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	entries, err := os.ReadDir(dim.regionDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	info := regionsInfo{
+		Key:           dim.key,
+		ID:            dim.id,
+		RenderVersion: converterVersion,
+		Regions:       []regionInfo{},
+	}
+	man := s.manifests[dim.key]
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".mca") {
+			continue
+		}
+		rx, rz, err := region.ParseRegionPath(entry.Name())
+		if err != nil {
+			continue
+		}
+		// the manifest has the quadrant summaries, and only reads
+		// the headers of regions that have changed
+		changed, summary, err := man.changed(path.Join(dim.regionDir, entry.Name()))
+		if err != nil {
+			log.Printf("%s %s: %v", dim.key, entry.Name(), err)
+			continue
+		}
+		ri := regionInfo{X: rx, Z: rz, Quadrants: []int{}, Rendered: !changed}
+		for q, qs := range summary.Quadrants {
+			if qs.Chunks > 0 {
+				ri.Quadrants = append(ri.Quadrants, q)
+			}
+		}
+		if len(ri.Quadrants) == 0 {
+			continue
+		}
+		if ri.Rendered && summary.MaxY > summary.MinY {
+			ri.YBounds = &[2]int{summary.MinY, summary.MaxY}
+			if info.YBounds == nil {
+				info.YBounds = &[2]int{summary.MinY, summary.MaxY}
+			}
+			info.YBounds[0] = min(info.YBounds[0], summary.MinY)
+			info.YBounds[1] = max(info.YBounds[1], summary.MaxY)
+		}
+		info.Regions = append(info.Regions, ri)
+	}
+
+	if dim.id == "minecraft:overworld" {
+		if ld := readLevelDat(dim.worldDir); ld != nil {
+			info.Spawn = &blockPos{ld.SpawnX, ld.SpawnY, ld.SpawnZ}
+		}
+	}
+
+	writeJSON(w, info)
+}
+
+// blockHandler describes the block at ?x=&y=&z=, read from the region file
+// the same way it's read for rendering.
+func (s *server) blockHandler(w http.ResponseWriter, r *http.Request) {
+	world := mux.Vars(r)["world"]
+	dim, ok := s.dims[world]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	readRegion := region.ReadRegion
+	if dim.readRegion != nil {
+		readRegion = dim.readRegion
+	}
+	var pos blockPos
+	for _, c := range []struct {
+		name string
+		v    *int
+	}{{"x", &pos.X}, {"y", &pos.Y}, {"z", &pos.Z}} {
+		v, err := strconv.Atoi(r.URL.Query().Get(c.name))
+		if err != nil {
+			http.Error(w, "bad "+c.name+" coordinate", http.StatusBadRequest)
+			return
+		}
+		*c.v = v
+	}
+
+	rx, rz := pos.X>>9, pos.Z>>9
+	x, z := pos.X&511, pos.Z&511
+	cdata, err := readRegion(s.regionPath(world, rx, rz), s.bm, []int{(x >> 4) + (z>>4)*32})
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "no region there", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	chunk := &cdata[(x>>4)+(z>>4)*32]
+	if len(chunk.Blocks) == 0 {
+		http.Error(w, "no chunk there", http.StatusNotFound)
+		return
+	}
+	if pos.Y < chunk.MinY {
+		http.Error(w, "below the world", http.StatusNotFound)
+		return
+	}
+
+	rs := regionState{
+		dir:        dim.regionDir,
+		bm:         s.bm,
+		rx:         rx,
+		rz:         rz,
+		cdata:      cdata,
+		openRegion: readRegion,
+	}
+	b, bs, bl, bsl := rs.get(x, pos.Y, z)
+	info := blockInfo{blockPos: pos, BlockLight: int(bl), SkyLight: int(bsl)}
+	info.Name, info.DisplayName, info.Properties = s.bm.Describe(b, bs)
+	if ys := (pos.Y - chunk.MinY) >> 4; ys < len(chunk.Biomes) && chunk.Biomes[ys] != nil {
+		id := chunk.Biomes[ys][((x&15)>>2)+((z&15)>>2)*4+((pos.Y&15)>>2)*16]
+		info.Biome = s.bm.BiomeName(id)
+	}
+	for i, be := range chunk.BlockEntities {
+		if be.X == pos.X && be.Y == pos.Y && be.Z == pos.Z {
+			info.BlockEntity = &chunk.BlockEntities[i]
+		}
+	}
+	writeJSON(w, info)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/gzip"
+	"github.com/rmmh/cubeographer/go/region"
+	"github.com/rmmh/cubeographer/go/render"
+	rp "github.com/rmmh/cubeographer/go/resourcepack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegionsHandler(t *testing.T) {
+	world := t.TempDir()
+	regionDir := path.Join(world, "region")
+	require.NoError(t, os.MkdirAll(regionDir, 0755))
+
+	// one chunk in the south-east quadrant, and a region with none
+	hdr := make([]byte, 8192)
+	require.NoError(t, os.WriteFile(path.Join(regionDir, "r.1.0.mca"), hdr, 0644))
+	binary.BigEndian.PutUint32(hdr[(20+20*32)*4:], 2<<8|1)
+	require.NoError(t, os.WriteFile(path.Join(regionDir, "r.-1.2.mca"), hdr, 0644))
+
+	tag := func(ty byte, name string) []byte {
+		buf := []byte{ty}
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(name)))
+		return append(buf, name...)
+	}
+	nbt := append(tag(region.TagCompound, ""), tag(region.TagCompound, "Data")...)
+	for _, f := range []struct {
+		name string
+		v    int32
+	}{{"SpawnX", -120}, {"SpawnY", 70}, {"SpawnZ", 45}} {
+		nbt = append(nbt, tag(region.TagInt, f.name)...)
+		nbt = binary.BigEndian.AppendUint32(nbt, uint32(f.v))
+	}
+	nbt = append(nbt, region.TagEnd, region.TagEnd)
+	var levelDat bytes.Buffer
+	zw := gzip.NewWriter(&levelDat)
+	zw.Write(nbt)
+	require.NoError(t, zw.Close())
+	require.NoError(t, os.WriteFile(path.Join(world, "level.dat"), levelDat.Bytes(), 0644))
+
+	dims, err := discoverDimensions(world)
+	require.NoError(t, err)
+	dims[0].worldDir = world
+	man, err := loadManifest(t.TempDir(), testBlockMapper(t), true)
+	require.NoError(t, err)
+	s := &server{
+		dims:      map[string]dimension{"0": dims[0]},
+		dimKeys:   []string{"0"},
+		manifests: map[string]*manifest{"0": man},
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/worlds", s.worldsHandler)
+	r.HandleFunc("/{world}/api/regions", s.regionsHandler)
+
+	get := func(url string, v any) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if w.Code == http.StatusOK {
+			require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+		}
+		return w.Code
+	}
+
+	var worlds struct{ Worlds []worldInfo }
+	require.Equal(t, http.StatusOK, get("/api/worlds", &worlds))
+	require.Len(t, worlds.Worlds, 1)
+	assert.Equal(t, []dimensionInfo{{Key: "0", ID: "minecraft:overworld", URL: "/0/"}}, worlds.Worlds[0].Dimensions)
+	require.NotNil(t, worlds.Worlds[0].Level)
+	assert.Equal(t, 70, worlds.Worlds[0].Level.SpawnY)
+
+	var info regionsInfo
+	require.Equal(t, http.StatusOK, get("/0/api/regions", &info))
+	assert.Equal(t, converterVersion, info.RenderVersion)
+	assert.Equal(t, &blockPos{-120, 70, 45}, info.Spawn)
+	assert.Nil(t, info.YBounds)
+	assert.Equal(t, []regionInfo{{X: -1, Z: 2, Quadrants: []int{3}}}, info.Regions)
+
+	// once rendered, the Y bounds are known
+	_, source, err := man.changed(path.Join(regionDir, "r.-1.2.mca"))
+	require.NoError(t, err)
+	man.record("r.-1.2.mca", source, -64, 320)
+	require.NoError(t, os.WriteFile(path.Join(path.Dir(man.path), "r.-1.2.0.cmt"), nil, 0644))
+	require.Equal(t, http.StatusOK, get("/0/api/regions", &info))
+	assert.Equal(t, &[2]int{-64, 320}, info.YBounds)
+	assert.True(t, info.Regions[0].Rendered)
+
+	assert.Equal(t, http.StatusNotFound, get("/nope/api/regions", &info))
+}
+
+// preparePack runs render.Prepare on a pack with just a grass block,
+// giving the blockmeta.json the server would load.
+func preparePack(t *testing.T) []byte {
+	dirt := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(dirt, dirt.Bounds(), image.Black, image.Point{}, draw.Src)
+	pack := &rp.ResourceJar{
+		BlockStates:  map[string]*rp.BlockState{},
+		Models:       map[string]*rp.Model{},
+		Textures:     map[string]image.Image{"block/dirt": dirt},
+		Translations: map[string]string{"block.minecraft.grass_block": "Grass Block"},
+		StringCounts: map[string]int{},
+	}
+	bs := &rp.BlockState{}
+	require.NoError(t, json.Unmarshal([]byte(`{"variants": {
+		"snowy=false": {"model": "minecraft:block/grass_block"},
+		"snowy=true": {"model": "minecraft:block/grass_block"}}}`), bs))
+	pack.BlockStates["minecraft:grass_block"] = bs
+	for name, model := range map[string]string{
+		"block/cube": `{"elements": [{"from": [0, 0, 0], "to": [16, 16, 16], "faces": {
+			"down": {"texture": "#all", "cullface": "down"}, "up": {"texture": "#all", "cullface": "up"},
+			"north": {"texture": "#all", "cullface": "north"}, "south": {"texture": "#all", "cullface": "south"},
+			"west": {"texture": "#all", "cullface": "west"}, "east": {"texture": "#all", "cullface": "east"}}}]}`,
+		"block/grass_block": `{"parent": "minecraft:block/cube", "textures": {"all": "minecraft:block/dirt"}}`,
+	} {
+		m := &rp.Model{}
+		require.NoError(t, json.Unmarshal([]byte(model), m))
+		pack.Models["minecraft:"+name] = m
+	}
+	meta, _, _, _ := render.Prepare(pack, "")
+	buf, err := json.Marshal(meta)
+	require.NoError(t, err)
+	return buf
+}
+
+func TestBlockHandler(t *testing.T) {
+	bm, err := region.LoadBlockMapper(preparePack(t))
+	require.NoError(t, err)
+
+	// a real region, for biomes
+	regionDir := t.TempDir()
+	var rw region.Writer
+	require.NoError(t, rw.Add(&region.Chunk{X: 0, Z: 0, DataVersion: 3700, Sections: []region.Section{
+		{Y: 0, Palette: []region.Block{{Name: "minecraft:grass_block"}}, Biome: "minecraft:swamp"},
+		{Y: 1, Palette: []region.Block{{Name: "minecraft:grass_block"}}, Biome: "somemod:weird_biome"},
+	}}))
+	require.NoError(t, rw.WriteFile(path.Join(regionDir, rw.Filename())))
+
+	s := &server{bm: bm, dims: map[string]dimension{
+		"test": {readRegion: region.FakeReadRegion},
+		"0":    newDimension("minecraft:overworld", regionDir),
+	}}
+	r := mux.NewRouter()
+	r.HandleFunc("/{world}/api/block", s.blockHandler)
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	// the fake region is a layer of grass at y=1
+	w := get("/test/api/block?x=5&y=1&z=-3")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var info blockInfo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, blockInfo{
+		blockPos:    blockPos{5, 1, -3},
+		Name:        "minecraft:grass_block",
+		DisplayName: "Grass Block",
+		Properties:  map[string]string{"snowy": "false"},
+		BlockLight:  15,
+		SkyLight:    15,
+	}, info)
+
+	w = get("/test/api/block?x=5&y=2&z=-3")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, "minecraft:air", info.Name)
+
+	assert.Equal(t, http.StatusBadRequest, get("/test/api/block?x=5&y=up&z=-3").Code)
+	assert.Equal(t, http.StatusNotFound, get("/test/api/block?x=5&y=-10&z=-3").Code)
+	assert.Equal(t, http.StatusNotFound, get("/nope/api/block?x=5&y=1&z=-3").Code)
+
+	// unknown biomes are reported as they were named, not as plains
+	for y, biome := range []string{"minecraft:swamp", "somemod:weird_biome"} {
+		w = get(fmt.Sprintf("/0/api/block?x=1&y=%d&z=2", y*16))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+		assert.Equal(t, biome, info.Biome)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config describes the worlds to map, and how to serve them. It's read from
+// a YAML file, or JSON, which is also YAML:
+//
+//	listen: 127.0.0.1:9999
+//	data_dir: dist
+//	worlds:
+//	  - name: survival
+//	    display_name: Survival
+//	    region_dir: /srv/minecraft/world
+//	  - name: creative
+//	    region_dir: /srv/minecraft/creative/region
+//	    prune: false
+type config struct {
+	Listen string `yaml:"listen"`
+	// DataDir holds blockmeta.json, the textures and the viewer, and the
+	// tiles of each map in <data_dir>/<key>/map.
+	DataDir string        `yaml:"data_dir"`
+	Worlds  []worldConfig `yaml:"worlds"`
+}
+
+type worldConfig struct {
+	// Name is used in URLs and output directories. It can be empty if
+	// there's only one world, and then the dimensions' own keys are used.
+	Name        string `yaml:"name"`
+	DisplayName string `yaml:"display_name"`
+	// RegionDir is either a world directory, whose dimensions are all mapped,
+	// or the region directory of a single dimension.
+	RegionDir string `yaml:"region_dir"`
+	// Dimension limits a world directory to one of its dimensions,
+	// like "minecraft:the_nether".
+	Dimension string `yaml:"dimension"`
+	// Prune hides the caves that can't be seen from outside, which it
+	// does unless it's set to false.
+	Prune *bool `yaml:"prune"`
+}
+
+const (
+	defaultListen  = "127.0.0.1:9999"
+	defaultDataDir = "dist"
+)
+
+// reservedNames are the top-level paths of the server, which can't be world names.
+var reservedNames = map[string]bool{
+	"api": true, "events": true, "index.js": true, "map": true, "textures": true,
+}
+
+// loadConfig reads a config file, rejecting unknown fields so typos don't go unnoticed.
+func loadConfig(fname string) (*config, error) {
+	buf, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	return cfg, nil
+}
+
+// singleWorldConfig is the config for a world given on the command line.
+func singleWorldConfig(regionDir, dataDir string, prune bool) (*config, error) {
+	cfg := &config{
+		DataDir: dataDir,
+		Worlds:  []worldConfig{{RegionDir: regionDir, Prune: &prune}},
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *config) validate() error {
+	if cfg.Listen == "" {
+		cfg.Listen = defaultListen
+	}
+	if cfg.DataDir == "" {
+		cfg.DataDir = defaultDataDir
+	}
+	if len(cfg.Worlds) == 0 {
+		return errors.New("no worlds configured")
+	}
+	for i, w := range cfg.Worlds {
+		if w.RegionDir == "" {
+			return fmt.Errorf("world %d has no region_dir", i+1)
+		}
+		if w.Name == "" && len(cfg.Worlds) > 1 {
+			return fmt.Errorf("world %d needs a name, since there's more than one", i+1)
+		}
+		if strings.ContainsAny(w.Name, "/?#%") || reservedNames[w.Name] {
+			return fmt.Errorf("world %d can't be named %q", i+1, w.Name)
+		}
+	}
+	return nil
+}
+
+// dimensions finds the dimensions of each configured world. Each is mapped
+// separately, with the key of the world, or of the world and dimension,
+// like "survival.-1", if the world has several.
+func (cfg *config) dimensions() ([]dimension, error) {
+	var ret []dimension
+	seen := map[string]bool{}
+	for _, w := range cfg.Worlds {
+		dims, err := discoverDimensions(w.RegionDir)
+		if err != nil {
+			return nil, err
+		}
+		if w.Dimension != "" {
+			var found []dimension
+			for _, d := range dims {
+				if d.id == w.Dimension {
+					found = append(found, d)
+				}
+			}
+			if len(found) == 0 {
+				return nil, fmt.Errorf("%s has no dimension %s", w.RegionDir, w.Dimension)
+			}
+			dims = found
+		}
+		for _, d := range dims {
+			d.world = w.Name
+			d.worldDir = worldRoot(w.RegionDir)
+			d.displayName = w.DisplayName
+			d.prune = w.Prune == nil || *w.Prune
+			if w.Name != "" {
+				if len(dims) == 1 {
+					d.key = w.Name
+				} else {
+					d.key = w.Name + "." + d.key
+				}
+			}
+			if seen[d.key] {
+				return nil, fmt.Errorf("more than one map is named %q", d.key)
+			}
+			seen[d.key] = true
+			ret = append(ret, d)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig(t *testing.T) {
+	survival, creative := t.TempDir(), t.TempDir()
+	for _, dir := range []string{
+		filepath.Join(survival, "region"),
+		filepath.Join(survival, "DIM-1", "region"),
+		filepath.Join(creative, "region"),
+	} {
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "r.0.0.mca"), nil, 0644))
+	}
+
+	fname := filepath.Join(t.TempDir(), "worlds.yaml")
+	write := func(s string) {
+		require.NoError(t, os.WriteFile(fname, []byte(s), 0644))
+	}
+	write(`
+data_dir: out
+worlds:
+  - name: survival
+    display_name: Survival
+    region_dir: ` + survival + `
+  - name: creative
+    region_dir: ` + filepath.Join(creative, "region") + `
+    prune: false
+`)
+	cfg, err := loadConfig(fname)
+	require.NoError(t, err)
+	assert.Equal(t, defaultListen, cfg.Listen)
+	assert.Equal(t, "out", cfg.DataDir)
+
+	dims, err := cfg.dimensions()
+	require.NoError(t, err)
+	require.Len(t, dims, 3)
+	assert.Equal(t, "survival.0", dims[0].key)
+	assert.Equal(t, "survival.-1", dims[1].key)
+	assert.Equal(t, "creative", dims[2].key)
+	assert.Equal(t, survival, dims[1].worldDir)
+	assert.Equal(t, creative, dims[2].worldDir)
+	assert.Equal(t, "Survival", dims[0].displayName)
+	assert.True(t, dims[0].prune)
+	assert.False(t, dims[2].prune)
+
+	// JSON works too, and a dimension can be picked out of a world
+	write(`{"listen": ":8080", "worlds": [{"name": "nether", "region_dir": "` + survival + `", "dimension": "minecraft:the_nether"}]}`)
+	cfg, err = loadConfig(fname)
+	require.NoError(t, err)
+	assert.Equal(t, ":8080", cfg.Listen)
+	assert.Equal(t, defaultDataDir, cfg.DataDir)
+	dims, err = cfg.dimensions()
+	require.NoError(t, err)
+	require.Len(t, dims, 1)
+	assert.Equal(t, "nether", dims[0].key)
+	assert.Equal(t, 128, dims[0].ceiling)
+
+	for _, bad := range []string{
+		`worlds: []`,
+		`worlds: [{name: a}]`,
+		`worlds: [{region_dir: a}, {region_dir: b}]`,
+		`worlds: [{name: api, region_dir: a}]`,
+		`worlds: [{name: a/b, region_dir: a}]`,
+		`worlds: [{name: a, regiondir: a}]`,
+	} {
+		write(bad)
+		_, err := loadConfig(fname)
+		assert.Error(t, err, bad)
+	}
+
+	// the keys must be unique
+	cfg = &config{Worlds: []worldConfig{{Name: "a.0", RegionDir: creative}, {Name: "a", RegionDir: survival}}}
+	require.NoError(t, cfg.validate())
+	_, err = cfg.dimensions()
+	assert.Error(t, err)
+
+	// a world on the command line keeps the dimensions' own keys
+	cfg, err = singleWorldConfig(survival, "dist", false)
+	require.NoError(t, err)
+	dims, err = cfg.dimensions()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0", "-1"}, []string{dims[0].key, dims[1].key})
+	assert.False(t, dims[0].prune)
+
+	// and must still be given a region directory
+	_, err = singleWorldConfig("", "dist", true)
+	assert.Error(t, err)
+}
@@ -26,6 +26,10 @@ type regionState struct {
 	rx, rz     int
 	cdata      []region.ChunkDatum
 	cadj       [16][]region.ChunkDatum
+	bedrock    uint16
+	// the world Y just above the dimension's roof, if it has one,
+	// which is left undrawn along with everything above it
+	ceiling int
 
 	nbs [6]uint16
 	nls [6]byte
@@ -33,8 +37,8 @@ type regionState struct {
 }
 
 func (rs *regionState) get(x, y, z int) (uint16, render.Stateval, byte, byte) {
-	if y < 0 {
-		return 7, 0, 0xf, 0 // bedrock
+	if rs.ceiling != 0 && y >= rs.ceiling {
+		return rs.bedrock, 0, 0xf, 0 // over the roof, so its top is hidden
 	}
 	var chunk *region.ChunkDatum
 	if (x|z)&512 != 0 {
@@ -66,7 +70,8 @@ func (rs *regionState) get(x, y, z int) (uint16, render.Stateval, byte, byte) {
 			}
 			ap := path.Join(rs.dir, fmt.Sprintf("r.%d.%d.mca", ox, oz))
 			chunks, err := rs.openRegion(ap, rs.bm, wanted)
-			if err != nil {
+			var chunkErrs region.ChunkErrors
+			if err != nil && !errors.As(err, &chunkErrs) {
 				rs.cadj[key] = make([]region.ChunkDatum, 1024)
 				return 0, 0, 0xf, 0
 			}
@@ -76,7 +81,13 @@ func (rs *regionState) get(x, y, z int) (uint16, render.Stateval, byte, byte) {
 	} else {
 		chunk = &rs.cdata[(x>>4)+(z>>4)*32]
 	}
-	ys := y >> 4
+	if len(chunk.Blocks) == 0 {
+		return 0, 0, 0, 0xf // ungenerated, so its MinY means nothing
+	}
+	ys := (y - chunk.MinY) >> 4
+	if ys < 0 {
+		return rs.bedrock, 0, 0xf, 0 // below the world
+	}
 	if ys >= len(chunk.Blocks) {
 		return 0, 0, 0, 0xf
 	}
@@ -84,21 +95,21 @@ func (rs *regionState) get(x, y, z int) (uint16, render.Stateval, byte, byte) {
 	s := (x & 1) << 2
 	b := chunk.Blocks[ys][o]
 	bs := chunk.BlockState[ys][o]
-	if ys >= len(chunk.Lights) {
-		if ys >= len(chunk.LightsSky) {
-			return b, bs, 0xf, 0xf
-		}
-		return b, bs, 0xf, (chunk.LightsSky[ys][o/2] >> s) & 0xf
-	} else if ys >= len(chunk.LightsSky) {
-		return b, bs, (chunk.Lights[ys][o/2] >> s) & 0xf, 0xf
+	bl, bsl := byte(0xf), byte(0xf)
+	if ys < len(chunk.Lights) && chunk.Lights[ys] != nil {
+		bl = (chunk.Lights[ys][o/2] >> s) & 0xf
+	}
+	if ys < len(chunk.LightsSky) && chunk.LightsSky[ys] != nil {
+		bsl = (chunk.LightsSky[ys][o/2] >> s) & 0xf
 	}
-	return b, bs, (chunk.Lights[ys][o/2] >> s) & 0xf, (chunk.LightsSky[ys][o/2] >> s) & 0xf
+	return b, bs, bl, bsl
 }
 
 func (rs *regionState) getLight(x, y, z int) byte {
 	chunk := &rs.cdata[(x>>4)+(z>>4)*32]
-	ys := y >> 4
-	if ys >= len(chunk.Lights) || ys >= len(chunk.LightsSky) {
+	ys := (y - chunk.MinY) >> 4
+	if ys < 0 || ys >= len(chunk.Lights) || ys >= len(chunk.LightsSky) ||
+		chunk.Lights[ys] == nil || chunk.LightsSky[ys] == nil {
 		return 15
 	}
 	o := ((x & 15) + (z&15)*16 + (y&15)*256) / 2
@@ -118,6 +129,94 @@ func (rs *regionState) neighs(x, y, z int) ([]uint16, []byte, []byte) {
 	return rs.nbs[:], rs.nls[:], rs.nsl[:]
 }
 
+// regionYBounds returns the lowest and highest (exclusive) Y covered by
+// the sections of any chunk in the region.
+func regionYBounds(cdata []region.ChunkDatum) (int, int) {
+	minY, maxY := 0, 0
+	first := true
+	for i := range cdata {
+		c := &cdata[i]
+		if len(c.Blocks) == 0 {
+			continue
+		}
+		top := c.MinY + 16*len(c.Blocks)
+		if first || c.MinY < minY {
+			minY = c.MinY
+		}
+		if first || top > maxY {
+			maxY = top
+		}
+		first = false
+	}
+	return minY, maxY
+}
+
+// biomeMapWidth is the resolution of each tile's biome color map:
+// one entry per 4x4 column, matching how the game stores biomes.
+const biomeMapWidth = 64
+
+// surfaceBiomeMaps builds the biome color map for each quadrant of a region.
+// Each map is three biomeMapWidth-square RGB images stacked vertically,
+// holding the grass, foliage and water colors of each 4x4 column.
+// A column's biome is the one at its highest block.
+func surfaceBiomeMaps(cdata []region.ChunkDatum, bm *region.BlockMapper) [4][]byte {
+	var maps [4][]byte
+	for q := range maps {
+		maps[q] = make([]byte, 3*biomeMapWidth*biomeMapWidth*3)
+	}
+	for cz := range 2 * biomeMapWidth {
+		for cx := range 2 * biomeMapWidth {
+			chunk := &cdata[(cx>>2)+(cz>>2)*32]
+			colors := bm.BiomeColors(surfaceBiome(chunk, (cx&3)*4+2, (cz&3)*4+2))
+			m := maps[cx/biomeMapWidth+2*(cz/biomeMapWidth)]
+			for k, c := range []uint32{colors.Grass, colors.Foliage, colors.Water} {
+				o := ((k*biomeMapWidth+cz%biomeMapWidth)*biomeMapWidth + cx%biomeMapWidth) * 3
+				m[o], m[o+1], m[o+2] = byte(c>>16), byte(c>>8), byte(c)
+			}
+		}
+	}
+	return maps
+}
+
+// surfaceBiome gives the biome of the highest block in a column of a chunk.
+func surfaceBiome(chunk *region.ChunkDatum, x, z int) uint8 {
+	for ys := len(chunk.Blocks) - 1; ys >= 0; ys-- {
+		for y := 15; y >= 0; y-- {
+			if chunk.Blocks[ys][x+z*16+y*256] == 0 {
+				continue
+			}
+			if ys >= len(chunk.Biomes) || chunk.Biomes[ys] == nil {
+				return 0
+			}
+			return chunk.Biomes[ys][(x>>2)+(z>>2)*4+(y>>2)*16]
+		}
+	}
+	return 0
+}
+
+// writePOIs writes the labelled block entities and entities of a region, for the viewer's markers.
+// The file is written even if there are none, so that it isn't considered stale.
+func writePOIs(fname string, cdata []region.ChunkDatum, entities []region.Entity) error {
+	pois := struct {
+		BlockEntities []region.BlockEntity `json:"block_entities"`
+		Entities      []region.Entity      `json:"entities"`
+	}{
+		BlockEntities: []region.BlockEntity{},
+		Entities:      entities,
+	}
+	if pois.Entities == nil {
+		pois.Entities = []region.Entity{}
+	}
+	for i := range cdata {
+		pois.BlockEntities = append(pois.BlockEntities, cdata[i].BlockEntities...)
+	}
+	buf, err := json.Marshal(pois)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fname, buf, 0644)
+}
+
 type scanRegionConfig struct {
 	dir, outdir string
 	file        string
@@ -125,11 +224,19 @@ type scanRegionConfig struct {
 	readRegion  region.ReadRegionFunc
 
 	prune bool
+	// ceiling is the world Y just above the dimension's roof, if it has one.
+	// See dimension.ceiling.
+	ceiling int
+	// strict makes unreadable chunks an error, instead of rendering
+	// the region without them.
+	strict bool
 }
 
-func scanRegion(conf *scanRegionConfig) error {
+// scanRegion renders a region's tiles and points of interest, and returns
+// the Y bounds of its sections.
+func scanRegion(conf *scanRegionConfig) (minY, maxY int, err error) {
 	if !strings.HasSuffix(conf.file, ".mca") {
-		return errors.New("file has wrong suffix (not .mca): " + conf.file)
+		return 0, 0, errors.New("file has wrong suffix (not .mca): " + conf.file)
 	}
 
 	readRegion := region.ReadRegion
@@ -140,18 +247,23 @@ func scanRegion(conf *scanRegionConfig) error {
 	bm := conf.bm
 	regionPath := path.Join(conf.dir, conf.file)
 	cdata, err := readRegion(regionPath, bm, nil)
-	if err != nil {
-		return err
+	var chunkErrs region.ChunkErrors
+	if errors.As(err, &chunkErrs) && !conf.strict {
+		for _, ce := range chunkErrs {
+			log.Printf("%s: skipping %v", conf.file, ce)
+		}
+	} else if err != nil {
+		return 0, 0, err
 	}
 	st, err := os.Stat(regionPath)
 	if err != nil {
-		return err
+		return 0, 0, err
 	}
 	regionSize := st.Size()
 
 	rx, rz, err := region.ParseRegionPath(conf.file)
 	if err != nil {
-		return err
+		return 0, 0, err
 	}
 
 	rs := regionState{
@@ -161,20 +273,30 @@ func scanRegion(conf *scanRegionConfig) error {
 		rz:         rz,
 		cdata:      cdata,
 		openRegion: readRegion,
+		bedrock:    bm.NameToNid["minecraft:bedrock"],
+		ceiling:    conf.ceiling,
 	}
 
 	var chunkVis *blockVis
 
 	if conf.prune {
-		chunkVis = makeBlockvis(cdata, bm, visTriakisOctahedral)
+		chunkVis = makeBlockvis(cdata, bm, visTriakisOctahedral, conf.ceiling)
 		if len(chunkVis.reachable) > 0 {
 			// fmt.Printf("mid reach=%36b pass=%v\n", chunkVis.reachable[16+16*32], chunkVis.isPassable(16, 0, 16))
 		}
 	}
 
-	var bufs [4][render.NumRenderLayers]bytes.Buffer
+	// Instance positions only have 8 bits for Y, so each quadrant's output is split
+	// into bands of 256 blocks, starting from the lowest section in the region.
+	minY, maxY = regionYBounds(cdata)
+	numBands := (maxY - minY + 255) >> 8
+	if numBands == 0 {
+		numBands = 1
+	}
+	bufs := make([][4][render.NumRenderLayers]bytes.Buffer, numBands)
 
 	buf := make([]byte, 64)
+	modelLayer := uint8(render.LayerModel)
 	// TODO: emulate minecraft renderpasses -- solid, cutout (i.e. sprite), translucent (liquid)
 
 	// iterate bottom-to-top so that transparency (i.e. ocean water)
@@ -184,7 +306,12 @@ func scanRegion(conf *scanRegionConfig) error {
 
 	blockCounts := make([]int, len(bm.Tmpl))
 
-	for y := 0; y <= 320; y++ {
+	for y := minY; y < maxY; y++ {
+		if conf.ceiling != 0 && y >= conf.ceiling {
+			break
+		}
+		band := (y - minY) >> 8
+		bandY := y - minY - band<<8
 		for z := 0; z < 512; z++ {
 			// skipping empty rows is a significant speedup for empty regions
 			minX := 0
@@ -202,7 +329,7 @@ func scanRegion(conf *scanRegionConfig) error {
 				}
 
 				chunk := &cdata[(x>>4)+(z>>4)*32]
-				if len(chunk.Blocks) <= y>>4 {
+				if ys := (y - chunk.MinY) >> 4; ys < 0 || ys >= len(chunk.Blocks) {
 					continue
 				}
 
@@ -258,20 +385,36 @@ func scanRegion(conf *scanRegionConfig) error {
 						layer = bm.Layer[b][0]
 					}
 
-					pos := uint32((x&255)<<16 | (z&255)<<8 | y)
-					blen := 0
+					pos := uint32((x&255)<<16 | (z&255)<<8 | bandY)
+					inst := buf[:0]
+
+					// model elements share the brightest light of the block's faces
+					modelLight := uint32(0)
+					if layer == modelLayer {
+						for i := range 6 {
+							modelLight = max(modelLight, sideLight>>(4*i)&0xf)
+						}
+					}
 
 					for i := 0; i < len(tmpl); i += 2 {
 						// x: 8b z: 8b y: 8b   8+8+8=24b
-						if sideVis&tmpl[i+1] != 0 {
-							binary.LittleEndian.PutUint32(buf[blen:], tmpl[i]|pos)
-							binary.LittleEndian.PutUint32(buf[blen+4:], tmpl[i+1]&^0b111111|sideLight<<6|(sideVis&tmpl[i+1]))
-							blen += 8
+						if layer == modelLayer {
+							// faces that don't touch a neighbor are always drawn
+							faces := (sideVis&tmpl[i+1] | tmpl[i+1]>>6) & 0b111111
+							if faces != 0 {
+								inst = binary.LittleEndian.AppendUint32(inst, tmpl[i]|pos)
+								inst = binary.LittleEndian.AppendUint32(inst, tmpl[i+1]&^0xfff|modelLight<<6|faces)
+							}
+						} else if sideVis&tmpl[i+1] != 0 {
+							inst = binary.LittleEndian.AppendUint32(inst, tmpl[i]|pos)
+							inst = binary.LittleEndian.AppendUint32(inst, tmpl[i+1]&^0b111111|sideLight<<6|(sideVis&tmpl[i+1]))
 						}
 					}
-					if blen > 0 {
-						bufs[x>>8+2*(z>>8)][layer].Write(buf[:blen])
+					if len(inst) > 0 {
+						bufs[band][x>>8+2*(z>>8)][layer].Write(inst)
 					}
+					// models can have more instances than fit in buf
+					buf = inst[:cap(inst)]
 				}
 			}
 		}
@@ -281,32 +424,47 @@ func scanRegion(conf *scanRegionConfig) error {
 		os.MkdirAll(conf.outdir, 0755)
 	}
 
+	biomeMaps := surfaceBiomeMaps(cdata, bm)
+
 	nameBase := path.Join(conf.outdir, strings.TrimSuffix(path.Base(conf.file), ".mca"))
 	outLen := 0
 	outLenComp := int64(0)
 	// note: the gzip.BestCompression level is 4x slower and <1% smaller for our files
 	outComp := gzip.NewWriter(nil)
-	for bi := range bufs {
-		bs := &bufs[bi]
+	for bi := range 4 {
 		out, err := os.Create(fmt.Sprintf("%s.%d.cmt", nameBase, bi))
 		if err != nil {
 			log.Println("unable to open dest file")
-			return err
+			return 0, 0, err
 		}
 
 		outComp.Reset(out)
-		outComp.Write([]byte("COMTE00\n"))
+		outComp.Write([]byte("COMTE01\n"))
 
-		var header (struct {
-			Layers [render.NumRenderLayers]struct {
-				Length int    `json:"length"`
-				Name   string `json:"name"`
-			} `json:"layers"`
-		})
-
-		for i, obuf := range bs {
-			header.Layers[i].Length = obuf.Len()
-			header.Layers[i].Name = render.LayerNames[i]
+		type layerHeader struct {
+			Length int    `json:"length"`
+			Name   string `json:"name"`
+			Y      int    `json:"y"`
+		}
+		type biomeHeader struct {
+			Length int `json:"length"`
+			Width  int `json:"width"`
+		}
+		// the biome map comes first in the data, followed by the layers
+		var header struct {
+			Biomes biomeHeader   `json:"biomes"`
+			Layers []layerHeader `json:"layers"`
+		}
+		header.Biomes = biomeHeader{Length: len(biomeMaps[bi]), Width: biomeMapWidth}
+
+		for band := range bufs {
+			for i, obuf := range bufs[band][bi] {
+				header.Layers = append(header.Layers, layerHeader{
+					Length: obuf.Len(),
+					Name:   render.LayerNames[i],
+					Y:      minY + band<<8,
+				})
+			}
 		}
 		headerJSON, err := json.Marshal(header)
 		if err != nil {
@@ -315,10 +473,13 @@ func scanRegion(conf *scanRegionConfig) error {
 		binary.LittleEndian.PutUint32(buf, uint32(len(headerJSON)))
 		outComp.Write(buf[:4])
 		outComp.Write(headerJSON)
+		outComp.Write(biomeMaps[bi])
 
-		for _, obuf := range bs {
-			outLen += obuf.Len()
-			outComp.Write(obuf.Bytes())
+		for band := range bufs {
+			for _, obuf := range bufs[band][bi] {
+				outLen += obuf.Len()
+				outComp.Write(obuf.Bytes())
+			}
 		}
 		outComp.Flush()
 		outComp.Close()
@@ -327,6 +488,23 @@ func scanRegion(conf *scanRegionConfig) error {
 		out.Close()
 	}
 
+	var entities []region.Entity
+	if conf.readRegion == nil {
+		// since 1.17, entities are kept in their own region files
+		entities, err = region.ReadEntities(region.EntityRegionPath(regionPath))
+		if errors.As(err, &chunkErrs) && !conf.strict {
+			for _, ce := range chunkErrs {
+				log.Printf("%s: skipping entities of %v", conf.file, ce)
+			}
+		} else if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := writePOIs(nameBase+".poi.json", cdata, entities); err != nil {
+		return 0, 0, err
+	}
+
 	fmt.Println(conf.dir, conf.file, regionSize/1024, "KiB region,", outLen/1024, "KiB =>", outLenComp/1024, "KiB gzipped tiles")
 
 	if false {
@@ -349,5 +527,5 @@ func scanRegion(conf *scanRegionConfig) error {
 		}
 	}
 
-	return err
+	return minY, maxY, err
 }
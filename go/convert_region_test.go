@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/rmmh/cubeographer/go/region"
+	"github.com/rmmh/cubeographer/go/render"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// tileLayer is one layer of a .cmt tile, with its instances decoded.
+type tileLayer struct {
+	Name      string
+	Y         int
+	Instances [][2]uint32
+}
+
+// readTile decodes a COMTE tile written by scanRegion, skipping the biome map.
+func readTile(t *testing.T, fname string) []tileLayer {
+	f, err := os.Open(fname)
+	require.NoError(t, err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t, "COMTE01\n", string(data[:8]))
+	hdrLen := int(binary.LittleEndian.Uint32(data[8:]))
+	var header struct {
+		Biomes struct {
+			Length int `json:"length"`
+		} `json:"biomes"`
+		Layers []struct {
+			Length int    `json:"length"`
+			Name   string `json:"name"`
+			Y      int    `json:"y"`
+		} `json:"layers"`
+	}
+	require.NoError(t, json.Unmarshal(data[12:12+hdrLen], &header))
+	data = data[12+hdrLen+header.Biomes.Length:]
+
+	var layers []tileLayer
+	for _, l := range header.Layers {
+		require.Zero(t, l.Length%8)
+		layer := tileLayer{Name: l.Name, Y: l.Y}
+		for i := 0; i < l.Length; i += 8 {
+			layer.Instances = append(layer.Instances, [2]uint32{
+				binary.LittleEndian.Uint32(data[i:]), binary.LittleEndian.Uint32(data[i+4:])})
+		}
+		layers = append(layers, layer)
+		data = data[l.Length:]
+	}
+	require.Empty(t, data)
+	return layers
+}
+
+// formatTiles lists the non-empty layers of a region's four tiles, with one line
+// for each instance, giving its world position, texture, visible faces,
+// the light of each face and the remaining template flags. MODEL instances
+// give their element and their one light instead.
+func formatTiles(t *testing.T, outDir, file string) string {
+	rx, rz, err := region.ParseRegionPath(file)
+	require.NoError(t, err)
+	var sb strings.Builder
+	for q := range 4 {
+		fname := fmt.Sprintf("%s.%d.cmt", path.Join(outDir, strings.TrimSuffix(file, ".mca")), q)
+		for _, l := range readTile(t, fname) {
+			if len(l.Instances) == 0 {
+				continue
+			}
+			fmt.Fprintf(&sb, "quadrant %d %s y=%d\n", q, l.Name, l.Y)
+			for _, inst := range l.Instances {
+				x := rx*512 + q&1*256 + int(inst[0]>>16&255)
+				z := rz*512 + q>>1*256 + int(inst[0]>>8&255)
+				y := l.Y + int(inst[0]&255)
+				faces := []byte("------")
+				for i, c := range "WESNUD" {
+					if inst[1]&(1<<i) != 0 {
+						faces[i] = byte(c)
+					}
+				}
+				if l.Name == render.LayerNames[render.LayerModel] {
+					fmt.Fprintf(&sb, "  %d %d %d tex=%d element=%d faces=%s light=%x flags=%02b\n",
+						x, y, z, inst[0]>>24, inst[1]>>12&0xffff, faces, inst[1]>>6&0xf, inst[1]>>30)
+					continue
+				}
+				fmt.Fprintf(&sb, "  %d %d %d tex=%d faces=%s light=%06x flags=%02b\n",
+					x, y, z, inst[0]>>24, faces, inst[1]>>6&0xffffff, inst[1]>>30)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// sectionBuilder fills in a section's palette and blocks.
+type sectionBuilder struct {
+	region.Section
+}
+
+func newSection(y int) *sectionBuilder {
+	return &sectionBuilder{region.Section{Y: y, Palette: []region.Block{{Name: "minecraft:air"}}, Blocks: make([]uint16, 4096)}}
+}
+
+// set places a block at section-relative coordinates.
+func (s *sectionBuilder) set(x, y, z int, b region.Block) {
+	idx := -1
+	for i, p := range s.Palette {
+		if p.Name == b.Name && fmt.Sprint(p.Props) == fmt.Sprint(b.Props) {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		idx = len(s.Palette)
+		s.Palette = append(s.Palette, b)
+	}
+	s.Blocks[x+z*16+y*256] = uint16(idx)
+}
+
+// fill places a block over a box, inclusive of both corners.
+func (s *sectionBuilder) fill(x0, y0, z0, x1, y1, z1 int, b region.Block) {
+	for y := y0; y <= y1; y++ {
+		for z := z0; z <= z1; z++ {
+			for x := x0; x <= x1; x++ {
+				s.set(x, y, z, b)
+			}
+		}
+	}
+}
+
+var goldenScenes = []struct {
+	name    string
+	ceiling int // see scanRegionConfig.ceiling
+	chunks  func() []*region.Chunk
+}{
+	{"blocks", 0, func() []*region.Chunk {
+		stone := region.Block{Name: "minecraft:stone"}
+		sec := newSection(4)
+		sec.fill(0, 0, 0, 15, 0, 2, stone)
+		for i, b := range []region.Block{
+			{Name: "minecraft:glass"},
+			{Name: "minecraft:water", Props: map[string]string{"level": "0"}},
+			{Name: "minecraft:grass_block", Props: map[string]string{"snowy": "false"}},
+			{Name: "minecraft:poppy"},
+			{Name: "minecraft:wheat", Props: map[string]string{"age": "7"}},
+			{Name: "minecraft:oak_log", Props: map[string]string{"axis": "x"}},
+			{Name: "minecraft:oak_log", Props: map[string]string{"axis": "z"}},
+			{Name: "mod:machine"},
+		} {
+			sec.set(1+i*2, 1, 1, b)
+		}
+		sec.set(2, 1, 2, region.Block{Name: "minecraft:oak_slab", Props: map[string]string{"type": "bottom"}})
+		// no sky, but some block light above the row
+		sec.SkyLight = make([]byte, 2048)
+		sec.BlockLight = make([]byte, 2048)
+		for i := 2 * 256; i < 3*256; i += 2 {
+			sec.BlockLight[i/2] = 0x7c
+		}
+		// a 1.14 chunk, from when sections were inside Level, in the last quadrant
+		old := newSection(0)
+		old.fill(0, 0, 0, 2, 0, 2, stone)
+		old.set(1, 1, 1, region.Block{Name: "minecraft:glass"})
+		return []*region.Chunk{
+			{X: 0, Z: 0, DataVersion: 3700, Sections: []region.Section{sec.Section}},
+			{X: 20, Z: 21, DataVersion: 1976, Sections: []region.Section{old.Section}},
+		}
+	}},
+	{"cave", 0, func() []*region.Chunk {
+		// a stone cube with a sealed pocket holding a glass block
+		sec := newSection(1)
+		sec.fill(2, 2, 2, 7, 7, 7, region.Block{Name: "minecraft:stone"})
+		sec.fill(4, 4, 4, 5, 5, 5, region.Block{Name: "minecraft:air"})
+		sec.set(4, 4, 4, region.Block{Name: "minecraft:glass"})
+		return []*region.Chunk{{X: -31, Z: 2, DataVersion: 3700, Sections: []region.Section{sec.Section}}}
+	}},
+	{"edge", 0, func() []*region.Chunk {
+		// blocks below y=0 beside an ungenerated chunk and an absent region
+		// keep their outer faces, like the block above them
+		stone := region.Block{Name: "minecraft:stone"}
+		low, high := newSection(-1), newSection(0)
+		low.set(0, 15, 0, stone)
+		high.set(0, 0, 2, stone)
+		edge := newSection(-1)
+		edge.set(15, 15, 0, stone)
+		return []*region.Chunk{
+			{X: 1, Z: 0, DataVersion: 3700, Sections: []region.Section{low.Section, high.Section}},
+			{X: 31, Z: 0, DataVersion: 3700, Sections: []region.Section{edge.Section}},
+		}
+	}},
+	{"ceiling", 16, func() []*region.Chunk {
+		// a roof with a sealed pocket just under its top, which is the first
+		// gap in those columns, over open space holding a glass block
+		stone, glass := region.Block{Name: "minecraft:stone"}, region.Block{Name: "minecraft:glass"}
+		sec := newSection(0)
+		sec.fill(0, 10, 0, 5, 15, 5, stone)
+		sec.fill(2, 12, 2, 3, 13, 3, region.Block{Name: "minecraft:air"})
+		sec.set(2, 12, 2, glass)
+		sec.set(12, 3, 12, glass)
+		return []*region.Chunk{{X: 0, Z: 0, DataVersion: 3700, Sections: []region.Section{sec.Section}}}
+	}},
+}
+
+// TestScanRegionGolden renders small synthetic regions and compares the
+// instances in their tiles with testdata/scan. Run with -update to accept changes.
+func TestScanRegionGolden(t *testing.T) {
+	bm := testBlockMapper(t)
+	for _, scene := range goldenScenes {
+		for _, prune := range []bool{false, true} {
+			name := scene.name
+			if prune {
+				name += "_pruned"
+			}
+			t.Run(name, func(t *testing.T) {
+				var w region.Writer
+				for _, c := range scene.chunks() {
+					c.Modified = time.Unix(1700000000, 0)
+					require.NoError(t, w.Add(c))
+				}
+				regionDir, outDir := t.TempDir(), t.TempDir()
+				require.NoError(t, w.WriteFile(path.Join(regionDir, w.Filename())))
+
+				minY, maxY, err := scanRegion(&scanRegionConfig{
+					dir: regionDir, outdir: outDir, file: w.Filename(), bm: bm, prune: prune, ceiling: scene.ceiling, strict: true})
+				require.NoError(t, err)
+				got := fmt.Sprintf("%s y=%d..%d\n%s", w.Filename(), minY, maxY, formatTiles(t, outDir, w.Filename()))
+
+				golden := path.Join("testdata", "scan", name+".golden")
+				if *updateGolden {
+					require.NoError(t, os.MkdirAll(path.Dir(golden), 0755))
+					require.NoError(t, os.WriteFile(golden, []byte(got), 0644))
+				}
+				want, err := os.ReadFile(golden)
+				require.NoError(t, err, "run with -update to create it")
+				require.Equal(t, string(want), got)
+			})
+		}
+	}
+}
+
+// TestScanRegionBands converts a chunk spanning the full 1.18 world height,
+// which takes two 256-block bands of instances in each tile.
+func TestScanRegionBands(t *testing.T) {
+	bm := testBlockMapper(t)
+	stone := region.Block{Name: "minecraft:stone"}
+	chunk := &region.Chunk{X: 0, Z: 0, DataVersion: 3700, Modified: time.Unix(1700000000, 0)}
+	// a block at the bottom and top of each band, with a gap of missing sections
+	for _, y := range []int{-64, 191, 192, 319} {
+		sec := newSection(y >> 4)
+		sec.set(0, y&15, 0, stone)
+		chunk.Sections = append(chunk.Sections, sec.Section)
+	}
+	var w region.Writer
+	require.NoError(t, w.Add(chunk))
+	regionDir, outDir := t.TempDir(), t.TempDir()
+	require.NoError(t, w.WriteFile(path.Join(regionDir, w.Filename())))
+
+	minY, maxY, err := scanRegion(&scanRegionConfig{dir: regionDir, outdir: outDir, file: w.Filename(), bm: bm, strict: true})
+	require.NoError(t, err)
+	require.Equal(t, -64, minY)
+	require.Equal(t, 320, maxY)
+
+	layers := readTile(t, path.Join(outDir, "r.0.0.0.cmt"))
+	require.Len(t, layers, 2*int(render.NumRenderLayers))
+	bandYs := map[int][]int{}
+	for i, l := range layers {
+		require.Equal(t, -64+i/int(render.NumRenderLayers)*256, l.Y)
+		for _, inst := range l.Instances {
+			y := int(inst[0] & 255)
+			if !slices.Contains(bandYs[l.Y], y) {
+				bandYs[l.Y] = append(bandYs[l.Y], y)
+			}
+		}
+	}
+	require.Equal(t, map[int][]int{-64: {0, 255}, 192: {0, 127}}, bandYs)
+
+	// below a chunk's lowest section is bedrock, but below an
+	// ungenerated chunk, and in missing sections, is air
+	cdata, err := region.ReadRegion(path.Join(regionDir, w.Filename()), bm, nil)
+	require.NoError(t, err)
+	rs := regionState{bm: bm, cdata: cdata, bedrock: bm.NameToNid["minecraft:bedrock"]}
+	b, _, _, _ := rs.get(0, -65, 0)
+	require.Equal(t, rs.bedrock, b)
+	b, _, _, _ = rs.get(16, -65, 0)
+	require.Zero(t, b)
+	b, _, _, _ = rs.get(0, 0, 0)
+	require.Zero(t, b)
+	b, _, _, _ = rs.get(0, 192, 0)
+	require.Equal(t, bm.NameToNid["minecraft:stone"], b)
+}
+
+func TestScanRegionFromWriter(t *testing.T) {
+	bm := testBlockMapper(t)
+	regionDir, outDir := t.TempDir(), t.TempDir()
+
+	// a lone block at 1,65,2 and a 2x1x1 bar at 500,0,300, in the first and last quadrants
+	blocks := make([]uint16, 4096)
+	blocks[1+2*16+1*256] = 1
+	bar := make([]uint16, 4096)
+	bar[4+12*16] = 1
+	bar[5+12*16] = 1
+	var w region.Writer
+	palette := []region.Block{{Name: "air"}, {Name: "mod:pipe"}} // drawn as the unknown block
+	require.NoError(t, w.Add(&region.Chunk{
+		X: 0, Z: 0, DataVersion: 3700, Modified: time.Unix(1700000000, 0),
+		Sections: []region.Section{{Y: 4, Palette: palette, Blocks: blocks}},
+	}))
+	require.NoError(t, w.Add(&region.Chunk{
+		X: 31, Z: 18, DataVersion: 3700, Modified: time.Unix(1700000000, 0),
+		Sections: []region.Section{{Y: 0, Palette: palette, Blocks: bar}},
+	}))
+	require.NoError(t, w.WriteFile(path.Join(regionDir, w.Filename())))
+
+	minY, maxY, err := scanRegion(&scanRegionConfig{dir: regionDir, outdir: outDir, file: "r.0.0.mca", bm: bm, strict: true})
+	require.NoError(t, err)
+	require.Equal(t, 0, minY)
+	require.Equal(t, 80, maxY)
+
+	// the unknown block's template is one instance for all six faces
+	faces := func(quadrant int) int {
+		n := 0
+		for _, l := range readTile(t, path.Join(outDir, fmt.Sprintf("r.0.0.%d.cmt", quadrant))) {
+			if l.Name == render.LayerNames[render.LayerCubeFallback] {
+				n += len(l.Instances)
+			}
+		}
+		return n
+	}
+	require.Equal(t, 1, faces(0))
+	require.Equal(t, 0, faces(1))
+	require.Equal(t, 0, faces(2))
+	require.Equal(t, 2, faces(3))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// etagCache remembers the content hashes of served files, so they're
+// only rehashed when the file changes.
+type etagCache struct {
+	lock    sync.Mutex
+	entries map[string]etagEntry
+}
+
+type etagEntry struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+func newEtagCache() *etagCache {
+	return &etagCache{entries: map[string]etagEntry{}}
+}
+
+// get returns a strong ETag for the current contents of a file.
+func (c *etagCache) get(fname string) (string, error) {
+	st, err := os.Stat(fname)
+	if err != nil {
+		return "", err
+	}
+	c.lock.Lock()
+	e, ok := c.entries[fname]
+	c.lock.Unlock()
+	if ok && e.modTime.Equal(st.ModTime()) && e.size == st.Size() {
+		return e.etag, nil
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	e = etagEntry{
+		modTime: st.ModTime(),
+		size:    st.Size(),
+		etag:    `"` + hex.EncodeToString(h.Sum(nil)[:12]) + `"`,
+	}
+	c.lock.Lock()
+	c.entries[fname] = e
+	c.lock.Unlock()
+	return e.etag, nil
+}
+
+// acceptsEncoding reports whether a request's Accept-Encoding allows the given
+// coding, honoring q=0 and the * wildcard.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case coding:
+			return q > 0
+		case "*":
+			wildcard = q > 0
+		}
+	}
+	return wildcard
+}
+
+// etagMatches reports whether a request's If-None-Match includes etag.
+func etagMatches(r *http.Request, etag string) bool {
+	for _, m := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		m = strings.TrimPrefix(strings.TrimSpace(m), "W/")
+		if m == etag || m == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Tiles, POI JSON and the page itself change along with the world, so the
+// browser revalidates them on each visit. Textures only change when the
+// resource pack is regenerated, so it keeps them for a while without asking.
+const (
+	cacheRevalidate = "no-cache"
+	cacheTextures   = "max-age=3600"
+)
+
+// serveFile serves a file with a strong ETag and the given Cache-Control.
+// Once it's expired, the browser only downloads it again if it's changed.
+func (s *server) serveFile(w http.ResponseWriter, r *http.Request, fname, cacheControl string) {
+	w.Header().Set("Cache-Control", cacheControl)
+	if etag, err := s.etags.get(fname); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+	// ServeFile handles If-None-Match and If-Modified-Since
+	http.ServeFile(w, r, fname)
+}
+
+// serveGzipped serves a file that's stored gzipped, like the .cmt tiles. Clients
+// that accept gzip get it as-is, and it's decompressed for the ones that don't.
+// The two versions get different ETags, since their bytes differ.
+func (s *server) serveGzipped(w http.ResponseWriter, r *http.Request, fname string) {
+	w.Header().Set("Cache-Control", cacheRevalidate)
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	etag, err := s.etags.get(fname)
+	if err != nil {
+		http.ServeFile(w, r, fname) // for the error response
+		return
+	}
+
+	if acceptsEncoding(r, "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("ETag", etag)
+		http.ServeFile(w, r, fname)
+		return
+	}
+
+	etag = strings.TrimSuffix(etag, `"`) + `-identity"`
+	w.Header().Set("ETag", etag)
+	if etagMatches(r, etag) {
+		// skip decompressing just to find out it's not needed
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		http.ServeFile(w, r, fname)
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		http.Error(w, "corrupt tile: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	buf, err := io.ReadAll(zr)
+	if err != nil {
+		http.Error(w, "corrupt tile: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, fname, st.ModTime(), bytes.NewReader(buf))
+}
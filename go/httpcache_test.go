@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeTiles(t *testing.T) {
+	dataDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(dataDir, "0", "map"), 0755))
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("COMTE01\ntile data"))
+	zw.Close()
+	require.NoError(t, os.WriteFile(path.Join(dataDir, "0", "map", "r.0.0.0.cmt"), gz.Bytes(), 0644))
+
+	s := &server{dataDir: dataDir, etags: newEtagCache()}
+	get := func(acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/0/map/r.0.0.0.cmt", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		s.mapHandler(w, req)
+		return w
+	}
+
+	w := get("gzip, deflate", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, gz.Bytes(), w.Body.Bytes())
+	gzEtag := w.Header().Get("ETag")
+	assert.NotEmpty(t, gzEtag)
+
+	// curl and friends get it decompressed, with a different ETag
+	for _, ae := range []string{"", "identity", "gzip;q=0, br"} {
+		w = get(ae, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"), ae)
+		assert.Equal(t, "COMTE01\ntile data", w.Body.String(), ae)
+		assert.NotEqual(t, gzEtag, w.Header().Get("ETag"))
+	}
+	identityEtag := w.Header().Get("ETag")
+
+	assert.Equal(t, http.StatusNotModified, get("gzip", gzEtag).Code)
+	assert.Equal(t, http.StatusNotModified, get("", identityEtag).Code)
+	assert.Equal(t, http.StatusOK, get("", gzEtag).Code)
+
+	// a changed tile gets a new ETag
+	require.NoError(t, os.WriteFile(path.Join(dataDir, "0", "map", "r.0.0.0.cmt"), append(gz.Bytes(), 0), 0644))
+	w = get("gzip", gzEtag)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, gzEtag, w.Header().Get("ETag"))
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	for ae, want := range map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"deflate, gzip;q=1.0": true,
+		"GZIP":                true,
+		"gzip;q=0":            false,
+		"*":                   true,
+		"*;q=0.5, br":         true,
+		"gzip;q=0, *":         false,
+		"x-gzip":              false,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", ae)
+		assert.Equal(t, want, acceptsEncoding(req, "gzip"), ae)
+	}
+}
+
+func TestServeTextures(t *testing.T) {
+	dataDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(dataDir, "textures"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(dataDir, "textures", "atlas0.png"), []byte("png"), 0644))
+	require.NoError(t, os.WriteFile(path.Join(dataDir, "index.html"), []byte("<html>"), 0644))
+
+	s := &server{dataDir: dataDir, etags: newEtagCache()}
+	// textures are kept for a while, and revalidated with their ETag after that
+	req := httptest.NewRequest("GET", "/textures/atlas0.png", nil)
+	w := httptest.NewRecorder()
+	s.textureHandler(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Cache-Control"), "max-age=")
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	req = httptest.NewRequest("GET", "/textures/atlas0.png", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	s.textureHandler(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// the page is revalidated on every visit
+	w = httptest.NewRecorder()
+	s.indexHandler(w, httptest.NewRequest("GET", "/0/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+}
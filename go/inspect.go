@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rmmh/cubeographer/go/region"
+)
+
+// inspectRegion describes where each chunk of a region file is stored,
+// and when it was last saved.
+func inspectRegion(w io.Writer, fname string) error {
+	rx, rz, err := region.ParseRegionPath(fname)
+	if err != nil {
+		return err
+	}
+	hdr, err := region.ReadRegionHeader(fname)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	chunks, sectors := 0, 0
+	for _, offset := range hdr.Offsets {
+		if offset != 0 {
+			chunks++
+			sectors += int(offset & 0xff)
+		}
+	}
+	fmt.Fprintf(w, "%s: region %d,%d, %d chunks in %d sectors\n", fname, rx, rz, chunks, sectors)
+	if chunks == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "%6s %6s %6s %8s %7s  %s\n", "index", "x", "z", "sector", "sectors", "saved")
+	for i, offset := range hdr.Offsets {
+		if offset == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%6d %6d %6d %8d %7d  %s\n", i, rx*32+i&31, rz*32+i>>5,
+			offset>>8, offset&0xff, time.Unix(int64(hdr.Timestamps[i]), 0).UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// inspectChunk prints the NBT of a chunk, or just the tags at the given paths.
+func inspectChunk(w io.Writer, fname string, cx, cz int, paths []region.NbtPath, asJSON bool) error {
+	nbt, err := region.ReadChunkNBT(fname, cx, cz)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return region.PrintNBT(w, nbt, paths, asJSON)
+}
@@ -16,22 +16,27 @@ import (
 	rp "github.com/rmmh/cubeographer/go/resourcepack"
 )
 
-var clientJarPath = flag.String("jar", "", "use specific client jar")
-var clientJarVersion = flag.String("version", "latest", "specify client version to download")
+var clientJarPath, clientJarVersion, genDebug string
 
-var genDebug = flag.String("gendebug", "", "debug specific block name (or \"all\")")
+// addGenFlags adds the flags for generating blockmeta and the atlases to the
+// subcommands that might need to, when they haven't been generated yet.
+func addGenFlags(fs *flag.FlagSet) {
+	fs.StringVar(&clientJarPath, "jar", "", "use specific client jar")
+	fs.StringVar(&clientJarVersion, "version", "latest", "specify client version to download")
+	fs.StringVar(&genDebug, "gendebug", "", "debug specific block name (or \"all\", or \"force\" to regenerate)")
+}
 
 func generate(outDir string) {
 	fmt.Println("generating textures")
 	jarPath := path.Join(outDir, "client.jar")
-	if *clientJarPath != "" {
-		if _, err := os.Stat(*clientJarPath); err == nil {
-			jarPath = *clientJarPath
+	if clientJarPath != "" {
+		if _, err := os.Stat(clientJarPath); err == nil {
+			jarPath = clientJarPath
 		}
 	}
 	if _, err := os.Stat(jarPath); err != nil {
 		fmt.Println("downloading minecraft client jar")
-		err := rp.DownloadMinecraftJar(jarPath, *clientJarVersion)
+		err := rp.DownloadMinecraftJar(jarPath, clientJarVersion)
 		if err != nil {
 			fmt.Println("unable to downlaod minecraft jar:", err)
 		}
@@ -46,7 +51,7 @@ func generate(outDir string) {
 		log.Fatal(err)
 	}
 
-	meta, atlases := render.Prepare(pack, *genDebug)
+	meta, atlases, tints, elements := render.Prepare(pack, genDebug)
 
 	os.MkdirAll(path.Join(outDir, "textures"), 0755)
 
@@ -62,6 +67,28 @@ func generate(outDir string) {
 		}
 	}
 
+	for layer, tint := range tints {
+		f, err := os.Create(path.Join(outDir, "textures", fmt.Sprintf("tints%d.png", layer)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		err = png.Encode(f, tint)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	f, err := os.Create(path.Join(outDir, "textures", "elements.png"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	err = png.Encode(f, elements)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	layerCounts := map[int]int{}
 
 	// Wipe unneeded texture references, and count layers for each block
@@ -83,7 +110,7 @@ func generate(outDir string) {
 		fmt.Println("layer", render.LayerNames[i], layerCounts[i])
 	}
 
-	f, err := os.Create(path.Join(outDir, "blockmeta.json"))
+	f, err = os.Create(path.Join(outDir, "blockmeta.json"))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -1,9 +1,10 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 	"log"
 	"os"
 	"path"
@@ -11,7 +12,9 @@ import (
 	"runtime"
 	"runtime/pprof"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/rmmh/cubeographer/go/region"
 	"github.com/samber/lo"
@@ -25,16 +28,11 @@ func makeBlockMapper(outDir string) (*region.BlockMapper, error) {
 	return region.LoadBlockMapper(blockmeta)
 }
 
-func convert(numProcs int, regionDir, outDir string, filters []string, prune bool) {
-	files, err := ioutil.ReadDir(regionDir)
-	if err != nil {
-		log.Fatal(err)
-	}
-	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
-
-	dataDir := path.Join(outDir, "..")
+// loadBlockMapper loads blockmeta.json from the data directory,
+// generating it first if it's missing.
+func loadBlockMapper(dataDir string) *region.BlockMapper {
 	bm, err := makeBlockMapper(dataDir)
-	if err != nil || *genDebug == "force" {
+	if err != nil || genDebug == "force" {
 		log.Println("regenerating block mapping")
 		generate(dataDir)
 		bm, err = makeBlockMapper(dataDir)
@@ -42,97 +40,381 @@ func convert(numProcs int, regionDir, outDir string, filters []string, prune boo
 			log.Fatal(err)
 		}
 	}
+	return bm
+}
+
+// convert renders the regions of each configured map for web display,
+// to <data_dir>/<key>/map, like serve does. Only the regions whose names
+// match every filter are converted, and those that haven't changed since
+// they were last converted are skipped, unless force is set.
+func convert(cfg *config, numProcs int, filters []*regexp.Regexp, strict, force bool) {
+	dims, err := cfg.dimensions()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bm := loadBlockMapper(cfg.DataDir)
+	for _, worldDir := range lo.Uniq(lo.Map(dims, func(d dimension, _ int) string { return d.worldDir })) {
+		checkWorldVersion(bm, worldDir)
+	}
 
-	work := make(chan os.FileInfo)
+	type convertItem struct {
+		dim      dimension
+		file     string
+		manifest *manifest
+		source   *manifestRegion
+	}
+	work := make(chan convertItem)
 	var wg sync.WaitGroup
 	for i := 0; i < numProcs; i++ {
 		go func() {
-			for file := range work {
-				err = scanRegion(&scanRegionConfig{
-					dir:    regionDir,
-					outdir: outDir,
-					file:   file.Name(),
-					bm:     bm,
-					prune:  prune,
+			for item := range work {
+				minY, maxY, err := scanRegion(&scanRegionConfig{
+					dir:     item.dim.regionDir,
+					outdir:  path.Join(cfg.DataDir, item.dim.key, "map"),
+					file:    item.file,
+					bm:      bm,
+					prune:   item.dim.prune,
+					ceiling: item.dim.ceiling,
+					strict:  strict,
 				})
 				if err != nil {
 					log.Fatal(err)
 				}
+				if item.source != nil {
+					item.manifest.record(item.file, item.source, minY, maxY)
+				}
 				wg.Done()
 			}
 		}()
 	}
 
-	filtersRe := lo.Map(filters, func(f string, idx int) *regexp.Regexp {
-		return regexp.MustCompile(f)
-	})
+	for _, dim := range dims {
+		log.Printf("converting %s (%s) from %s", dim.key, dim.id, dim.regionDir)
+		files, err := os.ReadDir(dim.regionDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
 
-	for _, file := range files {
-		if len(filters) > 0 {
-			good := 0
-			for _, filter := range filtersRe {
-				if filter.MatchString(file.Name()) {
-					good++
-				}
+		m, err := loadManifest(path.Join(cfg.DataDir, dim.key, "map"), bm, dim.prune)
+		if err != nil {
+			log.Fatal(err)
+		}
+		unchanged := 0
+
+		for _, file := range files {
+			if !strings.HasSuffix(file.Name(), ".mca") {
+				continue
+			}
+			if !lo.EveryBy(filters, func(re *regexp.Regexp) bool { return re.MatchString(file.Name()) }) {
+				continue
+			}
+			changed, source, err := m.changed(path.Join(dim.regionDir, file.Name()))
+			if err != nil {
+				log.Printf("%s: unable to check for changes: %v", file.Name(), err)
 			}
-			if good != len(filters) {
+			if !changed && !force {
+				unchanged++
 				continue
 			}
+			wg.Add(1)
+			work <- convertItem{dim, file.Name(), m, source}
+		}
+		wg.Wait()
+
+		if unchanged > 0 {
+			log.Printf("skipped %d unchanged regions", unchanged)
+		}
+		if err := m.save(); err != nil {
+			log.Fatal(err)
 		}
-		wg.Add(1)
-		work <- file
 	}
-	wg.Wait()
+
+	reportUnknownBlocks(bm)
+	reportUnknownBiomes(bm)
 }
 
-func usage() {
-	fmt.Println("usage: prog <regiondir> <outputdir> [filterstrings]")
-	flag.Usage()
+// checkWorldVersion reads a world's level.dat, and warns up front if it was saved
+// by a newer game version than blockmeta.json was generated from. It returns nil
+// if there's no level.dat, e.g. for a region directory on its own.
+func checkWorldVersion(bm *region.BlockMapper, worldDir string) *region.LevelDat {
+	ld, err := region.ReadLevelDat(path.Join(worldDir, "level.dat"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		log.Printf("unable to read level.dat: %v", err)
+		return nil
+	}
+	if ld.DataVersion > bm.WorldVersion() {
+		log.Printf("warning: %q was saved by %s (data version %d), which is newer than blockmeta.json (%d); "+
+			"new blocks will be drawn as placeholders until it's regenerated with gen from a newer jar",
+			ld.LevelName, ld.VersionName, ld.DataVersion, bm.WorldVersion())
+	}
+	return ld
 }
 
-func main() {
-	gen := flag.String("gen", "", "generate texture atlas & data files from jar")
-	numProcs := flag.Int("threads", runtime.NumCPU(), "number of parallel threads to use")
-	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to `file`")
-	noPrune := flag.Bool("noprune", false, "don't attempt to hide invisible portions")
-	doConvert := flag.Bool("convert", false, "convert region files for web display")
-	flag.Parse()
-
-	if *cpuprofile != "" {
-		f, err := os.Create(*cpuprofile)
-		if err != nil {
-			log.Fatal("could not create CPU profile: ", err)
+// reportUnknownBlocks lists the blocks that were drawn as placeholders, most common first.
+func reportUnknownBlocks(bm *region.BlockMapper) {
+	if v := bm.NewerVersion(); v > 0 {
+		fmt.Printf("some chunks were saved by a newer game version (data version %d) than blockmeta.json (%d)\n",
+			v, bm.WorldVersion())
+	}
+	unknown := bm.UnknownBlocks()
+	if len(unknown) == 0 {
+		return
+	}
+	names := lo.Keys(unknown)
+	sort.Slice(names, func(i, j int) bool {
+		if unknown[names[i]] != unknown[names[j]] {
+			return unknown[names[i]] > unknown[names[j]]
 		}
-		defer f.Close() // error handling omitted for example
-		if err := pprof.StartCPUProfile(f); err != nil {
-			log.Fatal("could not start CPU profile: ", err)
+		return names[i] < names[j]
+	})
+	fmt.Printf("%d unknown block types were drawn as placeholders:\n", len(names))
+	for _, name := range names {
+		fmt.Printf("%10d %s\n", unknown[name], name)
+	}
+}
+
+// reportUnknownBiomes lists the biomes that were tinted like plains.
+func reportUnknownBiomes(bm *region.BlockMapper) {
+	if unknown := bm.UnknownBiomes(); len(unknown) > 0 {
+		fmt.Printf("%d unknown biomes were tinted like plains: %s\n", len(unknown), strings.Join(unknown, ", "))
+	}
+}
+
+// worldFlags are the flags shared by the subcommands that work on worlds.
+type worldFlags struct {
+	configFile string
+	noPrune    bool
+	numProcs   int
+	cpuprofile string
+}
+
+func addWorldFlags(fs *flag.FlagSet) *worldFlags {
+	wf := &worldFlags{}
+	fs.StringVar(&wf.configFile, "config", "", "read the worlds to map from a YAML or JSON `file`")
+	fs.BoolVar(&wf.noPrune, "noprune", false, "don't attempt to hide invisible portions (without -config)")
+	fs.IntVar(&wf.numProcs, "threads", runtime.NumCPU(), "number of parallel threads to use")
+	fs.StringVar(&wf.cpuprofile, "cpuprofile", "", "write cpu profile to `file`")
+	return wf
+}
+
+// config reads the config file, or makes one for the world and data
+// directory given as arguments instead.
+func (wf *worldFlags) config(fs *flag.FlagSet) *config {
+	var cfg *config
+	var err error
+	if wf.configFile != "" {
+		if fs.NArg() != 0 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		cfg, err = loadConfig(wf.configFile)
+	} else {
+		if fs.NArg() != 2 {
+			fs.Usage()
+			os.Exit(2)
 		}
-		defer pprof.StopCPUProfile()
+		cfg, err = singleWorldConfig(fs.Arg(0), fs.Arg(1), !wf.noPrune)
 	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cfg
+}
+
+// startProfile starts writing a CPU profile if one was asked for,
+// and returns a function that finishes it.
+func (wf *worldFlags) startProfile() func() {
+	if wf.cpuprofile == "" {
+		return func() {}
+	}
+	f, err := os.Create(wf.cpuprofile)
+	if err != nil {
+		log.Fatal("could not create CPU profile: ", err)
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		log.Fatal("could not start CPU profile: ", err)
+	}
+	return func() {
+		pprof.StopCPUProfile()
+		f.Close()
+	}
+}
 
-	args := flag.Args()
+const worldArgs = "[-config file | worlddir|regiondir datadir]"
 
-	if *gen != "" {
-		generate(*gen)
-		return
+type command struct {
+	name, args, help string
+	run              func(fs *flag.FlagSet)
+}
+
+var commands = []command{
+	{"gen", "[datadir]", "generate blockmeta.json and the texture atlases from a client jar", genCommand},
+	{"convert", worldArgs, "render the regions of each world for web display", convertCommand},
+	{"serve", worldArgs, "render regions as they're viewed, and serve them with the viewer", serveCommand},
+	{"search", "-block block... " + worldArgs, "print the positions of blocks, like minecraft:chest[type=single]", searchCommand},
+	{"inspect", "regionfile [-chunk x,z [-path path]...]", "describe the chunks of a region file, or print one's NBT", inspectCommand},
+	{"stats", worldArgs, "summarize each world's regions and what's been rendered", statsCommand},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: cubeographer <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.help)
 	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "The commands that work on worlds read them from a config file, or map")
+	fmt.Fprintln(os.Stderr, "a single world or region directory to a data directory.")
+	fmt.Fprintln(os.Stderr, "Run \"cubeographer <command> -h\" for each command's flags.")
+}
 
-	var filters []string
-	if len(args) > 2 {
-		filters = args[2:]
+func genCommand(fs *flag.FlagSet) {
+	addGenFlags(fs)
+	fs.Parse(os.Args[2:])
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
 	}
-	if *doConvert {
-		if len(args) > 1 {
-			convert(*numProcs, args[0], args[1], filters, !*noPrune)
-			return
-		} else {
-			usage()
-			return
-		}
+	dataDir := defaultDataDir
+	if fs.NArg() == 1 {
+		dataDir = fs.Arg(0)
 	}
-	if len(args) > 1 {
-		serve(*numProcs, args[0], args[1], !*noPrune)
+	generate(dataDir)
+}
+
+func convertCommand(fs *flag.FlagSet) {
+	wf := addWorldFlags(fs)
+	addGenFlags(fs)
+	strict := fs.Bool("strict", false, "fail on unreadable chunks instead of skipping them")
+	force := fs.Bool("force", false, "convert every region, even those unchanged since the last conversion")
+	var filters []*regexp.Regexp
+	fs.Func("filter", "only convert regions whose file names match this `regexp` (repeatable, all must match)", func(s string) error {
+		re, err := regexp.Compile(s)
+		filters = append(filters, re)
+		return err
+	})
+	fs.Parse(os.Args[2:])
+	cfg := wf.config(fs)
+	defer wf.startProfile()()
+	convert(cfg, wf.numProcs, filters, *strict, *force)
+}
+
+func serveCommand(fs *flag.FlagSet) {
+	wf := addWorldFlags(fs)
+	addGenFlags(fs)
+	watch := fs.Duration("watch", 5*time.Second, "how often to check for changed regions (0 to disable)")
+	listen := fs.String("listen", "", "listen on this `address` instead of the configured one")
+	fs.Parse(os.Args[2:])
+	cfg := wf.config(fs)
+	if *listen != "" {
+		cfg.Listen = *listen
+	}
+	defer wf.startProfile()()
+	serve(cfg, wf.numProcs, *watch)
+}
+
+func searchCommand(fs *flag.FlagSet) {
+	wf := addWorldFlags(fs)
+	var queries []region.BlockQuery
+	fs.Func("block", "search for this block, like minecraft:chest[type=single] (repeatable)", func(s string) error {
+		q, err := region.ParseBlockQuery(s)
+		queries = append(queries, q)
+		return err
+	})
+	box := region.EverywhereBox
+	fs.Func("box", "only search within `x1,y1,z1,x2,y2,z2`", func(s string) (err error) {
+		box, err = region.ParseBox(s)
+		return err
+	})
+	limit := fs.Int("limit", 0, "stop searching a map after this many matches (0 for no limit)")
+	fs.Parse(os.Args[2:])
+	if len(queries) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	cfg := wf.config(fs)
+	defer wf.startProfile()()
+	search(cfg, wf.numProcs, queries, box, *limit)
+}
+
+func inspectCommand(fs *flag.FlagSet) {
+	chunk := fs.String("chunk", "", "print the NBT of the chunk at `x,z`, within the region or the world")
+	format := fs.String("format", "snbt", "print NBT as snbt or json")
+	var paths []region.NbtPath
+	fs.Func("path", "only print the tags at this `path`, like sections[*].block_states.palette (repeatable)", func(s string) error {
+		p, err := region.ParseNbtPath(s)
+		paths = append(paths, p)
+		return err
+	})
+	args := parseInterspersed(fs, os.Args[2:])
+	if len(args) != 1 || (*format != "snbt" && *format != "json") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var err error
+	if *chunk != "" {
+		var cx, cz int
+		if _, err := fmt.Sscanf(*chunk, "%d,%d", &cx, &cz); err != nil {
+			log.Fatalf("bad chunk %q: %v", *chunk, err)
+		}
+		err = inspectChunk(os.Stdout, args[0], cx, cz, paths, *format == "json")
 	} else {
+		err = inspectRegion(os.Stdout, args[0])
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseInterspersed parses flags that come after the arguments too, like
+// "inspect r.0.0.mca -chunk 1,2", and returns the arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var rest []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return rest
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func statsCommand(fs *flag.FlagSet) {
+	wf := addWorldFlags(fs)
+	fs.Parse(os.Args[2:])
+	cfg := wf.config(fs)
+	if err := stats(os.Stdout, cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
 		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name != os.Args[1] {
+			continue
+		}
+		fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "usage: cubeographer %s [flags] %s\n", c.name, c.args)
+			fmt.Fprintf(os.Stderr, "%s\n\nflags:\n", c.help)
+			fs.PrintDefaults()
+		}
+		c.run(fs)
+		return
+	}
+	if os.Args[1] != "-h" && os.Args[1] != "-help" && os.Args[1] != "help" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
 	}
+	usage()
+	os.Exit(2)
 }
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/rmmh/cubeographer/go/region"
+)
+
+// converterVersion is recorded in manifests, and must be bumped whenever
+// scanRegion's output changes, so that existing tiles get re-rendered.
+const converterVersion = 1
+
+// manifest records what each region's tiles were rendered from, so that
+// conversion can skip the regions that haven't changed since.
+// There's one for each output map directory.
+type manifest struct {
+	ConverterVersion int                        `json:"converter_version"`
+	BlockmetaHash    string                     `json:"blockmeta_hash"`
+	Prune            bool                       `json:"prune"`
+	Regions          map[string]*manifestRegion `json:"regions"` // by region file name, like r.1.-2.mca
+
+	path string
+	lock sync.Mutex
+	// the latest summary read from each region file, so neighbours and
+	// regions that haven't been rendered are only read again when they change
+	seen map[string]*manifestRegion
+}
+
+type manifestRegion struct {
+	// the region file's modification time (Unix nanoseconds) and size,
+	// which are enough to tell that it hasn't changed without reading it
+	ModTime int64 `json:"mtime"`
+	Size    int64 `json:"size"`
+
+	Quadrants [4]quadrantSummary `json:"quadrants"`
+
+	// the quadrants of the regions to the west, east, north and south that
+	// border this one, since faces along the border are hidden or drawn
+	// depending on the blocks across it
+	Neighbors [4][2]quadrantSummary `json:"neighbors"`
+
+	// the lowest and highest (exclusive) Y of the rendered sections,
+	// which are both 0 if it hasn't been rendered
+	MinY int `json:"min_y"`
+	MaxY int `json:"max_y"`
+}
+
+// quadrantSummary describes the chunks in a quarter of a region,
+// matching the r.X.Z.N.cmt tiles. Regions are still rendered as a whole,
+// since visibility and hidden faces depend on the neighbouring quadrants.
+type quadrantSummary struct {
+	Chunks int    `json:"chunks"` // how many are present
+	Hash   uint64 `json:"hash"`   // FNV-1a of each present chunk's index and timestamp
+}
+
+// neighborQuadrants gives the offset of each neighbouring region, and
+// which of its quadrants border the region in the middle.
+var neighborQuadrants = [4]struct {
+	dx, dz int
+	quads  [2]int
+}{
+	{-1, 0, [2]int{1, 3}},
+	{1, 0, [2]int{0, 2}},
+	{0, -1, [2]int{2, 3}},
+	{0, 1, [2]int{0, 1}},
+}
+
+// summarizeQuadrants condenses a region's chunk timestamps. A chunk's timestamp
+// is updated whenever the game saves it, so a quadrant has changed if any of
+// its chunks' timestamps has. All of them are hashed, not just the newest,
+// since an older chunk can change too, e.g. when it's restored from a backup.
+func summarizeQuadrants(hdr *region.RegionHeader) [4]quadrantSummary {
+	var quads [4]quadrantSummary
+	var hashes [4]hash.Hash64
+	for q := range hashes {
+		hashes[q] = fnv.New64a()
+	}
+	var buf [8]byte
+	for i, offset := range hdr.Offsets {
+		if offset == 0 {
+			continue
+		}
+		q := (i&31)>>4 + 2*(i>>9)
+		quads[q].Chunks++
+		binary.BigEndian.PutUint32(buf[:], uint32(i))
+		binary.BigEndian.PutUint32(buf[4:], hdr.Timestamps[i])
+		hashes[q].Write(buf[:])
+	}
+	for q := range quads {
+		if quads[q].Chunks > 0 {
+			quads[q].Hash = hashes[q].Sum64()
+		}
+	}
+	return quads
+}
+
+// loadManifest reads the manifest of an output map directory. If it's missing,
+// or was made by a different converter, blockmeta or pruning setting, it's empty,
+// so every region is considered changed.
+func loadManifest(outDir string, bm *region.BlockMapper, prune bool) (*manifest, error) {
+	m := &manifest{
+		ConverterVersion: converterVersion,
+		BlockmetaHash:    bm.Hash(),
+		Prune:            prune,
+		Regions:          map[string]*manifestRegion{},
+		seen:             map[string]*manifestRegion{},
+		path:             path.Join(outDir, "manifest.json"),
+	}
+	buf, err := os.ReadFile(m.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var old manifest
+	if err := json.Unmarshal(buf, &old); err != nil {
+		return nil, err
+	}
+	if old.ConverterVersion == m.ConverterVersion && old.BlockmetaHash == m.BlockmetaHash &&
+		old.Prune == m.Prune && old.Regions != nil {
+		m.Regions = old.Regions
+	}
+	return m, nil
+}
+
+// changed reports whether a region file or the borders of its neighbours
+// differ from when its tiles were last rendered, along with its current
+// summary for record to save once it's been rendered again.
+func (m *manifest) changed(regionPath string) (bool, *manifestRegion, error) {
+	cur, err := m.summarize(regionPath)
+	if err != nil {
+		return true, nil, err
+	}
+	cur.Neighbors = m.neighbors(regionPath)
+	name := path.Base(regionPath)
+	m.lock.Lock()
+	old := m.Regions[name]
+	m.lock.Unlock()
+	if old != nil {
+		// the tiles may have been deleted since
+		tile := path.Join(path.Dir(m.path), strings.TrimSuffix(name, ".mca")+".0.cmt")
+		if _, err := os.Stat(tile); err != nil {
+			old = nil
+		}
+	}
+	if old == nil || old.Quadrants != cur.Quadrants || old.Neighbors != cur.Neighbors {
+		return true, cur, nil
+	}
+	if old.ModTime != cur.ModTime || old.Size != cur.Size {
+		// rewritten without any chunks changing, e.g. by the game's
+		// periodic saves, so just note the new modification time
+		m.record(name, cur, old.MinY, old.MaxY)
+	}
+	cur.MinY, cur.MaxY = old.MinY, old.MaxY
+	return false, cur, nil
+}
+
+// summarize gives a region file's current summary, without its neighbours.
+// The header is only read if the file's changed since it was last seen.
+func (m *manifest) summarize(regionPath string) (*manifestRegion, error) {
+	st, err := os.Stat(regionPath)
+	if err != nil {
+		return nil, err
+	}
+	name := path.Base(regionPath)
+	m.lock.Lock()
+	known := []*manifestRegion{m.Regions[name], m.seen[name]}
+	m.lock.Unlock()
+	for _, r := range known {
+		if r != nil && r.ModTime == st.ModTime().UnixNano() && r.Size == st.Size() {
+			return &manifestRegion{ModTime: r.ModTime, Size: r.Size, Quadrants: r.Quadrants}, nil
+		}
+	}
+
+	hdr, err := region.ReadRegionHeader(regionPath)
+	if err != nil {
+		return nil, err
+	}
+	cur := manifestRegion{
+		ModTime:   st.ModTime().UnixNano(),
+		Size:      st.Size(),
+		Quadrants: summarizeQuadrants(hdr),
+	}
+	m.lock.Lock()
+	m.seen[name] = &cur
+	m.lock.Unlock()
+	return &manifestRegion{ModTime: cur.ModTime, Size: cur.Size, Quadrants: cur.Quadrants}, nil
+}
+
+// neighbors gives the summaries of the quadrants bordering a region,
+// which are empty where there's no neighbouring region.
+func (m *manifest) neighbors(regionPath string) [4][2]quadrantSummary {
+	var ret [4][2]quadrantSummary
+	rx, rz, err := region.ParseRegionPath(path.Base(regionPath))
+	if err != nil {
+		return ret
+	}
+	for i, n := range neighborQuadrants {
+		r, err := m.summarize(path.Join(path.Dir(regionPath), fmt.Sprintf("r.%d.%d.mca", rx+n.dx, rz+n.dz)))
+		if err != nil {
+			continue
+		}
+		for j, q := range n.quads {
+			ret[i][j] = r.Quadrants[q]
+		}
+	}
+	return ret
+}
+
+// record notes that a region has been rendered from the given source,
+// with sections from minY to maxY.
+func (m *manifest) record(name string, source *manifestRegion, minY, maxY int) {
+	r := *source
+	r.MinY, r.MaxY = minY, maxY
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Regions[name] = &r
+}
+
+// save writes the manifest back to its map directory.
+func (m *manifest) save() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	// write and rename, so that a reader never sees a partial manifest
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunkCounts gives how many chunks each quadrant has.
+func chunkCounts(quads []quadrantSummary) []int {
+	ret := make([]int, len(quads))
+	for i, q := range quads {
+		ret[i] = q.Chunks
+	}
+	return ret
+}
+
+func TestManifest(t *testing.T) {
+	bm := testBlockMapper(t)
+	regionDir, outDir := t.TempDir(), t.TempDir()
+	regionPath := path.Join(regionDir, "r.0.-1.mca")
+
+	hdrs := map[string][]byte{}
+	writeRegionAt := func(fn string, chunk int, timestamp uint32, mtime time.Time) {
+		if hdrs[fn] == nil {
+			hdrs[fn] = make([]byte, 8192)
+		}
+		hdr := hdrs[fn]
+		binary.BigEndian.PutUint32(hdr[chunk*4:], 2<<8|1)
+		binary.BigEndian.PutUint32(hdr[4096+chunk*4:], timestamp)
+		require.NoError(t, os.WriteFile(fn, hdr, 0644))
+		require.NoError(t, os.Chtimes(fn, mtime, mtime))
+	}
+	writeRegion := func(chunk int, timestamp uint32, mtime time.Time) {
+		writeRegionAt(regionPath, chunk, timestamp, mtime)
+	}
+	now := time.Now().Truncate(time.Second)
+	writeRegion(17+20*32, 1000, now)
+
+	m, err := loadManifest(outDir, bm, true)
+	require.NoError(t, err)
+	changed, source, err := m.changed(regionPath)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []int{0, 0, 0, 1}, chunkCounts(source.Quadrants[:]))
+	assert.NotZero(t, source.Quadrants[3].Hash)
+
+	// the tiles have to exist for the region to be up to date
+	m.record("r.0.-1.mca", source, -64, 320)
+	changed, _, _ = m.changed(regionPath)
+	assert.True(t, changed)
+	require.NoError(t, os.WriteFile(path.Join(outDir, "r.0.-1.0.cmt"), nil, 0644))
+	changed, _, _ = m.changed(regionPath)
+	assert.False(t, changed)
+	require.NoError(t, m.save())
+
+	m, err = loadManifest(outDir, bm, true)
+	require.NoError(t, err)
+	changed, _, _ = m.changed(regionPath)
+	assert.False(t, changed)
+
+	// saved again without any chunks changing
+	writeRegion(17+20*32, 1000, now.Add(time.Minute))
+	changed, _, _ = m.changed(regionPath)
+	assert.False(t, changed)
+	assert.Equal(t, -64, m.Regions["r.0.-1.mca"].MinY)
+
+	writeRegion(3, 2000, now.Add(2*time.Minute))
+	changed, source, _ = m.changed(regionPath)
+	assert.True(t, changed)
+	assert.Equal(t, 1, source.Quadrants[0].Chunks)
+
+	m.record("r.0.-1.mca", source, -64, 320)
+	changed, _, _ = m.changed(regionPath)
+	assert.False(t, changed)
+
+	// a chunk older than the newest one changing, with the count the same,
+	// like a chunk being restored from a backup
+	writeRegion(4, 1500, now.Add(3*time.Minute))
+	changed, source, _ = m.changed(regionPath)
+	assert.True(t, changed)
+	m.record("r.0.-1.mca", source, -64, 320)
+	writeRegion(4, 1400, now.Add(4*time.Minute))
+	changed, source, _ = m.changed(regionPath)
+	assert.True(t, changed)
+	assert.Equal(t, 2, source.Quadrants[0].Chunks)
+	m.record("r.0.-1.mca", source, -64, 320)
+	changed, _, _ = m.changed(regionPath)
+	assert.False(t, changed)
+
+	// faces along the border depend on the neighbours' bordering quadrants,
+	// here the western half of the region to the east
+	eastPath := path.Join(regionDir, "r.1.-1.mca")
+	writeRegionAt(eastPath, 0, 3000, now)
+	changed, source, _ = m.changed(regionPath)
+	assert.True(t, changed)
+	assert.Equal(t, []int{1, 0}, chunkCounts(source.Neighbors[1][:]))
+	m.record("r.0.-1.mca", source, -64, 320)
+	changed, _, _ = m.changed(regionPath)
+	assert.False(t, changed)
+	writeRegionAt(eastPath, 31, 4000, now.Add(time.Minute))
+	changed, _, _ = m.changed(regionPath)
+	assert.False(t, changed, "the east neighbour's far half doesn't matter")
+	writeRegionAt(eastPath, 32*20, 5000, now.Add(2*time.Minute))
+	changed, _, _ = m.changed(regionPath)
+	assert.True(t, changed)
+	require.NoError(t, os.Remove(eastPath))
+	changed, _, _ = m.changed(regionPath)
+	assert.True(t, changed, "so does a neighbour being deleted")
+
+	// different settings invalidate everything
+	m, err = loadManifest(outDir, bm, false)
+	require.NoError(t, err)
+	assert.Empty(t, m.Regions)
+}
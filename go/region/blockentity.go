@@ -0,0 +1,285 @@
+package region
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BlockEntity is a summary of a block entity that's worth labelling on the map.
+type BlockEntity struct {
+	ID      string      `json:"id"`
+	X       int         `json:"x"`
+	Y       int         `json:"y"`
+	Z       int         `json:"z"`
+	Name    string      `json:"name,omitempty"`    // custom name, e.g. of a banner or chest
+	Text    []string    `json:"text,omitempty"`    // sign lines, front then back
+	Items   []ItemCount `json:"items,omitempty"`   // container contents, most common first
+	Spawner string      `json:"spawner,omitempty"` // the mob a spawner creates
+}
+
+type ItemCount struct {
+	ID    string `json:"id"`
+	Count int    `json:"count"`
+}
+
+// interestingBlockEntity reports whether block entities with the given id are
+// kept. Names are normalized first, so the pre-1.11 CamelCase ids also work.
+func interestingBlockEntity(id string) bool {
+	switch strings.TrimPrefix(strings.ToLower(id), "minecraft:") {
+	case "sign", "hanging_sign", "chest", "trapped_chest", "barrel", "shulker_box",
+		"banner", "mob_spawner", "mobspawner", "spawner", "trial_spawner":
+		return true
+	}
+	return false
+}
+
+// rawBlockEntity accumulates the fields of a block entity during the NBT walk.
+type rawBlockEntity struct {
+	BlockEntity
+	items []rawItem
+	// the custom name and sign lines, front then back, when they're
+	// saved as NBT text components, since 1.21.5
+	name  textComponent
+	lines [2][]textComponent
+}
+
+type rawItem struct {
+	id       string
+	count    int
+	hasCount bool
+}
+
+// field handles a single NBT value belonging to a block entity.
+// path starts just inside the block entity's compound, and item is
+// the index in the Items list, if any.
+func (be *rawBlockEntity) field(path []string, item int, ty NbtType, value []byte) {
+	last := path[len(path)-1]
+	switch {
+	case len(path) == 1:
+		switch last {
+		case "id":
+			be.ID = string(value)
+		case "x", "y", "z":
+			if ty != TagInt {
+				return
+			}
+			v := int(int32(binary.BigEndian.Uint32(value)))
+			switch last {
+			case "x":
+				be.X = v
+			case "y":
+				be.Y = v
+			case "z":
+				be.Z = v
+			}
+		case "CustomName":
+			if ty == TagString {
+				be.Name = plainText(string(value))
+			} else {
+				be.name.add(nil, ty, value)
+			}
+		case "Text1", "Text2", "Text3", "Text4":
+			// signs before 1.20
+			be.Text = append(be.Text, plainText(string(value)))
+		case "EntityId":
+			// spawners before 1.9
+			be.Spawner = string(value)
+		}
+	case path[0] == "CustomName":
+		be.name.add(path[1:], ty, value)
+	case len(path) >= 2 && path[1] == "messages" && (path[0] == "front_text" || path[0] == "back_text"):
+		// signs from 1.20, as JSON strings, or as text components since 1.21.5
+		side := 0
+		if path[0] == "back_text" {
+			side = 1
+		}
+		if len(path) == 2 && ty == -TagString {
+			for i, text := range stringList(value) {
+				be.line(side, i).add(nil, TagString, []byte(plainText(text)))
+			}
+			return
+		}
+		if len(path) > 2 {
+			if i, err := strconv.Atoi(path[2]); err == nil {
+				be.line(side, i).add(path[3:], ty, value)
+			}
+		}
+	case len(path) == 3 && path[0] == "Items":
+		for item >= len(be.items) {
+			be.items = append(be.items, rawItem{})
+		}
+		it := &be.items[item]
+		switch {
+		case last == "id" && ty == TagString:
+			it.id = string(value)
+		case last == "Count" && ty == TagByte:
+			it.count, it.hasCount = int(int8(value[0])), true
+		case last == "count" && ty == TagInt:
+			// 1.20.5+
+			it.count, it.hasCount = int(int32(binary.BigEndian.Uint32(value))), true
+		}
+	case last == "id" && ty == TagString && path[0] == "SpawnData":
+		// SpawnData.id before 1.18, SpawnData.entity.id after
+		be.Spawner = string(value)
+	}
+}
+
+// line gives a sign line, growing the side's lines to hold it.
+func (be *rawBlockEntity) line(side, i int) *textComponent {
+	for i >= len(be.lines[side]) {
+		be.lines[side] = append(be.lines[side], nil)
+	}
+	return &be.lines[side][i]
+}
+
+// finish summarizes the contents of a container.
+func (be *rawBlockEntity) finish() BlockEntity {
+	counts := map[string]int{}
+	for _, it := range be.items {
+		if it.id == "" {
+			continue
+		}
+		if !it.hasCount {
+			it.count = 1 // omitted when 1 since 1.20.5
+		}
+		counts[it.id] += it.count
+	}
+	ret := be.BlockEntity
+	if be.name != nil {
+		ret.Name = be.name.String()
+	}
+	for _, lines := range be.lines {
+		for _, line := range lines {
+			ret.Text = append(ret.Text, line.String())
+		}
+	}
+	for id, count := range counts {
+		ret.Items = append(ret.Items, ItemCount{ID: id, Count: count})
+	}
+	sort.Slice(ret.Items, func(i, j int) bool {
+		if ret.Items[i].Count != ret.Items[j].Count {
+			return ret.Items[i].Count > ret.Items[j].Count
+		}
+		return ret.Items[i].ID < ret.Items[j].ID
+	})
+	// blank sign lines are the norm, only keep the text if there is some
+	if strings.TrimSpace(strings.Join(ret.Text, "")) == "" {
+		ret.Text = nil
+	}
+	return ret
+}
+
+// plainText flattens a JSON text component, like {"text":"a","extra":[{"text":"b"}]},
+// to its plain text. Anything else, like the plain strings that components are
+// saved as since 1.21.5, is returned as-is.
+func plainText(component string) string {
+	var v any
+	if err := json.Unmarshal([]byte(component), &v); err != nil {
+		return component
+	}
+	switch v.(type) {
+	case string, []any, map[string]any:
+	default:
+		return component // a number or the like, not a component
+	}
+	var sb strings.Builder
+	var walk func(v any)
+	walk = func(v any) {
+		switch t := v.(type) {
+		case string:
+			sb.WriteString(t)
+		case []any:
+			for _, e := range t {
+				walk(e)
+			}
+		case map[string]any:
+			if text, ok := t["text"].(string); ok {
+				sb.WriteString(text)
+			} else if tr, ok := t["translate"].(string); ok {
+				sb.WriteString(tr)
+			}
+			if extra, ok := t["extra"]; ok {
+				walk(extra)
+			}
+		}
+	}
+	walk(v)
+	return sb.String()
+}
+
+// textComponent collects the text of a text component saved as NBT, as they
+// have been since 1.21.5, like {text: "a", extra: [{text: "b"}, "c"]}. It's
+// walked a value at a time, so each piece of text is kept with its path
+// within the component, to put them back in order.
+type textComponent []textPart
+
+type textPart struct {
+	path []string
+	text string
+}
+
+// add handles a value of the component, at path within it.
+func (tc *textComponent) add(path []string, ty NbtType, value []byte) {
+	switch ty {
+	case TagString:
+		if len(path) > 0 {
+			switch path[len(path)-1] {
+			case "text", "translate", "":
+				// "" holds the strings of lists that mix them with compounds
+			default:
+				return
+			}
+		}
+		*tc = append(*tc, textPart{append([]string(nil), path...), string(value)})
+	case -TagString:
+		for i, text := range stringList(value) {
+			*tc = append(*tc, textPart{append(append([]string(nil), path...), strconv.Itoa(i)), text})
+		}
+	}
+}
+
+// String gives the component's plain text. A component's own text comes
+// before its extra components, and list elements are in order.
+func (tc textComponent) String() string {
+	rank := func(key string) int {
+		if key == "text" || key == "translate" || key == "" {
+			return 0
+		}
+		return 1
+	}
+	parts := slices.Clone(tc)
+	slices.SortStableFunc(parts, func(a, b textPart) int {
+		for i := range min(len(a.path), len(b.path)) {
+			if a.path[i] == b.path[i] {
+				continue
+			}
+			ai, aErr := strconv.Atoi(a.path[i])
+			bi, bErr := strconv.Atoi(b.path[i])
+			if aErr == nil && bErr == nil {
+				return ai - bi
+			}
+			return rank(a.path[i]) - rank(b.path[i])
+		}
+		return len(a.path) - len(b.path)
+	})
+	var sb strings.Builder
+	for _, p := range parts {
+		sb.WriteString(p.text)
+	}
+	return sb.String()
+}
+
+// stringList splits the value of a list of strings.
+func stringList(value []byte) []string {
+	var ret []string
+	for o := 0; o+2 <= len(value); {
+		n := int(binary.BigEndian.Uint16(value[o:]))
+		ret = append(ret, string(value[o+2:o+2+n]))
+		o += 2 + n
+	}
+	return ret
+}
@@ -0,0 +1,105 @@
+package region
+
+import (
+	"encoding/binary"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlainText(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{`"Shop"`, "Shop"},
+		{`{"text":"Diamonds ","extra":[{"text":"4 "},"sale"]}`, "Diamonds 4 sale"},
+		{`""`, ""},
+		{`not json`, "not json"},
+		{`{"translate":"block.minecraft.chest"}`, "block.minecraft.chest"},
+		{`42`, "42"}, // a plain string since 1.21.5
+	} {
+		require.Equal(t, tc.want, plainText(tc.in), tc.in)
+	}
+}
+
+func TestBlockEntityFields(t *testing.T) {
+	i32 := func(v int32) []byte {
+		return binary.BigEndian.AppendUint32(nil, uint32(v))
+	}
+	strList := func(strs ...string) []byte {
+		var buf []byte
+		for _, s := range strs {
+			buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+			buf = append(buf, s...)
+		}
+		return buf
+	}
+
+	var sign rawBlockEntity
+	sign.field([]string{"id"}, 0, TagString, []byte("minecraft:sign"))
+	sign.field([]string{"x"}, 0, TagInt, i32(-100))
+	sign.field([]string{"y"}, 0, TagInt, i32(-20))
+	sign.field([]string{"z"}, 0, TagInt, i32(7))
+	sign.field([]string{"front_text", "messages"}, 0, -TagString, strList(`"Iron"`, `{"text":"Shop"}`, `""`, `""`))
+	sign.field([]string{"back_text", "messages"}, 0, -TagString, strList(`""`, `""`, `""`, `""`))
+	got := sign.finish()
+	require.Equal(t, []int{-100, -20, 7}, []int{got.X, got.Y, got.Z})
+	require.Equal(t, []string{"Iron", "Shop", "", "", "", "", "", ""}, got.Text)
+
+	var blank rawBlockEntity
+	blank.field([]string{"Text1"}, 0, TagString, []byte(`""`))
+	require.Nil(t, blank.finish().Text)
+
+	var chest rawBlockEntity
+	chest.field([]string{"id"}, 0, TagString, []byte("minecraft:chest"))
+	chest.field([]string{"CustomName"}, 0, TagString, []byte(`{"text":"Bank"}`))
+	chest.field([]string{"Items", "0", "id"}, 0, TagString, []byte("minecraft:diamond"))
+	chest.field([]string{"Items", "0", "Count"}, 0, TagByte, []byte{5})
+	chest.field([]string{"Items", "1", "id"}, 1, TagString, []byte("minecraft:emerald"))
+	chest.field([]string{"Items", "1", "count"}, 1, TagInt, i32(12))
+	chest.field([]string{"Items", "2", "id"}, 2, TagString, []byte("minecraft:diamond"))
+	chest.field([]string{"Items", "3", "id"}, 3, TagString, []byte("minecraft:stick"))
+	got = chest.finish()
+	require.Equal(t, "Bank", got.Name)
+	require.Equal(t, []ItemCount{{"minecraft:emerald", 12}, {"minecraft:diamond", 6}, {"minecraft:stick", 1}}, got.Items)
+
+	var spawner rawBlockEntity
+	spawner.field([]string{"id"}, 0, TagString, []byte("minecraft:mob_spawner"))
+	spawner.field([]string{"SpawnData", "entity", "id"}, 0, TagString, []byte("minecraft:zombie"))
+	require.Equal(t, "minecraft:zombie", spawner.finish().Spawner)
+
+	require.True(t, interestingBlockEntity("minecraft:banner"))
+	require.True(t, interestingBlockEntity("MobSpawner"))
+	require.False(t, interestingBlockEntity("minecraft:furnace"))
+}
+
+// TestReadBlockEntitiesTextComponents reads block entities as saved since
+// 1.21.5, with names and sign lines as NBT text components instead of JSON.
+func TestReadBlockEntitiesTextComponents(t *testing.T) {
+	chunk := encodeSNBT(t, `{DataVersion: 4325, xPos: 0, zPos: 0, Status: "minecraft:full",
+		sections: [{Y: 0b, block_states: {palette: [{Name: "minecraft:stone"}]}}],
+		block_entities: [
+			{id: "minecraft:sign", x: 1, y: 64, z: 2,
+				front_text: {messages: [
+					{text: "Iron"},
+					{"": "Shop"},
+					{extra: [{text: "4 "}, {"": "sale"}], text: "Diamonds "},
+					{text: ""}
+				]},
+				back_text: {messages: ["", "42", "", ""]}},
+			{id: "minecraft:chest", x: 3, y: 64, z: 2, CustomName: {text: "Bank", color: "gold", extra: [" vault"]}},
+			{id: "minecraft:banner", x: 4, y: 64, z: 2, CustomName: "Home"}
+		]}`)
+	var w Writer
+	require.NoError(t, w.AddNBT(0, 0, chunk, time.Unix(1700000000, 0)))
+	fn := path.Join(t.TempDir(), w.Filename())
+	require.NoError(t, w.WriteFile(fn))
+
+	cdata, err := ReadRegion(fn, testBlockMapper(t), nil)
+	require.NoError(t, err)
+	require.Equal(t, []BlockEntity{
+		{ID: "minecraft:sign", X: 1, Y: 64, Z: 2, Text: []string{"Iron", "Shop", "Diamonds 4 sale", "", "", "42", "", ""}},
+		{ID: "minecraft:chest", X: 3, Y: 64, Z: 2, Name: "Bank vault"},
+		{ID: "minecraft:banner", X: 4, Y: 64, Z: 2, Name: "Home"},
+	}, cdata[0].BlockEntities)
+}
@@ -1,7 +1,15 @@
 package region
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/rmmh/cubeographer/go/render"
 	"github.com/rmmh/cubeographer/go/resourcepack"
@@ -10,6 +18,7 @@ import (
 // TODO: this should probably go back to AOS instead of this SOA form
 type BlockMapper struct {
 	meta             render.BlockEntryMetadata
+	hash             string
 	migrateBlockMaps []migrateVersionedBlockMap
 
 	solid              []uint64
@@ -17,41 +26,69 @@ type BlockMapper struct {
 	blockstateToNstate [4096]render.Stateval
 	NameToNid          map[string]uint16
 	NidToName          []string
+	nidToDisplayName   []string
 	nidToSmap          []render.Statemap
 	Tmpl               [][][]uint32
 	Layer              [][]uint8
+
+	// Blocks that aren't in blockmeta.json are given nids from a range
+	// reserved at load time, so the slices above never change afterwards.
+	unknownBase   uint16 // the placeholder's own nid, shared once the range is used up
+	unknownFirst  uint16 // start of the reserved range
+	unknownLock   sync.Mutex
+	unknownNids   map[string]uint16
+	unknownCounts map[string]int
+	// Likewise, biomes that aren't in render.Biomes get ids after them,
+	// so their names can still be told apart, and are tinted like plains.
+	unknownBiomes map[string]uint8
+
+	// the newest DataVersion read that's newer than blockmeta.json
+	newerVersion atomic.Int64
 }
 
+// maxUnknownNids is how many distinct unknown blocks get their own nid.
+// Any further ones share the placeholder's nid.
+const maxUnknownNids = 256
+
+// unknownBiome is the id shared by unknown biomes once the other ids are used up.
+const unknownBiome = math.MaxUint8
+
 func LoadBlockMapper(buf []byte) (*BlockMapper, error) {
 	bm := &BlockMapper{
-		NameToNid: map[string]uint16{},
-		NidToName: []string{""},
-		nidToSmap: []render.Statemap{nil},
-		solid:     []uint64{},
-		Tmpl:      [][][]uint32{nil},
-		Layer:     [][]uint8{nil},
+		NameToNid:        map[string]uint16{},
+		NidToName:        []string{""},
+		nidToDisplayName: []string{""},
+		nidToSmap:        []render.Statemap{nil},
+		solid:            []uint64{},
+		Tmpl:             [][][]uint32{nil},
+		Layer:            [][]uint8{nil},
 	}
 
 	err := json.Unmarshal(buf, &bm.meta)
 	if err != nil {
 		return nil, err
 	}
+	sum := sha256.Sum256(buf)
+	bm.hash = hex.EncodeToString(sum[:8])
 
 	count := 1
 	for _, b := range bm.meta.Blocks {
 		n := uint16(count)
-		if b.Name == "air" || b.Name == "cave_air" || b.Name == "void_air" {
+		switch b.Name {
+		case "air", "cave_air", "void_air", "minecraft:air", "minecraft:cave_air", "minecraft:void_air":
 			n = 0
-		} else {
+		default:
 			count++
 		}
 		bm.NameToNid[b.Name] = n
 		smap := render.BuildStateMap(b.States)
 		if int(n) >= len(bm.NidToName) {
 			bm.NidToName = append(bm.NidToName, b.Name)
+			bm.nidToDisplayName = append(bm.nidToDisplayName, b.DisplayName)
 			bm.nidToSmap = append(bm.nidToSmap, smap)
 		} else {
 			bm.NidToName[n] = b.Name
+			bm.nidToDisplayName[n] = b.DisplayName
 		}
 		if n > 0 {
 			if int(n>>6) >= len(bm.solid) {
@@ -71,6 +108,41 @@ func LoadBlockMapper(buf []byte) (*BlockMapper, error) {
 		}
 	}
 
+	// blockmeta names air without the minecraft: prefix, which chunks use,
+	// and anything missing from blockmeta is drawn as an unknown block
+	for _, name := range []string{"air", "cave_air", "void_air"} {
+		bm.NameToNid[name] = 0
+		bm.NameToNid["minecraft:"+name] = 0
+	}
+
+	if len(bm.meta.Biomes) != len(render.Biomes) {
+		return nil, errors.New("blockmeta has outdated biome colors, it must be regenerated")
+	}
+
+	unknown, ok := bm.NameToNid[render.UnknownBlockName]
+	if !ok {
+		return nil, errors.New("blockmeta has no placeholder for unknown blocks, it must be regenerated")
+	}
+	bm.unknownBase = unknown
+	bm.unknownFirst = uint16(len(bm.NidToName))
+	bm.unknownNids = map[string]uint16{}
+	bm.unknownCounts = map[string]int{}
+	bm.unknownBiomes = map[string]uint8{}
+	for i := 1; i < maxUnknownNids; i++ {
+		n := len(bm.NidToName)
+		bm.NidToName = append(bm.NidToName, render.UnknownBlockName)
+		bm.nidToDisplayName = append(bm.nidToDisplayName, bm.nidToDisplayName[unknown])
+		bm.nidToSmap = append(bm.nidToSmap, nil)
+		bm.Tmpl = append(bm.Tmpl, bm.Tmpl[unknown])
+		bm.Layer = append(bm.Layer, bm.Layer[unknown])
+		if n>>6 >= len(bm.solid) {
+			bm.solid = append(bm.solid, 0)
+		}
+		if bm.IsSolid(unknown) {
+			bm.solid[n>>6] |= 1 << (n & 63)
+		}
+	}
+
 	for blockstate, data := range resourcepack.BlockstateMap {
 		nid := bm.NameToNid["minecraft:"+data.Name]
 		bm.blockstateToNid[blockstate] = nid
@@ -82,7 +154,161 @@ func LoadBlockMapper(buf []byte) (*BlockMapper, error) {
 	return bm, nil
 }
 
+// Hash identifies the blockmeta.json the mapper was loaded from,
+// so output made with a different one can be recognized.
+func (bm *BlockMapper) Hash() string {
+	return bm.hash
+}
+
+// WorldVersion is the DataVersion of the game version blockmeta.json was
+// generated from.
+func (bm *BlockMapper) WorldVersion() int {
+	return bm.meta.WorldVersion
+}
+
+// NewerVersion reports the newest DataVersion of the chunks read that's newer
+// than blockmeta.json, or 0 if there were none. Blocks added since will have been
+// drawn as placeholders, and renamed blocks won't have been migrated.
+func (bm *BlockMapper) NewerVersion() int {
+	return int(bm.newerVersion.Load())
+}
+
+func (bm *BlockMapper) noteNewerVersion(v int) {
+	for {
+		old := bm.newerVersion.Load()
+		if int64(v) <= old || bm.newerVersion.CompareAndSwap(old, int64(v)) {
+			return
+		}
+	}
+}
+
 func (bm *BlockMapper) IsSolid(b uint16) bool {
 	// instead of trying to track every transparent block, keep a list of *known* solid blocks
 	return bm.solid[b>>6]&(1<<(b&63)) != 0
 }
+
+// BiomeColors gives the tints for a biome id. Unknown biomes are tinted
+// like the first of render.Biomes, plains.
+func (bm *BlockMapper) BiomeColors(id uint8) render.BiomeColors {
+	if int(id) >= len(bm.meta.Biomes) {
+		id = 0
+	}
+	return bm.meta.Biomes[id]
+}
+
+// biomeID gives the id of a biome from a 1.18+ palette, like "minecraft:swamp".
+func (bm *BlockMapper) biomeID(name string) uint8 {
+	if id, ok := render.LookupBiome(name); ok {
+		return id
+	}
+	bm.unknownLock.Lock()
+	defer bm.unknownLock.Unlock()
+	if id, ok := bm.unknownBiomes[name]; ok {
+		return id
+	}
+	id := uint8(unknownBiome)
+	if n := len(render.Biomes) + len(bm.unknownBiomes); n < unknownBiome {
+		id = uint8(n)
+	}
+	bm.unknownBiomes[name] = id
+	return id
+}
+
+// BiomeName gives the name of a biome id, like "minecraft:swamp". Unknown
+// biomes keep their own name, unless there were too many of them to tell
+// apart, when it's "unknown".
+func (bm *BlockMapper) BiomeName(id uint8) string {
+	if int(id) < len(render.Biomes) {
+		return "minecraft:" + render.Biomes[id].Name
+	}
+	if id != unknownBiome {
+		bm.unknownLock.Lock()
+		defer bm.unknownLock.Unlock()
+		for name, unknown := range bm.unknownBiomes {
+			if unknown == id {
+				return name
+			}
+		}
+	}
+	return "unknown"
+}
+
+// UnknownBiomes reports the biome names that weren't in render.Biomes
+// and were tinted like plains, in order.
+func (bm *BlockMapper) UnknownBiomes() []string {
+	bm.unknownLock.Lock()
+	defer bm.unknownLock.Unlock()
+	ret := make([]string, 0, len(bm.unknownBiomes))
+	for name := range bm.unknownBiomes {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// unknownNid returns the nid to use for a block name that isn't in blockmeta.
+func (bm *BlockMapper) unknownNid(name string) uint16 {
+	bm.unknownLock.Lock()
+	defer bm.unknownLock.Unlock()
+	if nid, ok := bm.unknownNids[name]; ok {
+		return nid
+	}
+	nid := bm.unknownBase
+	if n := len(bm.unknownNids); n < maxUnknownNids-1 {
+		nid = bm.unknownFirst + uint16(n)
+	}
+	bm.unknownNids[name] = nid
+	return nid
+}
+
+func (bm *BlockMapper) countUnknown(counts map[string]int) {
+	bm.unknownLock.Lock()
+	defer bm.unknownLock.Unlock()
+	for name, count := range counts {
+		bm.unknownCounts[name] += count
+	}
+}
+
+// Describe gives the name, display name and state properties of a block,
+// as it was read. Blocks that weren't in blockmeta.json keep their own name,
+// unless there were too many of them to tell apart.
+func (bm *BlockMapper) Describe(nid uint16, state render.Stateval) (name, displayName string, props map[string]string) {
+	if nid == 0 {
+		return "minecraft:air", "Air", nil
+	}
+	name, displayName = bm.NidToName[nid], bm.nidToDisplayName[nid]
+	if nid >= bm.unknownFirst {
+		bm.unknownLock.Lock()
+		for n, unknown := range bm.unknownNids {
+			if unknown == nid {
+				name = n
+			}
+		}
+		bm.unknownLock.Unlock()
+		return name, displayName, nil
+	}
+	// reverse the Statemap: each property=value sets its value bits within its mask
+	for prop, v := range bm.nidToSmap[nid] {
+		mask, val := render.Stateval(v>>16), render.Stateval(v)
+		if state&mask == val {
+			if props == nil {
+				props = map[string]string{}
+			}
+			k, v, _ := strings.Cut(prop, "=")
+			props[k] = v
+		}
+	}
+	return name, displayName, props
+}
+
+// UnknownBlocks reports the block names that weren't in blockmeta.json
+// and were drawn as placeholders, with how many of each have been read.
+func (bm *BlockMapper) UnknownBlocks() map[string]int {
+	bm.unknownLock.Lock()
+	defer bm.unknownLock.Unlock()
+	ret := make(map[string]int, len(bm.unknownCounts))
+	for name, count := range bm.unknownCounts {
+		ret[name] = count
+	}
+	return ret
+}
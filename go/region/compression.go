@@ -0,0 +1,150 @@
+package region
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	lz4 "github.com/DataDog/golz4-2"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+)
+
+// Chunk compression schemes, from the byte following each chunk's length.
+const (
+	compressionGZip   = 1
+	compressionZlib   = 2
+	compressionNone   = 3
+	compressionLZ4    = 4 // 1.20.5+
+	compressionCustom = 127
+
+	// set when the chunk was too large for the region file,
+	// and is stored in a c.X.Z.mcc file next to it instead
+	compressionExternal = 0x80
+)
+
+// externalChunkPath gives the path of the .mcc file holding an oversized chunk.
+// The coordinates are absolute chunk coordinates, not region-relative.
+func externalChunkPath(regionPath string, cx, cz int) string {
+	return path.Join(path.Dir(regionPath), fmt.Sprintf("c.%d.%d.mcc", cx, cz))
+}
+
+// chunkDecompressor holds decompression state that's reused between the chunks of a region.
+type chunkDecompressor struct {
+	out *bytes.Buffer
+
+	zr  io.ReadCloser
+	zrr zlib.Resetter
+	gr  *gzip.Reader
+}
+
+func newChunkDecompressor() *chunkDecompressor {
+	return &chunkDecompressor{
+		out: bytes.NewBuffer(make([]byte, 0, 4*(1<<20))),
+	}
+}
+
+// decompress returns the NBT data of a chunk. The result is only valid
+// until the next call.
+func (d *chunkDecompressor) decompress(scheme byte, data []byte) ([]byte, error) {
+	d.out.Reset()
+	switch scheme {
+	case compressionZlib:
+		var err error
+		r := bytes.NewReader(data)
+		if d.zr == nil {
+			d.zr, err = zlib.NewReader(r)
+			if err != nil {
+				d.zr = nil
+				return nil, err
+			}
+			var ok bool
+			d.zrr, ok = d.zr.(zlib.Resetter)
+			if !ok {
+				panic("zlib.NewReader MUST be resettable")
+			}
+		} else if err = d.zrr.Reset(r, nil); err != nil {
+			return nil, err
+		}
+		if _, err = d.out.ReadFrom(d.zr); err != nil {
+			return nil, err
+		}
+	case compressionGZip:
+		var err error
+		r := bytes.NewReader(data)
+		if d.gr == nil {
+			d.gr, err = gzip.NewReader(r)
+			if err != nil {
+				d.gr = nil
+				return nil, err
+			}
+		} else if err = d.gr.Reset(r); err != nil {
+			return nil, err
+		}
+		if _, err = d.out.ReadFrom(d.gr); err != nil {
+			return nil, err
+		}
+	case compressionNone:
+		return data, nil
+	case compressionLZ4:
+		if err := d.decompressLZ4(data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unhandled compression type %d", scheme)
+	}
+	return d.out.Bytes(), nil
+}
+
+var lz4BlockMagic = []byte("LZ4Block")
+
+// decompressLZ4 reads the block stream produced by lz4-java's LZ4BlockOutputStream:
+// a series of blocks, each with a 21 byte header, ending with an empty block.
+func (d *chunkDecompressor) decompressLZ4(data []byte) error {
+	for len(data) > 0 {
+		if len(data) < 21 || !bytes.Equal(data[:8], lz4BlockMagic) {
+			return errors.New("bad LZ4Block header")
+		}
+		method := data[8] & 0xf0
+		compLen := int(binary.LittleEndian.Uint32(data[9:]))
+		rawLen := int(binary.LittleEndian.Uint32(data[13:]))
+		// data[17:21] is an xxhash32 checksum of the block, which we don't verify
+		data = data[21:]
+		if rawLen == 0 {
+			// end of stream marker
+			break
+		}
+		if compLen < 0 || compLen > len(data) || rawLen < 0 {
+			return fmt.Errorf("truncated LZ4Block (want %d bytes, have %d)", compLen, len(data))
+		}
+		if rawLen > 255*compLen+16 {
+			// more than LZ4 can expand to, so don't allocate it
+			return fmt.Errorf("bad LZ4Block length %d for %d compressed bytes", rawLen, compLen)
+		}
+		switch method {
+		case 0x10: // raw
+			if compLen != rawLen {
+				return errors.New("bad raw LZ4Block length")
+			}
+			d.out.Write(data[:compLen])
+		case 0x20: // lz4
+			d.out.Grow(rawLen)
+			dst := d.out.AvailableBuffer()[:rawLen]
+			n, err := lz4.Uncompress(dst, data[:compLen])
+			if err != nil {
+				return err
+			}
+			if n != rawLen {
+				return fmt.Errorf("LZ4Block decompressed to %d bytes, expected %d", n, rawLen)
+			}
+			d.out.Write(dst)
+		default:
+			return fmt.Errorf("unknown LZ4Block method %#x", method)
+		}
+		data = data[compLen:]
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package region
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	lz4 "github.com/DataDog/golz4-2"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/stretchr/testify/require"
+)
+
+// lz4BlockEncode mimics lz4-java's LZ4BlockOutputStream, minus the checksums.
+func lz4BlockEncode(t *testing.T, data []byte, blockSize int) []byte {
+	var out bytes.Buffer
+	writeBlock := func(method byte, comp []byte, rawLen int) {
+		var hdr [21]byte
+		copy(hdr[:], lz4BlockMagic)
+		hdr[8] = method | 6
+		binary.LittleEndian.PutUint32(hdr[9:], uint32(len(comp)))
+		binary.LittleEndian.PutUint32(hdr[13:], uint32(rawLen))
+		out.Write(hdr[:])
+		out.Write(comp)
+	}
+	for i := 0; i < len(data); i += blockSize {
+		block := data[i:min(i+blockSize, len(data))]
+		comp := make([]byte, lz4.CompressBound(block))
+		n, err := lz4.Compress(comp, block)
+		require.NoError(t, err)
+		if n >= len(block) {
+			writeBlock(0x10, block, len(block))
+		} else {
+			writeBlock(0x20, comp[:n], len(block))
+		}
+	}
+	writeBlock(0x10, nil, 0)
+	return out.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	data := bytes.Repeat([]byte("minecraft:stone minecraft:dirt "), 1000)
+	data = append(data, "some incompressible tail: \x01\x93\xfe\x22"...)
+
+	var zbuf, gbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	zw.Write(data)
+	zw.Close()
+	gw := gzip.NewWriter(&gbuf)
+	gw.Write(data)
+	gw.Close()
+
+	d := newChunkDecompressor()
+	for _, tc := range []struct {
+		name   string
+		scheme byte
+		comp   []byte
+	}{
+		{"zlib", compressionZlib, zbuf.Bytes()},
+		{"gzip", compressionGZip, gbuf.Bytes()},
+		{"none", compressionNone, data},
+		{"lz4", compressionLZ4, lz4BlockEncode(t, data, 1<<16)},
+		{"lz4 small blocks", compressionLZ4, lz4BlockEncode(t, data, 100)},
+		{"zlib again", compressionZlib, zbuf.Bytes()},
+	} {
+		out, err := d.decompress(tc.scheme, tc.comp)
+		require.NoError(t, err, tc.name)
+		require.Equal(t, data, out, tc.name)
+	}
+
+	_, err := d.decompress(compressionLZ4, []byte("LZ4Block"))
+	require.Error(t, err)
+	_, err = d.decompress(99, data)
+	require.Error(t, err)
+}
@@ -0,0 +1,145 @@
+package region
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// Entity is a summary of a mob or other entity that's worth labelling on the map.
+type Entity struct {
+	ID         string                `json:"id"`
+	X          float64               `json:"x"`
+	Y          float64               `json:"y"`
+	Z          float64               `json:"z"`
+	Name       string                `json:"name,omitempty"`       // custom name, e.g. of a pet
+	Tamed      bool                  `json:"tamed,omitempty"`      // has an owner
+	Item       string                `json:"item,omitempty"`       // the item in an item frame
+	Pose       map[string][3]float32 `json:"pose,omitempty"`       // armor stand part rotations in degrees, by part name
+	Profession string                `json:"profession,omitempty"` // villager profession
+}
+
+// interestingEntity reports whether an entity is kept. Other mobs are only
+// kept if they're named or tamed, since there are far too many of them.
+func interestingEntity(e *Entity) bool {
+	if e.Name != "" || e.Tamed {
+		return true
+	}
+	switch strings.TrimPrefix(strings.ToLower(e.ID), "minecraft:") {
+	case "item_frame", "glow_item_frame", "armor_stand", "villager", "zombie_villager",
+		"wandering_trader", "itemframe", "armorstand":
+		return true
+	}
+	return false
+}
+
+// EntityRegionPath gives the path of the entity region file (1.17+) that goes
+// with a region file, in the entities directory alongside the region directory.
+func EntityRegionPath(regionPath string) string {
+	return filepath.Join(filepath.Dir(regionPath), "..", "entities", filepath.Base(regionPath))
+}
+
+// ReadEntities reads the interesting entities from an entity region file.
+// Chunks from before 1.17, with entities under Level.Entities, are also understood,
+// so a region file can be passed for older worlds. A missing file isn't an error.
+// Like ReadRegion, unreadable chunks are skipped and reported with ChunkErrors.
+func ReadEntities(path string) ([]Entity, error) {
+	var entities []Entity
+	err := readChunks(path, nil, func(chunkNum, xPos, zPos int, data []byte) *ChunkError {
+		var raw []rawEntity
+		err := NbtWalk(data, func(path []string, idxes []int, ty NbtType, value []byte) {
+			// Entities.N.* since 1.17, Level.Entities.N.* before.
+			// Passengers are nested deeper, and aren't included, but the
+			// lists of a custom name saved as a text component are.
+			var ePath []string
+			if len(path) > 2 && path[0] == "Entities" {
+				ePath = path[2:]
+			} else if len(path) > 3 && path[0] == "Level" && path[1] == "Entities" {
+				ePath = path[3:]
+			}
+			if ePath == nil || ty == TagCompound || len(idxes) != 1 && ePath[0] != "CustomName" {
+				return
+			}
+			for idxes[0] >= len(raw) {
+				raw = append(raw, rawEntity{Entity: Entity{X: math.NaN()}})
+			}
+			raw[idxes[0]].field(ePath, ty, value)
+		})
+		if err != nil {
+			return &ChunkError{Reason: "unable to parse NBT", Err: err}
+		}
+		for i := range raw {
+			e := raw[i].finish()
+			if !math.IsNaN(e.X) && interestingEntity(&e) {
+				entities = append(entities, e)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return entities, err
+}
+
+// rawEntity accumulates the fields of an entity during the NBT walk.
+type rawEntity struct {
+	Entity
+	// the custom name, when it's saved as an NBT text component, since 1.21.5
+	name textComponent
+}
+
+// field handles a single NBT value belonging to an entity.
+// path starts just inside the entity's compound.
+func (e *rawEntity) field(path []string, ty NbtType, value []byte) {
+	last := path[len(path)-1]
+	switch {
+	case len(path) == 1:
+		switch last {
+		case "id":
+			e.ID = string(value)
+		case "Pos":
+			if ty == -TagDouble && len(value) == 24 {
+				e.X = math.Float64frombits(binary.BigEndian.Uint64(value))
+				e.Y = math.Float64frombits(binary.BigEndian.Uint64(value[8:]))
+				e.Z = math.Float64frombits(binary.BigEndian.Uint64(value[16:]))
+			}
+		case "CustomName":
+			if ty == TagString {
+				e.Name = plainText(string(value))
+			} else {
+				e.name.add(nil, ty, value)
+			}
+		case "Owner", "OwnerUUID":
+			// an int array since 1.16, a string before
+			e.Tamed = len(value) > 0
+		}
+	case path[0] == "CustomName":
+		e.name.add(path[1:], ty, value)
+	case len(path) == 2 && path[0] == "Item" && last == "id" && ty == TagString:
+		e.Item = string(value)
+	case len(path) == 2 && path[0] == "Pose" && ty == -TagFloat && len(value) == 12:
+		if e.Pose == nil {
+			e.Pose = map[string][3]float32{}
+		}
+		var rot [3]float32
+		for i := range rot {
+			rot[i] = math.Float32frombits(binary.BigEndian.Uint32(value[i*4:]))
+		}
+		e.Pose[last] = rot
+	case len(path) == 2 && path[0] == "VillagerData" && last == "profession" && ty == TagString:
+		e.Profession = string(value)
+	}
+}
+
+// finish gives the entity, with its custom name flattened to plain text.
+func (e *rawEntity) finish() Entity {
+	ret := e.Entity
+	if e.name != nil {
+		ret.Name = e.name.String()
+	}
+	return ret
+}
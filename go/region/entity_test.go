@@ -0,0 +1,115 @@
+package region
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadEntities(t *testing.T) {
+	tag := func(ty byte, name string) []byte {
+		buf := []byte{ty}
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(name)))
+		return append(buf, name...)
+	}
+	str := func(name, v string) []byte {
+		buf := tag(TagString, name)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(v)))
+		return append(buf, v...)
+	}
+	pos := func(x, y, z float64) []byte {
+		buf := append(tag(TagList, "Pos"), TagDouble, 0, 0, 0, 3)
+		for _, v := range []float64{x, y, z} {
+			buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(v))
+		}
+		return buf
+	}
+	entity := func(fields ...[]byte) []byte {
+		var buf []byte
+		for _, f := range fields {
+			buf = append(buf, f...)
+		}
+		return append(buf, TagEnd)
+	}
+
+	// an entity chunk as stored since 1.17
+	nbt := append(tag(TagCompound, ""), tag(TagList, "Entities")...)
+	nbt = append(nbt, TagCompound, 0, 0, 0, 4)
+	nbt = append(nbt, entity(str("id", "minecraft:cow"), pos(1, 2, 3))...)
+	nbt = append(nbt, entity(str("id", "minecraft:wolf"), pos(-10.5, 64, 20.5), str("CustomName", `{"text":"Rex"}`))...)
+	pose := append(tag(TagCompound, "Pose"), tag(TagList, "Head")...)
+	pose = append(pose, TagFloat, 0, 0, 0, 3, 0x42, 0x34, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, TagEnd) // 45, 0, 0
+	nbt = append(nbt, entity(str("id", "minecraft:armor_stand"), pos(0, 70, 0), pose)...)
+	villagerData := append(tag(TagCompound, "VillagerData"), str("profession", "minecraft:librarian")...)
+	nbt = append(nbt, entity(str("id", "minecraft:villager"), pos(5, 65, 5), append(villagerData, TagEnd))...)
+	nbt = append(nbt, TagEnd)
+
+	hdr := make([]byte, 8192)
+	binary.BigEndian.PutUint32(hdr[3*4:], 2<<8|1)
+	sector := make([]byte, 4096)
+	binary.BigEndian.PutUint32(sector, uint32(len(nbt)+1))
+	sector[4] = compressionNone
+	copy(sector[5:], nbt)
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(dir, "entities"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(dir, "entities", "r.0.0.mca"), append(hdr, sector...), 0644))
+
+	entities, err := ReadEntities(EntityRegionPath(path.Join(dir, "region", "r.0.0.mca")))
+	require.NoError(t, err)
+	require.Equal(t, []Entity{
+		{ID: "minecraft:wolf", X: -10.5, Y: 64, Z: 20.5, Name: "Rex"},
+		{ID: "minecraft:armor_stand", Y: 70, Pose: map[string][3]float32{"Head": {45, 0, 0}}},
+		{ID: "minecraft:villager", X: 5, Y: 65, Z: 5, Profession: "minecraft:librarian"},
+	}, entities)
+
+	entities, err = ReadEntities(path.Join(dir, "entities", "r.1.0.mca"))
+	require.NoError(t, err)
+	require.Nil(t, entities)
+}
+
+func TestEntityFields(t *testing.T) {
+	var frame rawEntity
+	frame.field([]string{"id"}, TagString, []byte("minecraft:glow_item_frame"))
+	frame.field([]string{"Item", "id"}, TagString, []byte("minecraft:map"))
+	require.Equal(t, "minecraft:map", frame.Item)
+	require.True(t, interestingEntity(&frame.Entity))
+
+	var cat rawEntity
+	cat.field([]string{"id"}, TagString, []byte("minecraft:cat"))
+	require.False(t, interestingEntity(&cat.Entity))
+	cat.field([]string{"Owner"}, TagIntArray, make([]byte, 16))
+	require.True(t, interestingEntity(&cat.Entity))
+
+	// custom names are text components since 1.21.5
+	var parrot rawEntity
+	parrot.field([]string{"CustomName", "text"}, TagString, []byte("Polly"))
+	parrot.field([]string{"CustomName", "color"}, TagString, []byte("red"))
+	require.Equal(t, "Polly", parrot.finish().Name)
+}
+
+// TestReadEntitiesTextComponents reads entities as saved since 1.21.5, with
+// custom names as NBT text components instead of JSON.
+func TestReadEntitiesTextComponents(t *testing.T) {
+	chunk := encodeSNBT(t, `{DataVersion: 4325, Position: [I; 0, 0], Entities: [
+		{id: "minecraft:wolf", Pos: [1.5d, 64.0d, 2.5d], CustomName: {text: "Rex", extra: [{"": " the "}, {text: "Brave", bold: 1b}]}},
+		{id: "minecraft:cat", Pos: [3.5d, 64.0d, 2.5d], CustomName: "Tom"},
+		{id: "minecraft:horse", Pos: [5.5d, 64.0d, 2.5d], Passengers: [{id: "minecraft:zombie", Pos: [5.5d, 65.0d, 2.5d], CustomName: {text: "Rider"}}]}
+	]}`)
+	var w Writer
+	require.NoError(t, w.AddNBT(0, 0, chunk, time.Unix(1700000000, 0)))
+	fn := path.Join(t.TempDir(), w.Filename())
+	require.NoError(t, w.WriteFile(fn))
+
+	entities, err := ReadEntities(fn)
+	require.NoError(t, err)
+	require.Equal(t, []Entity{
+		{ID: "minecraft:wolf", X: 1.5, Y: 64, Z: 2.5, Name: "Rex the Brave"},
+		{ID: "minecraft:cat", X: 3.5, Y: 64, Z: 2.5, Name: "Tom"},
+	}, entities)
+}
@@ -0,0 +1,57 @@
+package region
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChunkError describes a single chunk that couldn't be read. The rest of
+// the region is still usable; the bad chunk is left empty.
+type ChunkError struct {
+	Index  int // chunk index within the region, x + z*32
+	X, Z   int // absolute chunk coordinates
+	Reason string
+	Err    error // underlying cause, if any
+}
+
+func (e *ChunkError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("chunk %d,%d: %s: %v", e.X, e.Z, e.Reason, e.Err)
+	}
+	return fmt.Sprintf("chunk %d,%d: %s", e.X, e.Z, e.Reason)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// ChunkErrors is returned by ReadRegion alongside the chunk data when
+// some chunks were skipped. Callers can use errors.As to tell it apart from
+// errors that make the whole region unreadable, and decide whether
+// to carry on without the bad chunks.
+type ChunkErrors []*ChunkError
+
+func (e ChunkErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d bad chunks", len(e))
+	for i, ce := range e {
+		if i == 3 {
+			fmt.Fprintf(&sb, "; ...")
+			break
+		}
+		sb.WriteString("; ")
+		sb.WriteString(ce.Error())
+	}
+	return sb.String()
+}
+
+func (e ChunkErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, ce := range e {
+		errs[i] = ce
+	}
+	return errs
+}
@@ -0,0 +1,182 @@
+package region
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// longBytes gives longs as they're stored in NBT.
+func longBytes(longs []int64) []byte {
+	var buf []byte
+	for _, l := range longs {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(l))
+	}
+	return buf
+}
+
+// seedChunks gives the NBT of synthetic chunks in the formats that
+// parseChunk understands: 1.18+, 1.13-1.15 and pre-flattening, for
+// chunks 0,0 to 2,0.
+func seedChunks(t testing.TB) [][]byte {
+	idxes := make([]uint16, 4096)
+	for i := range idxes {
+		idxes[i] = uint16(i % 3)
+	}
+
+	blocks := make([]int8, 4096)
+	for i := range blocks {
+		blocks[i] = int8(i % 3)
+	}
+
+	modern := encodeSNBT(t, `{DataVersion: 3700, xPos: 0, zPos: 0, Status: "minecraft:full",
+		sections: [
+			{Y: -1b, block_states: {palette: [{Name: "minecraft:stone"}]}},
+			{Y: 0b, BlockLight: %s, block_states: {data: %s, palette: [
+				{Name: "minecraft:air"},
+				{Name: "minecraft:stone"},
+				{Name: "minecraft:chest", Properties: {type: "single", facing: "north"}}
+			]}, biomes: {palette: ["minecraft:plains", "minecraft:desert"], data: [L; 1L, 2L]}}
+		],
+		block_entities: [{id: "minecraft:chest", x: 2, y: 0, z: 0,
+			Items: [{id: "minecraft:apple", count: 5b, Slot: 0b}]}]}`,
+		make([]int8, 2048), packStates(idxes, 4, false))
+
+	packed := encodeSNBT(t, `{DataVersion: 1976, Level: {xPos: 1, zPos: 0,
+		Sections: [{Y: 0b, BlockStates: %s, Palette: [{Name: "minecraft:air"}, {Name: "minecraft:stone"}, {Name: "minecraft:dirt"},
+			{Name: "minecraft:sand"}, {Name: "minecraft:gravel"}, {Name: "minecraft:glass"},
+			{Name: "minecraft:clay"}, {Name: "minecraft:ice"}, {Name: "minecraft:snow"},
+			{Name: "minecraft:tnt"}, {Name: "minecraft:obsidian"}, {Name: "minecraft:bricks"},
+			{Name: "minecraft:cobblestone"}, {Name: "minecraft:bedrock"}, {Name: "minecraft:sponge"},
+			{Name: "minecraft:gold_block"}, {Name: "minecraft:iron_block"}]}],
+		Biomes: [I; 1, 2]}}`, packStates(idxes, 5, true))
+
+	legacy := encodeSNBT(t, `{Level: {xPos: 2, zPos: 0, Biomes: %s, Sections: [{Y: 0b, Blocks: %s, Data: %s}],
+		TileEntities: [{id: "Sign", x: 0, y: 0, z: 0, Text1: "{\"text\":\"hi\"}"}]}}`,
+		make([]int8, 256), blocks, make([]int8, 2048))
+
+	return [][]byte{modern, packed, legacy}
+}
+
+// seedRegion puts chunks in a row at the start of region 0,0.
+func seedRegion(t testing.TB, chunks [][]byte) []byte {
+	var w Writer
+	for i, c := range chunks {
+		require.NoError(t, w.AddNBT(i, 0, c, time.Unix(1700000000, 0)))
+	}
+	var buf bytes.Buffer
+	_, err := w.WriteTo(&buf)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestSeedChunks(t *testing.T) {
+	bm := testBlockMapper(t)
+	fn := path.Join(t.TempDir(), "r.0.0.mca")
+	require.NoError(t, os.WriteFile(fn, seedRegion(t, seedChunks(t)), 0644))
+	cdata, err := ReadRegion(fn, bm, nil)
+	require.NoError(t, err)
+
+	stone := bm.NameToNid["minecraft:stone"]
+	require.Equal(t, -16, cdata[0].MinY)
+	require.Equal(t, []uint16{stone, bm.unknownNid("minecraft:chest")}, cdata[0].Blocks[1][1:3])
+	require.Len(t, cdata[0].BlockEntities, 1)
+	require.Equal(t, stone, cdata[1].Blocks[0][1])
+	require.Len(t, cdata[2].Blocks, 1)
+}
+
+func TestNbtWalkTruncated(t *testing.T) {
+	for _, chunk := range seedChunks(t) {
+		require.NoError(t, NbtWalk(chunk, func([]string, []int, NbtType, []byte) {}))
+		for i := 1; i < len(chunk); i++ {
+			require.Error(t, NbtWalk(chunk[:i], func([]string, []int, NbtType, []byte) {}), i)
+		}
+	}
+
+	// an array claiming to be longer than the data
+	err := NbtWalk([]byte{TagCompound, 0, 0, TagLongArray, 0, 1, 'a', 0x7f, 0xff, 0xff, 0xff, TagEnd},
+		func([]string, []int, NbtType, []byte) {})
+	require.ErrorContains(t, err, "bad NBT length")
+	err = NbtWalk([]byte{TagEnd}, func([]string, []int, NbtType, []byte) {})
+	require.ErrorContains(t, err, "unexpected end tag")
+}
+
+func TestBlockstatesMalformed(t *testing.T) {
+	// short, odd-length and empty arrays don't panic
+	for _, n := range []int{0, 7, 8, 100, 2047, 2049, 2560, 4096 * 2} {
+		require.Len(t, blockstatesToShorts116(make([]byte, n)), 4096, n)
+		require.Len(t, blockstatesToShortsPacked(make([]byte, n)), 4096, n)
+	}
+
+	// palette indexes past the end of the palette are an error
+	bm := testBlockMapper(t)
+	rc := &rawChunk{dataVersion: 3700, sections: []rawSection{{
+		y:           0,
+		hasY:        true,
+		palette:     []paletteEntry{{name: "minecraft:air"}, {name: "minecraft:stone"}},
+		blockStates: longBytes(packStates(make([]uint16, 4096), 4, false)),
+	}}}
+	rc.sections[0].blockStates[7] = 0x20
+	_, err := newChunkConverter(bm).convert(rc)
+	require.ErrorContains(t, err, "palette index 2 out of range")
+}
+
+func FuzzNbtWalk(f *testing.F) {
+	for _, chunk := range seedChunks(f) {
+		f.Add(chunk)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		NbtWalk(data, func(path []string, idxes []int, ty NbtType, value []byte) {})
+		parseChunk(data)
+	})
+}
+
+func FuzzBlockstatesToShorts116(f *testing.F) {
+	idxes := make([]uint16, 4096)
+	for i := range idxes {
+		idxes[i] = uint16(i * 7 % 300)
+	}
+	for _, bpb := range []int{4, 5, 9} {
+		f.Add(longBytes(packStates(idxes, bpb, false)))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(blockstatesToShorts116(data)) != 4096 {
+			t.Fatal("wrong length")
+		}
+	})
+}
+
+func FuzzBlockstatesToShortsPacked(f *testing.F) {
+	idxes := make([]uint16, 4096)
+	for i := range idxes {
+		idxes[i] = uint16(i * 7 % 300)
+	}
+	for _, bpb := range []int{4, 5, 9} {
+		f.Add(longBytes(packStates(idxes, bpb, true)))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(blockstatesToShortsPacked(data)) != 4096 {
+			t.Fatal("wrong length")
+		}
+	})
+}
+
+func FuzzReadRegion(f *testing.F) {
+	chunks := seedChunks(f)
+	f.Add(seedRegion(f, chunks))
+	f.Add(seedRegion(f, chunks[:1]))
+	bm := testBlockMapper(f)
+	dir := f.TempDir()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fn := path.Join(dir, "r.0.0.mca")
+		if err := os.WriteFile(fn, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		ReadRegion(fn, bm, nil)
+	})
+}
@@ -0,0 +1,112 @@
+package region
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/rmmh/cubeographer/go/region/nbt"
+)
+
+// LevelDat holds the world settings from level.dat.
+type LevelDat struct {
+	LevelName string `json:"level_name"`
+	SpawnX    int    `json:"spawn_x"`
+	SpawnY    int    `json:"spawn_y"`
+	SpawnZ    int    `json:"spawn_z"`
+	// DataVersion identifies the game version that last saved the world,
+	// and VersionName is its name, like "1.21.4"
+	DataVersion int    `json:"data_version"`
+	VersionName string `json:"version_name"`
+	// Seed isn't served, since it would let anyone generate the rest of the world.
+	Seed      int64             `json:"-"`
+	Hardcore  bool              `json:"hardcore"`
+	GameRules map[string]string `json:"game_rules"`
+}
+
+// ReadLevelDat reads a world's level.dat, which is gzipped NBT.
+func ReadLevelDat(path string) (*LevelDat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, zr); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	ld, err := parseLevelDat(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ld, nil
+}
+
+// levelDatNBT is the parts of level.dat that LevelDat is made from.
+type levelDatNBT struct {
+	Data struct {
+		LevelName              string
+		SpawnX, SpawnY, SpawnZ int
+		// Data.spawn.pos replaced SpawnX/Y/Z in 1.21.9
+		Spawn struct {
+			Pos []int32 `nbt:"pos"`
+		} `nbt:"spawn"`
+		DataVersion int
+		Version     struct {
+			Name string
+		}
+		Hardcore         bool  `nbt:"hardcore"`
+		RandomSeed       int64 // before 1.16
+		WorldGenSettings struct {
+			Seed int64 `nbt:"seed"`
+		}
+		GameRules nbt.Compound
+	}
+}
+
+// parseLevelDat reads the fields of level.dat that are there. Ones that aren't
+// the expected type, as can happen between versions, are left out rather than
+// making the whole file unreadable.
+func parseLevelDat(buf []byte) (*LevelDat, error) {
+	var raw levelDatNBT
+	if err := nbt.UnmarshalLenient(buf, &raw); err != nil {
+		return nil, err
+	}
+	d := &raw.Data
+	ld := &LevelDat{
+		LevelName:   d.LevelName,
+		SpawnX:      d.SpawnX,
+		SpawnY:      d.SpawnY,
+		SpawnZ:      d.SpawnZ,
+		DataVersion: d.DataVersion,
+		VersionName: d.Version.Name,
+		Seed:        d.RandomSeed,
+		Hardcore:    d.Hardcore,
+		GameRules:   map[string]string{},
+	}
+	if len(d.Spawn.Pos) == 3 {
+		ld.SpawnX, ld.SpawnY, ld.SpawnZ = int(d.Spawn.Pos[0]), int(d.Spawn.Pos[1]), int(d.Spawn.Pos[2])
+	}
+	if d.WorldGenSettings.Seed != 0 {
+		ld.Seed = d.WorldGenSettings.Seed
+	}
+	for _, rule := range d.GameRules {
+		// the rules were all strings until they became typed
+		switch v := rule.Value.(type) {
+		case string:
+			ld.GameRules[rule.Name] = v
+		case int8:
+			ld.GameRules[rule.Name] = strconv.FormatBool(v != 0)
+		case int32:
+			ld.GameRules[rule.Name] = strconv.Itoa(int(v))
+		}
+	}
+	return ld, nil
+}
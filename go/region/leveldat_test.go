@@ -0,0 +1,118 @@
+package region
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/rmmh/cubeographer/go/region/nbt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadLevelDat(t *testing.T) {
+	tag := func(ty byte, name string) []byte {
+		buf := []byte{ty}
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(name)))
+		return append(buf, name...)
+	}
+	str := func(name, v string) []byte {
+		buf := tag(TagString, name)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(v)))
+		return append(buf, v...)
+	}
+	int32Tag := func(name string, v int32) []byte {
+		return binary.BigEndian.AppendUint32(tag(TagInt, name), uint32(v))
+	}
+	int64Tag := func(name string, v int64) []byte {
+		return binary.BigEndian.AppendUint64(tag(TagLong, name), uint64(v))
+	}
+	compound := func(name string, fields ...[]byte) []byte {
+		buf := tag(TagCompound, name)
+		for _, f := range fields {
+			buf = append(buf, f...)
+		}
+		return append(buf, TagEnd)
+	}
+
+	// as saved by 1.21.4
+	nbt := compound("", compound("Data",
+		str("LevelName", "Novigrad"),
+		int32Tag("DataVersion", 4189),
+		compound("Version", str("Name", "1.21.4"), int32Tag("Id", 4189)),
+		int32Tag("SpawnX", -120),
+		int32Tag("SpawnY", 70),
+		int32Tag("SpawnZ", 45),
+		append(tag(TagByte, "hardcore"), 1),
+		compound("WorldGenSettings", int64Tag("seed", -4172144997902289642)),
+		compound("GameRules", str("doDaylightCycle", "false"), str("randomTickSpeed", "3")),
+	))
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(nbt)
+	require.NoError(t, zw.Close())
+	fname := path.Join(t.TempDir(), "level.dat")
+	require.NoError(t, os.WriteFile(fname, buf.Bytes(), 0644))
+
+	ld, err := ReadLevelDat(fname)
+	require.NoError(t, err)
+	require.Equal(t, &LevelDat{
+		LevelName:   "Novigrad",
+		SpawnX:      -120,
+		SpawnY:      70,
+		SpawnZ:      45,
+		DataVersion: 4189,
+		VersionName: "1.21.4",
+		Seed:        -4172144997902289642,
+		Hardcore:    true,
+		GameRules:   map[string]string{"doDaylightCycle": "false", "randomTickSpeed": "3"},
+	}, ld)
+
+	// the spawn moved in 1.21.9, and the seed was at the top level before 1.16
+	pos := binary.BigEndian.AppendUint32(tag(TagIntArray, "pos"), 3)
+	for _, v := range []int32{8, -60, -8} {
+		pos = binary.BigEndian.AppendUint32(pos, uint32(v))
+	}
+	ld, err = parseLevelDat(compound("", compound("Data",
+		compound("spawn", pos, str("dimension", "minecraft:overworld")),
+		int64Tag("RandomSeed", 42),
+	)))
+	require.NoError(t, err)
+	require.Equal(t, [3]int{8, -60, -8}, [3]int{ld.SpawnX, ld.SpawnY, ld.SpawnZ})
+	require.Equal(t, int64(42), ld.Seed)
+
+	_, err = ReadLevelDat(path.Join(t.TempDir(), "level.dat"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestParseLevelDatTypes(t *testing.T) {
+	encode := func(snbt string) []byte {
+		root, err := nbt.ParseSNBT(snbt)
+		require.NoError(t, err)
+		buf, err := nbt.Encode("", root.(nbt.Compound))
+		require.NoError(t, err)
+		return buf
+	}
+
+	// the game rules became typed
+	ld, err := parseLevelDat(encode(`{Data: {
+		LevelName: "Novigrad",
+		GameRules: {doDaylightCycle: 0b, randomTickSpeed: 3}
+	}}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"doDaylightCycle": "false", "randomTickSpeed": "3"}, ld.GameRules)
+
+	// fields that aren't the expected type are skipped, not fatal
+	ld, err = parseLevelDat(encode(`{Data: {
+		LevelName: "Novigrad",
+		SpawnX: "far away", SpawnY: 70, SpawnZ: 45,
+		Version: {Name: 4189},
+		spawn: {pos: [I; 8, -60]}
+	}}`))
+	require.NoError(t, err)
+	require.Equal(t, "Novigrad", ld.LevelName)
+	require.Equal(t, [3]int{0, 70, 45}, [3]int{ld.SpawnX, ld.SpawnY, ld.SpawnZ})
+	require.Empty(t, ld.VersionName)
+}
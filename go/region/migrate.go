@@ -203,7 +203,9 @@ func (bm *BlockMapper) precalculateMigrations() {
 func (bm *BlockMapper) migrate(vfrom int, palettes [][]paletteEntry) {
 	vto := bm.meta.WorldVersion
 	if vfrom >= vto {
-		// TODO: warn on reading files that are newer than blockmeta
+		if vfrom > vto {
+			bm.noteNewerVersion(vfrom)
+		}
 		return
 	}
 
@@ -36,3 +36,15 @@ func TestMigration(t *testing.T) {
 		require.Equal(t, tc.expected, pal[0][0].name, "migrate(%d, %d, %q)", tc.vfrom, tc.vto, tc.input)
 	}
 }
+
+func TestMigrationNewerVersion(t *testing.T) {
+	bm := BlockMapper{meta: render.BlockEntryMetadata{WorldVersion: 3700}}
+	bm.precalculateMigrations()
+	pal := [][]paletteEntry{{{name: "minecraft:chain"}}}
+	bm.migrate(3600, pal)
+	require.Zero(t, bm.NewerVersion())
+	bm.migrate(4600, pal)
+	bm.migrate(4500, pal)
+	require.Equal(t, 4600, bm.NewerVersion())
+	require.Equal(t, "minecraft:chain", pal[0][0].name)
+}
@@ -31,14 +31,44 @@ type nbtList struct {
 	idx    int
 }
 
-// a stream-oriented zero-copy nbt parser
+// a stream-oriented zero-copy nbt parser. Every read is bounds-checked,
+// so truncated or corrupt data is an error rather than a panic.
 func NbtWalk(buf []byte, cb func(path []string, idxes []int, ty NbtType, value []byte)) error {
 	path := []string{}
 	idxes := []int{}
 	listStack := []nbtList{}
 	depth := 0
 	var ty NbtType
-	for o := 0; o < len(buf); {
+	o := 0
+	// take returns the next n bytes
+	take := func(n int) ([]byte, error) {
+		if n < 0 || n > len(buf)-o {
+			return nil, fmt.Errorf("truncated NBT: %v at offset %d needs %d bytes, but only %d remain", path, o, n, len(buf)-o)
+		}
+		o += n
+		return buf[o-n : o], nil
+	}
+	// count reads the length of an array or list of elements of the given size
+	count := func(size int) (int, error) {
+		b, err := take(4)
+		if err != nil {
+			return 0, err
+		}
+		n := int(int32(binary.BigEndian.Uint32(b)))
+		if n < 0 || n > (len(buf)-o)/size {
+			return 0, fmt.Errorf("bad NBT length %d at %v (offset %d)", n, path, o)
+		}
+		return n, nil
+	}
+	// array reads a length-prefixed array of elements of the given size
+	array := func(size int) ([]byte, error) {
+		n, err := count(size)
+		if err != nil {
+			return nil, err
+		}
+		return take(n * size)
+	}
+	for o < len(buf) {
 		if len(listStack) > 0 && listStack[len(listStack)-1].depth == depth {
 			lt := &listStack[len(listStack)-1]
 			lt.idx++
@@ -58,94 +88,114 @@ func NbtWalk(buf []byte, cb func(path []string, idxes []int, ty NbtType, value [
 				o++
 				depth--
 				if depth < 0 {
-					return fmt.Errorf("unexpected end tag %v, %v", ty, buf[o-1:o+100])
+					return fmt.Errorf("unexpected end tag at offset %d", o-1)
 				}
 				continue
 			}
-			tagLen := int(binary.BigEndian.Uint16(buf[o+1:]))
-			tag := buf[o+3 : o+3+tagLen]
+			hdr, err := take(3)
+			if err != nil {
+				return err
+			}
+			tag, err := take(int(binary.BigEndian.Uint16(hdr[1:])))
+			if err != nil {
+				return err
+			}
 			path = append(path[:depth], string(tag))
-			o += 3 + tagLen
 		}
+		var value []byte
+		var err error
 		switch ty {
 		case TagCompound:
 			cb(path[1:], idxes, ty, nil)
 			depth++
+			continue
 		case TagByte:
-			cb(path[1:], idxes, ty, buf[o:o+1])
-			o++
+			value, err = take(1)
 		case TagShort:
-			cb(path[1:], idxes, ty, buf[o:o+2])
-			o += 2
-		case TagInt:
-			cb(path[1:], idxes, ty, buf[o:o+4])
-			o += 4
-		case TagLong:
-			cb(path[1:], idxes, ty, buf[o:o+8])
-			o += 8
-		case TagFloat:
-			cb(path[1:], idxes, ty, buf[o:o+4])
-			o += 4
-		case TagDouble:
-			cb(path[1:], idxes, ty, buf[o:o+8])
-			o += 8
+			value, err = take(2)
+		case TagInt, TagFloat:
+			value, err = take(4)
+		case TagLong, TagDouble:
+			value, err = take(8)
 		case TagByteArray:
-			len := binary.BigEndian.Uint32(buf[o:])
-			cb(path[1:], idxes, ty, buf[o+4:o+4+int(len)])
-			o += 4 + int(len)
-		case TagString:
-			len := binary.BigEndian.Uint16(buf[o:])
-			cb(path[1:], idxes, ty, buf[o+2:o+2+int(len)])
-			o += 2 + int(len)
+			value, err = array(1)
 		case TagIntArray:
-			len := binary.BigEndian.Uint32(buf[o:])
-			cb(path[1:], idxes, ty, buf[o+4:o+4+int(len)*4])
-			o += 4 + int(len)*4
+			value, err = array(4)
 		case TagLongArray:
-			len := binary.BigEndian.Uint32(buf[o:])
-			cb(path[1:], idxes, ty, buf[o+4:o+4+int(len)*8])
-			o += 4 + int(len)*8
+			value, err = array(8)
+		case TagString:
+			var b []byte
+			if b, err = take(2); err == nil {
+				value, err = take(int(binary.BigEndian.Uint16(b)))
+			}
 		case TagList:
-			lty := NbtType(buf[o])
-			len := int(binary.BigEndian.Uint32(buf[o+1:]))
-			o += 5
+			var b []byte
+			if b, err = take(1); err != nil {
+				return err
+			}
+			lty := NbtType(b[0])
 			if lty >= TagByte && lty <= TagDouble {
 				ltyLen := int(lty)
 				if lty > 2 {
 					ltyLen = 4 + 4*int((lty-3)%2)
 				}
-				cb(path[1:], idxes, -lty, buf[o:o+len*ltyLen])
-				o += len * ltyLen
-			} else if lty == TagCompound {
-				if len > 0 {
-					depth++
-					listStack = append(listStack, nbtList{depth: depth, ty: TagCompound, length: len, idx: 0})
+				n, err := count(ltyLen)
+				if err != nil {
+					return err
 				}
-			} else if lty == TagList {
-				if len > 0 {
-					depth++
-					listStack = append(listStack, nbtList{depth: depth, ty: TagList, length: len, idx: 0})
+				value, _ = take(n * ltyLen)
+				cb(path[1:], idxes, -lty, value)
+				continue
+			}
+			switch lty {
+			case TagCompound, TagList, TagByteArray, TagIntArray, TagLongArray:
+				// each is at least a byte
+				n, err := count(1)
+				if err != nil {
+					return err
 				}
-			} else if lty == TagIntArray {
-				if len > 0 {
+				if n > 0 {
 					depth++
-					listStack = append(listStack, nbtList{depth: depth, ty: TagIntArray, length: len, idx: 0})
+					listStack = append(listStack, nbtList{depth: depth, ty: lty, length: n, idx: 0})
 				}
-			} else if lty == TagString {
+			case TagString:
 				// e.g. Level.TileEntities.Items.tag.pages
+				n, err := count(2)
+				if err != nil {
+					return err
+				}
 				start := o
-				for i := 0; i < len; i++ {
-					o += int(binary.BigEndian.Uint16(buf[o:])) + 2
+				for range n {
+					b, err := take(2)
+					if err != nil {
+						return err
+					}
+					if _, err := take(int(binary.BigEndian.Uint16(b))); err != nil {
+						return err
+					}
 				}
 				cb(path[1:], idxes, -lty, buf[start:o])
-			} else if len > 0 {
-				// TileEntities is length=0 and type=0 when empty?
-				return fmt.Errorf("unhandled TAG_List type: %d at %v (len %d)", lty, path, len)
+			default:
+				// TileEntities is length=0 and type=0 when empty
+				n, err := count(1)
+				if err != nil {
+					return err
+				}
+				if n > 0 {
+					return fmt.Errorf("unhandled TAG_List type: %d at %v (len %d)", lty, path, n)
+				}
 			}
+			continue
 		default:
 			return fmt.Errorf("unhandled nbt tag type: %d at %v", ty, path)
 		}
-
+		if err != nil {
+			return err
+		}
+		cb(path[1:], idxes, ty, value)
+	}
+	if depth > 0 {
+		return fmt.Errorf("truncated NBT: %v is unfinished", path)
 	}
 	return nil
 }
@@ -0,0 +1,432 @@
+package nbt
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Unmarshal decodes binary NBT into v, which must be a pointer. Compounds
+// are decoded into structs, whose fields are named by their "nbt" tags like
+// encoding/json, or into maps with string keys. Numbers are converted to the
+// field's type if they fit, and bytes into bools. A Value or Compound field
+// is given the decoded tree as it is.
+func Unmarshal(buf []byte, v any) error {
+	_, root, err := Decode(buf)
+	if err != nil {
+		return err
+	}
+	return UnmarshalValue(root, v)
+}
+
+// UnmarshalValue is Unmarshal for a value that's already been decoded.
+func UnmarshalValue(src Value, v any) error {
+	return unmarshaler{}.unmarshal(src, v)
+}
+
+// UnmarshalLenient is Unmarshal, except that a compound's entries that can't
+// be converted to their field or map element's type are skipped, leaving the
+// field zero or the map without them, instead of failing the whole value. It suits files like level.dat, which the game
+// changes between versions and only some of which is wanted.
+func UnmarshalLenient(buf []byte, v any) error {
+	_, root, err := Decode(buf)
+	if err != nil {
+		return err
+	}
+	return unmarshaler{lenient: true}.unmarshal(root, v)
+}
+
+type unmarshaler struct {
+	lenient bool // skip mismatched compound entries
+}
+
+func (u unmarshaler) unmarshal(src Value, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("nbt: can't unmarshal into %T", v)
+	}
+	return u.assign(rv.Elem(), src)
+}
+
+var (
+	compoundType = reflect.TypeFor[Compound]()
+	anyListType  = reflect.TypeFor[AnyList]()
+)
+
+// integer returns the value of an integer tag.
+func integer(src Value) (int64, bool) {
+	switch v := src.(type) {
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+// elements returns the elements of an array or list.
+func elements(src Value) ([]Value, bool) {
+	var ret []Value
+	switch v := src.(type) {
+	case []int8:
+		for _, e := range v {
+			ret = append(ret, e)
+		}
+	case []int32:
+		for _, e := range v {
+			ret = append(ret, e)
+		}
+	case []int64:
+		for _, e := range v {
+			ret = append(ret, e)
+		}
+	case AnyList:
+		for i := range v.Len() {
+			ret = append(ret, v.Index(i))
+		}
+	default:
+		return nil, false
+	}
+	return ret, true
+}
+
+// fieldName returns the tag name of a struct field, and whether it's omitted
+// when empty, or "" if it's skipped.
+func fieldName(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	name, opts, _ := strings.Cut(f.Tag.Get("nbt"), ",")
+	if name == "-" && opts == "" {
+		return "", false
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return name, opts == "omitempty"
+}
+
+func (u unmarshaler) assign(dst reflect.Value, src Value) error {
+	mismatch := func() error {
+		return fmt.Errorf("nbt: can't unmarshal %v into %v", TagOf(src), dst.Type())
+	}
+	if dst.Kind() == reflect.Interface {
+		if src == nil || !reflect.TypeOf(src).AssignableTo(dst.Type()) {
+			return mismatch()
+		}
+		dst.Set(reflect.ValueOf(src))
+		return nil
+	}
+	if dst.Type() == compoundType {
+		c, ok := src.(Compound)
+		if !ok {
+			return mismatch()
+		}
+		dst.Set(reflect.ValueOf(c))
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Pointer:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return u.assign(dst.Elem(), src)
+	case reflect.Bool:
+		i, ok := integer(src)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetBool(i != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := integer(src)
+		if !ok || dst.OverflowInt(i) {
+			return mismatch()
+		}
+		dst.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := integer(src)
+		if ok && i < 0 && TagOf(src) == uintTag(dst.Kind()) {
+			// the same bits, like a byte of a []byte
+			i &= 1<<(dst.Type().Bits()) - 1
+		}
+		if !ok || i < 0 || dst.OverflowUint(uint64(i)) {
+			return mismatch()
+		}
+		dst.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		switch v := src.(type) {
+		case float32:
+			dst.SetFloat(float64(v))
+		case float64:
+			dst.SetFloat(v)
+		default:
+			i, ok := integer(src)
+			if !ok {
+				return mismatch()
+			}
+			dst.SetFloat(float64(i))
+		}
+	case reflect.String:
+		s, ok := src.(string)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetString(s)
+	case reflect.Slice, reflect.Array:
+		elems, ok := elements(src)
+		if !ok {
+			return mismatch()
+		}
+		if dst.Kind() == reflect.Array {
+			if len(elems) != dst.Len() {
+				return fmt.Errorf("nbt: can't unmarshal %d elements into %v", len(elems), dst.Type())
+			}
+		} else {
+			dst.Set(reflect.MakeSlice(dst.Type(), len(elems), len(elems)))
+		}
+		for i, e := range elems {
+			if err := u.assign(dst.Index(i), e); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+	case reflect.Map:
+		c, ok := src.(Compound)
+		if !ok || dst.Type().Key().Kind() != reflect.String {
+			return mismatch()
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(dst.Type()))
+		}
+		for _, f := range c {
+			v := reflect.New(dst.Type().Elem()).Elem()
+			if err := u.assign(v, f.Value); err != nil {
+				if u.lenient {
+					continue
+				}
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+			dst.SetMapIndex(reflect.ValueOf(f.Name).Convert(dst.Type().Key()), v)
+		}
+	case reflect.Struct:
+		c, ok := src.(Compound)
+		if !ok {
+			return mismatch()
+		}
+		t := dst.Type()
+		for i := range t.NumField() {
+			name, _ := fieldName(t.Field(i))
+			if name == "" {
+				continue
+			}
+			if v := c.Get(name); v != nil {
+				if err := u.assign(dst.Field(i), v); err != nil {
+					if u.lenient {
+						dst.Field(i).SetZero()
+						continue
+					}
+					return fmt.Errorf("%s: %w", name, err)
+				}
+			}
+		}
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+// uintTag is the tag whose bits an unsigned type reuses.
+func uintTag(k reflect.Kind) Tag {
+	switch k {
+	case reflect.Uint8:
+		return TagByte
+	case reflect.Uint16:
+		return TagShort
+	case reflect.Uint32:
+		return TagInt
+	}
+	return TagLong
+}
+
+// Marshal encodes v, which must be a struct, a map with string keys or a
+// Compound, as binary NBT with the given root name. Go types are encoded as
+// the tag of the same size: bools as bytes, ints as TAG_Int, unsigned types
+// as the signed tag with the same bits, and []int8, []byte, []int32, []int
+// and []int64 as arrays. Other slices are encoded as lists, and structs and
+// maps as compounds, with map keys in sorted order. Nil pointers and
+// interfaces, and empty fields tagged omitempty, are left out.
+func Marshal(name string, v any) ([]byte, error) {
+	tree, err := MarshalValue(v)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := tree.(Compound)
+	if !ok {
+		return nil, fmt.Errorf("nbt: can't marshal %T as the root", v)
+	}
+	return Encode(name, root)
+}
+
+// MarshalValue converts v to a tree, as Marshal does.
+func MarshalValue(v any) (Value, error) {
+	return toValue(reflect.ValueOf(v))
+}
+
+func toValue(v reflect.Value) (Value, error) {
+	if !v.IsValid() {
+		return nil, fmt.Errorf("nbt: can't marshal nil")
+	}
+	t := v.Type()
+	if t == compoundType {
+		return v.Interface(), nil
+	}
+	if t.Implements(anyListType) && t.Kind() != reflect.Interface {
+		return v.Interface(), nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil, fmt.Errorf("nbt: can't marshal nil %v", t)
+		}
+		return toValue(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return int8(1), nil
+		}
+		return int8(0), nil
+	case reflect.Int8:
+		return int8(v.Int()), nil
+	case reflect.Int16:
+		return int16(v.Int()), nil
+	case reflect.Int32:
+		return int32(v.Int()), nil
+	case reflect.Int:
+		if v.Int() < math.MinInt32 || v.Int() > math.MaxInt32 {
+			return nil, fmt.Errorf("nbt: %d doesn't fit in a %v", v.Int(), TagInt)
+		}
+		return int32(v.Int()), nil
+	case reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint8:
+		return int8(v.Uint()), nil
+	case reflect.Uint16:
+		return int16(v.Uint()), nil
+	case reflect.Uint32:
+		return int32(v.Uint()), nil
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return int64(v.Uint()), nil
+	case reflect.Float32:
+		return float32(v.Float()), nil
+	case reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice, reflect.Array:
+		switch t.Elem().Kind() {
+		case reflect.Int8, reflect.Uint8:
+			arr := make([]int8, v.Len())
+			for i := range arr {
+				if t.Elem().Kind() == reflect.Int8 {
+					arr[i] = int8(v.Index(i).Int())
+				} else {
+					arr[i] = int8(v.Index(i).Uint())
+				}
+			}
+			return arr, nil
+		case reflect.Int32, reflect.Int:
+			arr := make([]int32, v.Len())
+			for i := range arr {
+				e, err := toValue(v.Index(i))
+				if err != nil {
+					return nil, err
+				}
+				arr[i] = e.(int32)
+			}
+			return arr, nil
+		case reflect.Int64:
+			arr := make([]int64, v.Len())
+			for i := range arr {
+				arr[i] = v.Index(i).Int()
+			}
+			return arr, nil
+		}
+		elems := make([]Value, v.Len())
+		ety := TagEnd
+		for i := range elems {
+			e, err := toValue(v.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			if i == 0 {
+				ety = TagOf(e)
+			} else if TagOf(e) != ety {
+				return nil, fmt.Errorf("nbt: [%d]: can't mix %v and %v in a list", i, ety, TagOf(e))
+			}
+			elems[i] = e
+		}
+		if ety == TagEnd && len(elems) == 0 {
+			// the type of an empty list still matters to the game
+			if z, err := toValue(reflect.Zero(t.Elem())); err == nil {
+				ety = TagOf(z)
+			}
+		}
+		return newList(ety, elems)
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("nbt: can't marshal %v", t)
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		c := Compound{}
+		for _, k := range keys {
+			mv := v.MapIndex(k)
+			if (mv.Kind() == reflect.Pointer || mv.Kind() == reflect.Interface) && mv.IsNil() {
+				continue
+			}
+			e, err := toValue(mv)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k.String(), err)
+			}
+			c = append(c, Field{k.String(), e})
+		}
+		return c, nil
+	case reflect.Struct:
+		c := Compound{}
+		for i := range t.NumField() {
+			name, omitEmpty := fieldName(t.Field(i))
+			if name == "" {
+				continue
+			}
+			fv := v.Field(i)
+			if (fv.Kind() == reflect.Pointer || fv.Kind() == reflect.Interface) && fv.IsNil() {
+				continue
+			}
+			if omitEmpty && isEmpty(fv) {
+				continue
+			}
+			e, err := toValue(fv)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			c = append(c, Field{name, e})
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("nbt: can't marshal %v", t)
+}
+
+// isEmpty is like encoding/json's omitempty: false, 0, and empty strings,
+// slices and maps.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
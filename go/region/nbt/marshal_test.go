@@ -0,0 +1,119 @@
+package nbt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testItem struct {
+	ID    string `nbt:"id"`
+	Count int8   `nbt:"count"`
+	Slot  *int8  `nbt:"Slot"`
+}
+
+type testEntity struct {
+	ID       string           `nbt:"id"`
+	Pos      [3]float64       `nbt:"Pos"`
+	Rotation []float32        `nbt:"Rotation"`
+	UUID     []int32          `nbt:"UUID"`
+	OnGround bool             `nbt:"OnGround"`
+	Items    []testItem       `nbt:"Items"`
+	Tags     []string         `nbt:"Tags,omitempty"`
+	Attrs    map[string]int64 `nbt:"attrs"`
+	Data     Compound         `nbt:"data"`
+	Extra    Value            `nbt:"extra"`
+	Light    []byte           `nbt:"light"`
+	Skipped  string           `nbt:"-"`
+	Age      int
+	Scores   map[string]string `nbt:"scores,omitempty"`
+}
+
+func TestMarshal(t *testing.T) {
+	slot := int8(3)
+	e := testEntity{
+		ID:       "minecraft:chest_minecart",
+		Pos:      [3]float64{1.5, 64, -2.5},
+		Rotation: []float32{90, 0},
+		UUID:     []int32{1, 2, 3, 4},
+		OnGround: true,
+		Items:    []testItem{{"minecraft:apple", 5, &slot}, {ID: "minecraft:stick", Count: 1}},
+		Attrs:    map[string]int64{"b": 2, "a": 1},
+		Data:     Compound{{"x", int8(1)}},
+		Extra:    List[string]{"a", "b"},
+		Light:    []byte{0, 255},
+		Skipped:  "not saved",
+		Age:      -7,
+	}
+	buf, err := Marshal("", e)
+	require.NoError(t, err)
+
+	_, root, err := Decode(buf)
+	require.NoError(t, err)
+	require.Equal(t, Compound{
+		{"id", "minecraft:chest_minecart"},
+		{"Pos", List[float64]{1.5, 64, -2.5}},
+		{"Rotation", List[float32]{90, 0}},
+		{"UUID", []int32{1, 2, 3, 4}},
+		{"OnGround", int8(1)},
+		{"Items", List[Compound]{
+			{{"id", "minecraft:apple"}, {"count", int8(5)}, {"Slot", int8(3)}},
+			{{"id", "minecraft:stick"}, {"count", int8(1)}},
+		}},
+		{"attrs", Compound{{"a", int64(1)}, {"b", int64(2)}}},
+		{"data", Compound{{"x", int8(1)}}},
+		{"extra", List[string]{"a", "b"}},
+		{"light", []int8{0, -1}},
+		{"Age", int32(-7)},
+	}, root)
+
+	var got testEntity
+	require.NoError(t, Unmarshal(buf, &got))
+	e.Skipped = ""
+	require.Equal(t, e, got)
+
+	// numbers are converted if they fit
+	var small struct {
+		A int64   `nbt:"a"`
+		B float64 `nbt:"b"`
+		C uint8   `nbt:"c"`
+		D bool    `nbt:"d"`
+	}
+	require.NoError(t, UnmarshalValue(Compound{{"a", int8(-1)}, {"b", int32(2)}, {"c", int16(200)}, {"d", int32(5)}}, &small))
+	require.Equal(t, int64(-1), small.A)
+	require.Equal(t, 2.0, small.B)
+	require.Equal(t, uint8(200), small.C)
+	require.True(t, small.D)
+	require.ErrorContains(t, UnmarshalValue(Compound{{"c", int32(300)}}, &small), "c: ")
+	require.Error(t, UnmarshalValue(Compound{{"a", "1"}}, &small))
+	require.Error(t, UnmarshalValue(Compound{}, small))
+
+	// unless lenient, when the mismatched entries are skipped
+	buf, err = Marshal("", Compound{{"a", "1"}, {"b", int32(3)}, {"c", List[int32]{1}}, {"attrs", Compound{{"x", int64(1)}, {"y", "2"}}}})
+	require.NoError(t, err)
+	var lenient struct {
+		A     int64            `nbt:"a"`
+		B     float64          `nbt:"b"`
+		C     []string         `nbt:"c"`
+		Attrs map[string]int64 `nbt:"attrs"`
+	}
+	lenient.A = 7
+	require.NoError(t, UnmarshalLenient(buf, &lenient))
+	require.Zero(t, lenient.A)
+	require.Equal(t, 3.0, lenient.B)
+	require.Nil(t, lenient.C)
+	require.Equal(t, map[string]int64{"x": 1}, lenient.Attrs)
+	require.Error(t, Unmarshal(buf, &lenient))
+
+	// empty lists keep their type
+	buf, err = Marshal("", struct{ Items []testItem }{})
+	require.NoError(t, err)
+	_, root, err = Decode(buf)
+	require.NoError(t, err)
+	require.Equal(t, Compound{{"Items", List[Compound]{}}}, root)
+
+	_, err = Marshal("", []string{"not a compound"})
+	require.Error(t, err)
+	_, err = Marshal("", struct{ Mixed []any }{[]any{int8(1), "a"}})
+	require.Error(t, err)
+}
@@ -0,0 +1,513 @@
+// Package nbt decodes and encodes Minecraft's Named Binary Tag format as trees
+// of typed values, and marshals Go structs to and from it. Unlike
+// region.NbtWalk, which streams tags without copying them, it's meant for
+// small documents like level.dat, and for writing test fixtures.
+package nbt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Tag is the type of a tag.
+type Tag byte
+
+const (
+	TagEnd Tag = iota
+	TagByte
+	TagShort
+	TagInt
+	TagLong
+	TagFloat
+	TagDouble
+	TagByteArray
+	TagString
+	TagList
+	TagCompound
+	TagIntArray
+	TagLongArray
+)
+
+var tagNames = []string{
+	"TAG_End", "TAG_Byte", "TAG_Short", "TAG_Int", "TAG_Long", "TAG_Float", "TAG_Double",
+	"TAG_Byte_Array", "TAG_String", "TAG_List", "TAG_Compound", "TAG_Int_Array", "TAG_Long_Array",
+}
+
+func (t Tag) String() string {
+	if int(t) < len(tagNames) {
+		return tagNames[t]
+	}
+	return fmt.Sprintf("TAG_%d", t)
+}
+
+// Value is the value of a tag, which is one of:
+//
+//	int8       TAG_Byte
+//	int16      TAG_Short
+//	int32      TAG_Int
+//	int64      TAG_Long
+//	float32    TAG_Float
+//	float64    TAG_Double
+//	[]int8     TAG_Byte_Array
+//	string     TAG_String
+//	List[T]    TAG_List, of any of these, or List[AnyList] for lists of lists
+//	Compound   TAG_Compound
+//	[]int32    TAG_Int_Array
+//	[]int64    TAG_Long_Array
+type Value = any
+
+// TagOf returns the tag of a value, or TagEnd if it isn't one.
+func TagOf(v Value) Tag {
+	switch v := v.(type) {
+	case int8:
+		return TagByte
+	case int16:
+		return TagShort
+	case int32:
+		return TagInt
+	case int64:
+		return TagLong
+	case float32:
+		return TagFloat
+	case float64:
+		return TagDouble
+	case []int8:
+		return TagByteArray
+	case string:
+		return TagString
+	case Compound:
+		return TagCompound
+	case []int32:
+		return TagIntArray
+	case []int64:
+		return TagLongArray
+	case AnyList:
+		if v != nil {
+			return TagList
+		}
+	}
+	return TagEnd
+}
+
+// Field is a named tag in a compound.
+type Field struct {
+	Name  string
+	Value Value
+}
+
+// Compound is a TAG_Compound. Its tags are kept in order, so that encoding
+// a decoded compound gives the same bytes.
+type Compound []Field
+
+// Get returns the value of a tag, or nil if there isn't one.
+func (c Compound) Get(name string) Value {
+	for _, f := range c {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return nil
+}
+
+// Set replaces the value of a tag, or adds it at the end.
+func (c *Compound) Set(name string, v Value) {
+	for i, f := range *c {
+		if f.Name == name {
+			(*c)[i].Value = v
+			return
+		}
+	}
+	*c = append(*c, Field{name, v})
+}
+
+// MarshalJSON writes a compound as an object, keeping its tags in order.
+func (c Compound) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, f := range c {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		name, _ := json.Marshal(f.Name)
+		buf = append(append(buf, name...), ':')
+		v, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, v...)
+	}
+	return append(buf, '}'), nil
+}
+
+// AnyList is implemented by every List, whatever its element type.
+type AnyList interface {
+	ElemType() Tag
+	Len() int
+	Index(i int) Value
+}
+
+// List is a TAG_List. T is one of the types of Value, with List[AnyList] for
+// lists of lists, and List[any] for the empty lists of TAG_End that the game
+// writes when it doesn't know the element type.
+type List[T any] []T
+
+// ElemType returns the tag of the list's elements.
+func (List[T]) ElemType() Tag {
+	switch any((*T)(nil)).(type) {
+	case *int8:
+		return TagByte
+	case *int16:
+		return TagShort
+	case *int32:
+		return TagInt
+	case *int64:
+		return TagLong
+	case *float32:
+		return TagFloat
+	case *float64:
+		return TagDouble
+	case *[]int8:
+		return TagByteArray
+	case *string:
+		return TagString
+	case *AnyList:
+		return TagList
+	case *Compound:
+		return TagCompound
+	case *[]int32:
+		return TagIntArray
+	case *[]int64:
+		return TagLongArray
+	}
+	return TagEnd
+}
+
+func (l List[T]) Len() int          { return len(l) }
+func (l List[T]) Index(i int) Value { return l[i] }
+
+// newList makes a list of elements of the given type from values of that type.
+func newList(ty Tag, elems []Value) (AnyList, error) {
+	switch ty {
+	case TagEnd:
+		if len(elems) > 0 {
+			return nil, fmt.Errorf("nbt: list of %d %v", len(elems), ty)
+		}
+		return List[any]{}, nil
+	case TagByte:
+		return makeList[int8](elems)
+	case TagShort:
+		return makeList[int16](elems)
+	case TagInt:
+		return makeList[int32](elems)
+	case TagLong:
+		return makeList[int64](elems)
+	case TagFloat:
+		return makeList[float32](elems)
+	case TagDouble:
+		return makeList[float64](elems)
+	case TagByteArray:
+		return makeList[[]int8](elems)
+	case TagString:
+		return makeList[string](elems)
+	case TagList:
+		return makeList[AnyList](elems)
+	case TagCompound:
+		return makeList[Compound](elems)
+	case TagIntArray:
+		return makeList[[]int32](elems)
+	case TagLongArray:
+		return makeList[[]int64](elems)
+	}
+	return nil, fmt.Errorf("nbt: unknown tag type %d", ty)
+}
+
+func makeList[T any](elems []Value) (AnyList, error) {
+	l := make(List[T], len(elems))
+	for i, e := range elems {
+		v, ok := e.(T)
+		if !ok {
+			return nil, fmt.Errorf("nbt: can't put %T in a list of %v", e, l.ElemType())
+		}
+		l[i] = v
+	}
+	return l, nil
+}
+
+// maxDepth is how deeply compounds and lists can nest, as in the game.
+const maxDepth = 512
+
+var errShort = errors.New("nbt: unexpected end of data")
+
+type decoder struct {
+	buf   []byte
+	depth int
+}
+
+func (d *decoder) take(n int) ([]byte, error) {
+	if n < 0 || n > len(d.buf) {
+		return nil, errShort
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func (d *decoder) string() (string, error) {
+	b, err := d.take(2)
+	if err != nil {
+		return "", err
+	}
+	b, err = d.take(int(binary.BigEndian.Uint16(b)))
+	return string(b), err
+}
+
+// count reads the length of an array or list, and checks that there's room
+// for that many elements of at least the given size, before they're allocated.
+func (d *decoder) count(size int) (int, error) {
+	b, err := d.take(4)
+	if err != nil {
+		return 0, err
+	}
+	n := int(int32(binary.BigEndian.Uint32(b)))
+	if n < 0 || n > len(d.buf)/size {
+		return 0, fmt.Errorf("nbt: bad length %d", n)
+	}
+	return n, nil
+}
+
+// minSize is the smallest encoding of each type's payload.
+var minSize = [...]int{0, 1, 2, 4, 8, 4, 8, 4, 2, 5, 1, 4, 4}
+
+func (d *decoder) value(ty Tag) (Value, error) {
+	switch ty {
+	case TagByte:
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		return int8(b[0]), nil
+	case TagShort:
+		b, err := d.take(2)
+		if err != nil {
+			return nil, err
+		}
+		return int16(binary.BigEndian.Uint16(b)), nil
+	case TagInt:
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return int32(binary.BigEndian.Uint32(b)), nil
+	case TagLong:
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case TagFloat:
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), nil
+	case TagDouble:
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case TagByteArray:
+		n, err := d.count(1)
+		if err != nil {
+			return nil, err
+		}
+		b, _ := d.take(n)
+		arr := make([]int8, n)
+		for i, v := range b {
+			arr[i] = int8(v)
+		}
+		return arr, nil
+	case TagString:
+		return d.string()
+	case TagIntArray:
+		n, err := d.count(4)
+		if err != nil {
+			return nil, err
+		}
+		b, _ := d.take(n * 4)
+		arr := make([]int32, n)
+		for i := range arr {
+			arr[i] = int32(binary.BigEndian.Uint32(b[i*4:]))
+		}
+		return arr, nil
+	case TagLongArray:
+		n, err := d.count(8)
+		if err != nil {
+			return nil, err
+		}
+		b, _ := d.take(n * 8)
+		arr := make([]int64, n)
+		for i := range arr {
+			arr[i] = int64(binary.BigEndian.Uint64(b[i*8:]))
+		}
+		return arr, nil
+	case TagList, TagCompound:
+		if d.depth++; d.depth > maxDepth {
+			return nil, fmt.Errorf("nbt: nested more than %d deep", maxDepth)
+		}
+		defer func() { d.depth-- }()
+		if ty == TagCompound {
+			return d.compound()
+		}
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		ety := Tag(b[0])
+		if ety > TagLongArray {
+			return nil, fmt.Errorf("nbt: unknown tag type %d", ety)
+		}
+		n, err := d.count(max(minSize[ety], 1))
+		if err != nil {
+			return nil, err
+		}
+		if ety == TagEnd && n > 0 {
+			return nil, fmt.Errorf("nbt: list of %d %v", n, ety)
+		}
+		elems := make([]Value, n)
+		for i := range elems {
+			if elems[i], err = d.value(ety); err != nil {
+				return nil, err
+			}
+		}
+		return newList(ety, elems)
+	}
+	return nil, fmt.Errorf("nbt: unknown tag type %d", ty)
+}
+
+func (d *decoder) compound() (Compound, error) {
+	c := Compound{}
+	for {
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		ty := Tag(b[0])
+		if ty == TagEnd {
+			return c, nil
+		}
+		name, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value(ty)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		c = append(c, Field{name, v})
+	}
+}
+
+// Decode decodes uncompressed binary NBT, whose root tag must be a compound.
+// It returns the root's name, which is usually empty.
+func Decode(buf []byte) (string, Compound, error) {
+	d := &decoder{buf: buf}
+	b, err := d.take(1)
+	if err != nil {
+		return "", nil, err
+	}
+	if Tag(b[0]) != TagCompound {
+		return "", nil, fmt.Errorf("nbt: root is %v, not a compound", Tag(b[0]))
+	}
+	name, err := d.string()
+	if err != nil {
+		return "", nil, err
+	}
+	d.depth = 1
+	root, err := d.compound()
+	return name, root, err
+}
+
+// Encode encodes a compound as binary NBT, with the given root name.
+func Encode(name string, root Compound) ([]byte, error) {
+	buf := appendString([]byte{byte(TagCompound)}, name)
+	return appendValue(buf, root)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+func appendValue(buf []byte, v Value) ([]byte, error) {
+	switch v := v.(type) {
+	case int8:
+		return append(buf, byte(v)), nil
+	case int16:
+		return binary.BigEndian.AppendUint16(buf, uint16(v)), nil
+	case int32:
+		return binary.BigEndian.AppendUint32(buf, uint32(v)), nil
+	case int64:
+		return binary.BigEndian.AppendUint64(buf, uint64(v)), nil
+	case float32:
+		return binary.BigEndian.AppendUint32(buf, math.Float32bits(v)), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(v)), nil
+	case []int8:
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+		for _, b := range v {
+			buf = append(buf, byte(b))
+		}
+		return buf, nil
+	case string:
+		if len(v) > math.MaxUint16 {
+			return nil, fmt.Errorf("nbt: string of %d bytes is too long", len(v))
+		}
+		return appendString(buf, v), nil
+	case []int32:
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+		for _, i := range v {
+			buf = binary.BigEndian.AppendUint32(buf, uint32(i))
+		}
+		return buf, nil
+	case []int64:
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+		for _, i := range v {
+			buf = binary.BigEndian.AppendUint64(buf, uint64(i))
+		}
+		return buf, nil
+	case Compound:
+		var err error
+		for _, f := range v {
+			ty := TagOf(f.Value)
+			if ty == TagEnd {
+				return nil, fmt.Errorf("nbt: %s: can't encode %T", f.Name, f.Value)
+			}
+			buf = appendString(append(buf, byte(ty)), f.Name)
+			if buf, err = appendValue(buf, f.Value); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name, err)
+			}
+		}
+		return append(buf, byte(TagEnd)), nil
+	case AnyList:
+		if v == nil {
+			break
+		}
+		ety := v.ElemType()
+		if ety == TagEnd && v.Len() > 0 {
+			return nil, fmt.Errorf("nbt: list of %d %v", v.Len(), ety)
+		}
+		buf = append(buf, byte(ety))
+		buf = binary.BigEndian.AppendUint32(buf, uint32(v.Len()))
+		var err error
+		for i := range v.Len() {
+			if buf, err = appendValue(buf, v.Index(i)); err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("nbt: can't encode %T", v)
+}
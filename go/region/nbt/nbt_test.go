@@ -0,0 +1,126 @@
+package nbt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	root := Compound{
+		{"DataVersion", int32(3700)},
+		{"Status", "minecraft:full"},
+		{"Y", int8(-4)},
+		{"Short", int16(-300)},
+		{"LastUpdate", int64(1) << 40},
+		{"Pos", List[float64]{1.5, -64, 0.25}},
+		{"Rotation", List[float32]{90, 0}},
+		{"Biomes", []int32{1, 2, 3}},
+		{"Heights", []int64{-1, 1 << 50}},
+		{"Light", []int8{-1, 0, 127}},
+		{"sections", List[Compound]{
+			{{"Y", int8(0)}, {"palette", List[Compound]{{{"Name", "minecraft:stone"}}}}},
+			{{"Y", int8(1)}},
+		}},
+		{"PostProcessing", List[AnyList]{List[int16]{1, 2}, List[any]{}, List[string]{"a"}}},
+		{"Empty", List[any]{}},
+		{"Nested", Compound{{"deep", Compound{}}}},
+	}
+	buf, err := Encode("root", root)
+	require.NoError(t, err)
+	name, decoded, err := Decode(buf)
+	require.NoError(t, err)
+	require.Equal(t, "root", name)
+	require.Equal(t, root, decoded)
+
+	// encoding it again gives the same bytes
+	again, err := Encode(name, decoded)
+	require.NoError(t, err)
+	require.Equal(t, buf, again)
+
+	// every truncation is an error, not a panic
+	for i := range buf {
+		_, _, err := Decode(buf[:i])
+		require.Error(t, err, i)
+	}
+
+	require.Equal(t, int32(3700), decoded.Get("DataVersion"))
+	require.Nil(t, decoded.Get("nope"))
+	decoded.Set("DataVersion", int32(3800))
+	decoded.Set("New", "tag")
+	require.Equal(t, int32(3800), decoded.Get("DataVersion"))
+	require.Equal(t, "tag", decoded[len(decoded)-1].Value)
+
+	require.Equal(t, TagDouble, List[float64]{}.ElemType())
+	require.Equal(t, TagList, List[AnyList]{}.ElemType())
+	require.Equal(t, TagEnd, List[any]{}.ElemType())
+
+	_, err = Encode("", Compound{{"bad", 5}})
+	require.Error(t, err)
+	_, err = Encode("", Compound{{"bad", List[any]{1}}})
+	require.Error(t, err)
+
+	js, err := json.Marshal(Compound{{"b", int8(1)}, {"a", List[string]{"x"}}, {"c", []int8{-1}}})
+	require.NoError(t, err)
+	require.Equal(t, `{"b":1,"a":["x"],"c":[-1]}`, string(js))
+
+	// a list claiming more elements than there's room for
+	_, _, err = Decode([]byte{10, 0, 0, 9, 0, 1, 'l', 10, 0x7f, 0xff, 0xff, 0xff, 0})
+	require.Error(t, err)
+	// a root that isn't a compound
+	_, _, err = Decode([]byte{8, 0, 0, 0, 0})
+	require.Error(t, err)
+}
+
+func TestSNBT(t *testing.T) {
+	v := Compound{
+		{"Name", "minecraft:chest"},
+		{"Count", int8(1)},
+		{"Damage", int16(2)},
+		{"Slot", int32(-3)},
+		{"Seed", int64(42)},
+		{"Health", float32(19.5)},
+		{"Motion", List[float64]{0, -0.5}},
+		{"custom name", `say "hi"` + "\n"},
+		{"Ids", []int32{1, -2}},
+		{"Items", List[Compound]{{{"id", "minecraft:apple"}}, {}}},
+		{"Bytes", []int8{1}},
+		{"Longs", []int64{}},
+		{"Empty", List[any]{}},
+	}
+	s := FormatSNBT(v, "")
+	require.Equal(t, `{Name: "minecraft:chest", Count: 1b, Damage: 2s, Slot: -3, Seed: 42L, Health: 19.5f, `+
+		`Motion: [0d, -0.5d], "custom name": "say \"hi\"\n", Ids: [I; 1, -2], `+
+		`Items: [{id: "minecraft:apple"}, {}], Bytes: [B; 1b], Longs: [L;], Empty: []}`, s)
+	parsed, err := ParseSNBT(s)
+	require.NoError(t, err)
+	require.Equal(t, v, parsed)
+
+	require.Equal(t, `{
+  Items: [
+    {
+      id: "minecraft:apple"
+    },
+    {}
+  ],
+  Motion: [0d, -0.5d]
+}`, FormatSNBT(Compound{v[9], v[6]}, "  "))
+
+	parsed, err = ParseSNBT(`{a: 1, b: 1.5, c: true, d: 'it\'s', e: stone, f: [[1s], []], g: 300b}`)
+	require.NoError(t, err)
+	require.Equal(t, Compound{
+		{"a", int32(1)},
+		{"b", 1.5},
+		{"c", int8(1)},
+		{"d", "it's"},
+		{"e", "stone"},
+		{"f", List[AnyList]{List[int16]{1}, List[any]{}}},
+		{"g", "300b"},
+	}, parsed)
+
+	for _, bad := range []string{"", "{", "{a 1}", "{a: 1", "[1, a]", "[B; 1L]", "[B; 300]", `"open`, "{} x"} {
+		_, err := ParseSNBT(bad)
+		require.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,350 @@
+package nbt
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FormatSNBT writes a value as SNBT, the text form used by the game's commands.
+// With an indent, compounds and lists of them are split over lines, while
+// arrays and lists of numbers and strings stay on one.
+func FormatSNBT(v Value, indent string) string {
+	var sb strings.Builder
+	writeSNBT(&sb, v, indent, "")
+	return sb.String()
+}
+
+var snbtBareString = regexp.MustCompile(`^[0-9A-Za-z_.+-]+$`)
+
+func writeSNBTString(sb *strings.Builder, s string) {
+	sb.WriteByte('"')
+	sb.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s))
+	sb.WriteByte('"')
+}
+
+// the suffixes of numbers, by tag
+var snbtSuffix = map[Tag]string{
+	TagByte: "b", TagShort: "s", TagLong: "L", TagFloat: "f", TagDouble: "d",
+}
+
+func writeSNBT(sb *strings.Builder, v Value, indent, prefix string) {
+	switch v := v.(type) {
+	case int8, int16, int32, int64:
+		i, _ := integer(v)
+		sb.WriteString(strconv.FormatInt(i, 10) + snbtSuffix[TagOf(v)])
+	case float32:
+		sb.WriteString(strconv.FormatFloat(float64(v), 'g', -1, 32) + "f")
+	case float64:
+		sb.WriteString(strconv.FormatFloat(v, 'g', -1, 64) + "d")
+	case string:
+		writeSNBTString(sb, v)
+	case []int8, []int32, []int64:
+		elems, _ := elements(v)
+		sb.WriteString(map[Tag]string{TagByteArray: "[B;", TagIntArray: "[I;", TagLongArray: "[L;"}[TagOf(v)])
+		for i, e := range elems {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteByte(' ')
+			writeSNBT(sb, e, indent, prefix)
+		}
+		sb.WriteByte(']')
+	case Compound:
+		if len(v) == 0 {
+			sb.WriteString("{}")
+			return
+		}
+		sb.WriteByte('{')
+		for i, f := range v {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			if indent != "" {
+				sb.WriteString("\n" + prefix + indent)
+			} else if i > 0 {
+				sb.WriteByte(' ')
+			}
+			if snbtBareString.MatchString(f.Name) {
+				sb.WriteString(f.Name)
+			} else {
+				writeSNBTString(sb, f.Name)
+			}
+			sb.WriteString(": ")
+			writeSNBT(sb, f.Value, indent, prefix+indent)
+		}
+		if indent != "" {
+			sb.WriteString("\n" + prefix)
+		}
+		sb.WriteByte('}')
+	case AnyList:
+		ety := v.ElemType()
+		split := indent != "" && v.Len() > 0 && (ety == TagCompound || ety == TagList)
+		sb.WriteByte('[')
+		for i := range v.Len() {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			if split {
+				sb.WriteString("\n" + prefix + indent)
+			} else if i > 0 {
+				sb.WriteByte(' ')
+			}
+			writeSNBT(sb, v.Index(i), indent, prefix+indent)
+		}
+		if split {
+			sb.WriteString("\n" + prefix)
+		}
+		sb.WriteByte(']')
+	default:
+		fmt.Fprintf(sb, "<%T>", v)
+	}
+}
+
+// ParseSNBT parses SNBT, like {Name: "minecraft:chest", Count: 1b}. Numbers
+// without a suffix are ints, or doubles if they have a decimal point, and
+// true and false are bytes, as in the game.
+func ParseSNBT(s string) (Value, error) {
+	p := &snbtParser{s: s}
+	v, err := p.value(0)
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	if p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q after the value", p.s[p.pos:])
+	}
+	return v, nil
+}
+
+type snbtParser struct {
+	s   string
+	pos int
+}
+
+func (p *snbtParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("snbt: at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *snbtParser) space() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// peek skips whitespace and returns the next byte, or 0 at the end.
+func (p *snbtParser) peek() byte {
+	p.space()
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *snbtParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+// str reads a quoted or bare string, and reports whether it was quoted.
+func (p *snbtParser) str() (string, bool, error) {
+	q := p.peek()
+	if q != '"' && q != '\'' {
+		start := p.pos
+		for p.pos < len(p.s) && snbtBareString.MatchString(p.s[p.pos:p.pos+1]) {
+			p.pos++
+		}
+		if p.pos == start {
+			return "", false, p.errorf("expected a value")
+		}
+		return p.s[start:p.pos], false, nil
+	}
+	p.pos++
+	var sb strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == q:
+			return sb.String(), true, nil
+		case c == '\\' && p.pos < len(p.s):
+			c = p.s[p.pos]
+			p.pos++
+			if c == 'n' {
+				c = '\n'
+			}
+		}
+		sb.WriteByte(c)
+	}
+	return "", false, p.errorf("unterminated string")
+}
+
+var snbtNumber = regexp.MustCompile(`^([-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?)([bBsSlLfFdD]?)$`)
+
+// number interprets a bare string, which is a string if it isn't a number.
+func number(s string) Value {
+	m := snbtNumber.FindStringSubmatch(s)
+	if m == nil {
+		switch s {
+		case "true":
+			return int8(1)
+		case "false":
+			return int8(0)
+		}
+		return s
+	}
+	isInt := !strings.ContainsAny(m[1], ".eE")
+	var v Value
+	var err error
+	switch strings.ToLower(m[2]) {
+	case "b":
+		var i int64
+		i, err = strconv.ParseInt(m[1], 10, 8)
+		v = int8(i)
+	case "s":
+		var i int64
+		i, err = strconv.ParseInt(m[1], 10, 16)
+		v = int16(i)
+	case "l":
+		v, err = strconv.ParseInt(m[1], 10, 64)
+	case "f":
+		var f float64
+		f, err = strconv.ParseFloat(m[1], 32)
+		v = float32(f)
+	case "d":
+		v, err = strconv.ParseFloat(m[1], 64)
+	default:
+		if isInt {
+			var i int64
+			i, err = strconv.ParseInt(m[1], 10, 32)
+			v = int32(i)
+		} else {
+			v, err = strconv.ParseFloat(m[1], 64)
+		}
+	}
+	if err != nil || (!isInt && strings.ContainsAny(m[2], "bBsSlL")) {
+		return s
+	}
+	return v
+}
+
+func (p *snbtParser) value(depth int) (Value, error) {
+	if depth > maxDepth {
+		return nil, p.errorf("nested more than %d deep", maxDepth)
+	}
+	switch p.peek() {
+	case '{':
+		p.pos++
+		c := Compound{}
+		if p.peek() == '}' {
+			p.pos++
+			return c, nil
+		}
+		for {
+			name, _, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+			v, err := p.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, Field{name, v})
+			if p.peek() == ',' {
+				p.pos++
+				continue
+			}
+			return c, p.expect('}')
+		}
+	case '[':
+		p.pos++
+		if rest := p.s[p.pos:]; len(rest) >= 2 && rest[1] == ';' && strings.IndexByte("BIL", rest[0]) >= 0 {
+			return p.array(rest[0])
+		}
+		var elems []Value
+		if p.peek() == ']' {
+			p.pos++
+			return List[any]{}, nil
+		}
+		for {
+			v, err := p.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if len(elems) > 0 && TagOf(v) != TagOf(elems[0]) {
+				return nil, p.errorf("can't mix %v and %v in a list", TagOf(elems[0]), TagOf(v))
+			}
+			elems = append(elems, v)
+			if p.peek() == ',' {
+				p.pos++
+				continue
+			}
+			if err := p.expect(']'); err != nil {
+				return nil, err
+			}
+			return newList(TagOf(elems[0]), elems)
+		}
+	}
+	s, quoted, err := p.str()
+	if err != nil || quoted {
+		return s, err
+	}
+	return number(s), nil
+}
+
+// array parses the rest of a [B; ...], [I; ...] or [L; ...] array.
+func (p *snbtParser) array(kind byte) (Value, error) {
+	p.pos += 2
+	want := map[byte]Tag{'B': TagByte, 'I': TagInt, 'L': TagLong}[kind]
+	elems := []int64{}
+	if p.peek() == ']' {
+		p.pos++
+	} else {
+		for {
+			s, _, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			v := number(s)
+			i, ok := integer(v)
+			if !ok || (TagOf(v) != want && TagOf(v) != TagInt) {
+				return nil, p.errorf("%q isn't a %v", s, want)
+			}
+			elems = append(elems, i)
+			if p.peek() == ',' {
+				p.pos++
+				continue
+			}
+			if err := p.expect(']'); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	switch kind {
+	case 'B':
+		arr := make([]int8, len(elems))
+		for i, e := range elems {
+			if e < math.MinInt8 || e > math.MaxInt8 {
+				return nil, p.errorf("%d doesn't fit in a byte", e)
+			}
+			arr[i] = int8(e)
+		}
+		return arr, nil
+	case 'I':
+		arr := make([]int32, len(elems))
+		for i, e := range elems {
+			arr[i] = int32(e)
+		}
+		return arr, nil
+	}
+	return elems, nil
+}
@@ -1,21 +1,16 @@
 package region
 
 import (
-	"bytes"
-	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"os"
 	"regexp"
 	"sort"
 	"strconv"
 
-	"github.com/klauspost/compress/zlib"
-
 	"github.com/rmmh/cubeographer/go/render"
 )
 
@@ -40,43 +35,299 @@ type paletteEntry struct {
 }
 
 type ChunkDatum struct {
-	Blocks            [][]uint16
-	BlockState        [][]render.Stateval
+	// MinY is the Y coordinate of the bottom of Blocks[0]. Sections are
+	// contiguous, so Blocks[i] covers MinY+16*i through MinY+16*i+15.
+	MinY       int
+	Blocks     [][]uint16
+	BlockState [][]render.Stateval
+	// Lights and LightsSky are aligned with Blocks, with nil entries
+	// for sections that had no stored light.
 	Lights, LightsSky [][]byte
+	// Biomes is aligned with Blocks, with a render.Biomes id for each 4x4x4 cell
+	// of a section, indexed by x + z*4 + y*16, or an id past them for biomes
+	// that aren't known (see BlockMapper.BiomeName). Entries are nil if unknown.
+	Biomes [][]uint8
+	// BlockEntities holds the signs, containers, banners and spawners of the chunk.
+	BlockEntities []BlockEntity
+}
+
+// rawSection is a chunk section as stored in the NBT, before
+// its blocks are mapped to nids.
+type rawSection struct {
+	y    int8
+	hasY bool
+
+	palette     []paletteEntry
+	blockStates []byte // packed palette indexes (1.13+)
+	blocks      []byte // legacy block ids (pre-1.13)
+	blockData   []byte // legacy block metadata nibbles (pre-1.13)
+
+	light, skyLight []byte
+
+	biomePalette []string
+	biomeData    []byte // packed biomePalette indexes (1.18+)
+}
+
+func (s *rawSection) hasBlocks() bool {
+	return len(s.blocks) > 0 || len(s.blockStates) > 0 || len(s.palette) > 0
+}
+
+func (s *rawSection) isAir() bool {
+	return len(s.blocks) == 0 && len(s.blockStates) == 0 &&
+		(len(s.palette) == 0 || len(s.palette) == 1 && s.palette[0].name == "minecraft:air")
+}
+
+type rawChunk struct {
+	dataVersion int
+	xPos, zPos  int
+	status      string
+	sections    []rawSection
+
+	// Level.Biomes before 1.18: numeric ids, either a 16x16 column map
+	// (as bytes or ints) or, from 1.15, ints for each 4x4x4 cell from y=0 up
+	legacyBiomes    []byte
+	legacyBiomesInt bool
+
+	blockEntities []rawBlockEntity
+}
+
+func parseChunk(buf []byte) (*rawChunk, error) {
+	rc := &rawChunk{
+		xPos: math.MaxInt64,
+		zPos: math.MaxInt64,
+	}
+
+	section := func(i int) *rawSection {
+		for i >= len(rc.sections) {
+			rc.sections = append(rc.sections, rawSection{})
+		}
+		return &rc.sections[i]
+	}
+	blockEntity := func(i int) *rawBlockEntity {
+		for i >= len(rc.blockEntities) {
+			rc.blockEntities = append(rc.blockEntities, rawBlockEntity{})
+		}
+		return &rc.blockEntities[i]
+	}
+
+	err := NbtWalk(buf, func(path []string, idxes []int, ty NbtType, value []byte) {
+		if len(path) == 0 {
+			return
+		}
+		if len(path) <= 2 && ty == TagInt {
+			switch path[len(path)-1] {
+			case "xPos":
+				rc.xPos = int(int32(binary.BigEndian.Uint32(value)))
+			case "zPos":
+				rc.zPos = int(int32(binary.BigEndian.Uint32(value)))
+			}
+		}
+		last := path[len(path)-1]
+		if len(path) == 2 && path[0] == "Level" && last == "Biomes" && (ty == TagIntArray || ty == TagByteArray) {
+			rc.legacyBiomes = value
+			rc.legacyBiomesInt = ty == TagIntArray
+			return
+		}
+		if len(idxes) > 0 && ty != TagCompound {
+			// block_entities.N.* since 1.18, Level.TileEntities.N.* before
+			var bePath []string
+			if path[0] == "block_entities" && len(path) > 2 {
+				bePath = path[2:]
+			} else if path[0] == "Level" && len(path) > 3 && path[1] == "TileEntities" {
+				bePath = path[3:]
+			}
+			if bePath != nil {
+				item := 0
+				if len(idxes) > 1 {
+					item = idxes[1]
+				}
+				blockEntity(idxes[0]).field(bePath, item, ty, value)
+				return
+			}
+		}
+		if last == "DataVersion" && ty == TagInt {
+			rc.dataVersion = int(binary.BigEndian.Uint32(value))
+		} else if last == "Status" {
+			rc.status = string(value)
+		} else if path[0] == "sections" || len(path) > 1 && path[1] == "Sections" {
+			if len(idxes) == 0 {
+				return
+			}
+			sec := section(idxes[0])
+			penult := path[len(path)-2]
+			if len(idxes) == 2 && len(path) > 4 && (path[3] == "Palette" || path[3] == "palette") {
+				for idxes[1] >= len(sec.palette) {
+					sec.palette = append(sec.palette, paletteEntry{})
+				}
+				entry := &sec.palette[idxes[1]]
+				if last == "Name" {
+					entry.name = string(value)
+				} else if len(path) == 7 && path[5] == "Properties" {
+					entry.props = append(entry.props, last+"="+string(value))
+				}
+			} else if ty == TagByteArray {
+				if last == "Blocks" {
+					sec.blocks = value
+				} else if last == "Data" {
+					sec.blockData = value
+				} else if last == "BlockLight" {
+					// lights and lightsky are the only values that escape this
+					// function-- don't reference the reused chunk data buffer!
+					sec.light = make([]byte, len(value))
+					copy(sec.light, value)
+				} else if last == "SkyLight" {
+					sec.skyLight = make([]byte, len(value))
+					copy(sec.skyLight, value)
+				}
+			} else if ty == TagLongArray {
+				if last == "BlockStates" || last == "data" && penult == "block_states" {
+					sec.blockStates = value
+				} else if last == "data" && penult == "biomes" {
+					sec.biomeData = value
+				}
+			} else if ty == -TagString && last == "palette" && penult == "biomes" {
+				for o := 0; o+2 <= len(value); {
+					n := int(binary.BigEndian.Uint16(value[o:]))
+					sec.biomePalette = append(sec.biomePalette, string(value[o+2:o+2+n]))
+					o += 2 + n
+				}
+			} else if ty == TagByte && last == "Y" {
+				sec.y = int8(value[0])
+				sec.hasY = true
+			}
+		}
+	})
+	if err != nil {
+		return rc, err
+	}
+
+	// keep only the sections that actually hold blocks, in ascending Y order.
+	// Light-only sections (e.g. the ones just above and below the world) are dropped.
+	sections := rc.sections[:0]
+	for _, sec := range rc.sections {
+		if sec.hasY && sec.hasBlocks() {
+			sections = append(sections, sec)
+		}
+	}
+	sort.SliceStable(sections, func(i, j int) bool { return sections[i].y < sections[j].y })
+	// omit the all-air sections on top
+	for len(sections) > 0 && sections[len(sections)-1].isAir() {
+		sections = sections[:len(sections)-1]
+	}
+	rc.sections = sections
+
+	return rc, nil
 }
 
 func ReadRegion(path string, bm *BlockMapper, wanted []int) ([]ChunkDatum, error) {
+	cdata := make([]ChunkDatum, 1024)
+	conv := newChunkConverter(bm)
+
+	err := readChunks(path, wanted, func(chunkNum, xPos, zPos int, chunkData []byte) *ChunkError {
+		rc, err := parseChunk(chunkData)
+		if err != nil {
+			return &ChunkError{Reason: "unable to parse NBT", Err: err}
+		}
+		if (rc.xPos != math.MaxInt64 && rc.xPos != xPos) || (rc.zPos != math.MaxInt64 && rc.zPos != zPos) {
+			return &ChunkError{Reason: fmt.Sprintf("chunk misplaced (corrupt region file?)-- got %d,%d", rc.xPos, rc.zPos)}
+		}
+		if rc.status != "" && rc.status != "minecraft:full" {
+			return nil // skip proto-chunks
+		}
+		cd, err := conv.convert(rc)
+		if err != nil {
+			return &ChunkError{Reason: "unable to convert", Err: err}
+		}
+		cdata[chunkNum] = cd
+		return nil
+	})
+	return cdata, err
+}
+
+// ReadChunkNBT reads the decompressed NBT of one chunk of a region file. The chunk
+// can be given by its position within the region, 0-31, or in the world.
+func ReadChunkNBT(path string, cx, cz int) ([]byte, error) {
 	rx, rz, err := ParseRegionPath(path)
 	if err != nil {
 		return nil, err
 	}
+	if cx>>5 == rx && cz>>5 == rz {
+		cx, cz = cx&31, cz&31
+	} else if cx < 0 || cx > 31 || cz < 0 || cz > 31 {
+		return nil, fmt.Errorf("chunk %d,%d isn't in region %d,%d", cx, cz, rx, rz)
+	}
+	var nbt []byte
+	err = readChunks(path, []int{cx + cz*32}, func(chunkNum, xPos, zPos int, data []byte) *ChunkError {
+		nbt = append([]byte(nil), data...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if nbt == nil {
+		return nil, fmt.Errorf("chunk %d,%d of region %d,%d hasn't been generated", cx, cz, rx, rz)
+	}
+	return nbt, nil
+}
 
-	cdata := make([]ChunkDatum, 1024)
+// RegionHeader is the table at the start of a region file.
+type RegionHeader struct {
+	// Offsets gives the location of each chunk, x + z*32, as a 24-bit sector
+	// offset and an 8-bit sector count. Missing chunks are zero.
+	Offsets [1024]uint32
+	// Timestamps gives when each chunk was last saved, in Unix seconds.
+	Timestamps [1024]uint32
+}
 
+// ReadRegionHeader reads just the chunk offsets and timestamps of a region file,
+// which is enough to tell which chunks exist and which have changed.
+func ReadRegionHeader(path string) (*RegionHeader, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return cdata, err
+		return nil, err
 	}
 	defer f.Close()
+	return readHeader(f)
+}
 
-	var buf [4096]uint8
-	_, err = f.Read(buf[:])
-	if err != nil {
-		return cdata, err
+func readHeader(f io.Reader) (*RegionHeader, error) {
+	var buf [8192]uint8
+	if _, err := io.ReadFull(f, buf[:]); err != nil {
+		return nil, fmt.Errorf("unable to read region header: %w", err)
 	}
-	offsets := make([]uint32, 1024)
+	hdr := &RegionHeader{}
 	for i := 0; i < 1024; i++ {
-		offsets[i] = binary.BigEndian.Uint32(buf[i*4:])
+		hdr.Offsets[i] = binary.BigEndian.Uint32(buf[i*4:])
+		hdr.Timestamps[i] = binary.BigEndian.Uint32(buf[4096+i*4:])
 	}
+	return hdr, nil
+}
+
+// chunkHandler is called with the decompressed NBT of each chunk in a region.
+// The data is only valid until it returns. A returned ChunkError only needs
+// its Reason and Err filled in.
+type chunkHandler func(chunkNum, xPos, zPos int, data []byte) *ChunkError
 
-	_, err = f.Read(buf[:])
+// readChunks reads the chunks of a region file in file order, or just the
+// wanted ones if given, and passes each to handle. Chunks that can't be read
+// or are rejected by handle are collected into a ChunkErrors.
+func readChunks(path string, wanted []int, handle chunkHandler) error {
+	rx, rz, err := ParseRegionPath(path)
 	if err != nil {
-		return cdata, err
+		return err
 	}
-	timestamps := make([]uint32, 1024)
-	for i := 0; i < 1024; i++ {
-		timestamps[i] = binary.BigEndian.Uint32(buf[i*4:])
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hdr, err := readHeader(f)
+	if err != nil {
+		return err
 	}
+	offsets := hdr.Offsets[:]
 
 	maxSectors := 0 // size of largest chunk in region
 	for _, offset := range offsets {
@@ -107,245 +358,321 @@ func ReadRegion(path string, bm *BlockMapper, wanted []int) ([]ChunkDatum, error
 
 	// allocating these once per region saves memory
 	chunkBuf := make([]byte, 4096*maxSectors)
-	chunkDecompressed := bytes.NewBuffer(make([]byte, 0, 4*(1<<20)))
-	palNids := make([]uint16, 0, 64)
-	palStates := make([]render.Stateval, 0, 64)
-	var zr io.ReadCloser
-	var zrr zlib.Resetter
+	dec := newChunkDecompressor()
 
+	var errs ChunkErrors
 	for _, chunkNum := range seqChunks {
-		f.Seek(int64(offsets[chunkNum]>>8)*4096, os.SEEK_SET)
-		paddedLen := 4096 * int(offsets[chunkNum]&0xff)
-		_, err = f.Read(chunkBuf[:paddedLen])
-		if err != nil {
-			return cdata, err
+		xPos, zPos := int(chunkNum&31)|rx<<5, int(chunkNum>>5)|rz<<5
+		fail := func(ce *ChunkError) {
+			ce.Index, ce.X, ce.Z = int(chunkNum), xPos, zPos
+			errs = append(errs, ce)
 		}
-		chunkLen := int(binary.BigEndian.Uint32(chunkBuf))
-		if chunkLen > paddedLen {
-			// TODO: header indicates chunk is too long
-			log.Println("chunkLen too long??")
+
+		f.Seek(int64(offsets[chunkNum]>>8)*4096, io.SeekStart)
+		paddedLen := 4096 * int(offsets[chunkNum]&0xff)
+		n, err := io.ReadFull(f, chunkBuf[:paddedLen])
+		if err != nil && (err != io.ErrUnexpectedEOF || n < 5) {
+			fail(&ChunkError{Reason: "unable to read chunk", Err: err})
 			continue
 		}
-		if chunkBuf[4] != 2 {
-			// TODO: ERROR unhandled compression
-			log.Println("unhandled compression type", chunkBuf[4])
+		chunkLen := int(binary.BigEndian.Uint32(chunkBuf))
+		if chunkLen < 1 || chunkLen+4 > n {
+			fail(&ChunkError{Reason: fmt.Sprintf("bad chunk length %d (%d bytes available)", chunkLen, n)})
 			continue
 		}
 
-		chunkReader := bytes.NewReader(chunkBuf[5 : chunkLen+4])
-		if zr == nil {
-			zr, err = zlib.NewReader(chunkReader)
-			var ok bool
-			zrr, ok = zr.(zlib.Resetter)
-			if !ok {
-				panic("zlib.NewReader MUST be resettable")
+		scheme := chunkBuf[4]
+		compressed := chunkBuf[5 : chunkLen+4]
+		if scheme&compressionExternal != 0 {
+			scheme &^= compressionExternal
+			compressed, err = os.ReadFile(externalChunkPath(path, xPos, zPos))
+			if err != nil {
+				fail(&ChunkError{Reason: "unable to read external chunk", Err: err})
+				continue
 			}
-		} else {
-			err = zrr.Reset(chunkReader, nil)
 		}
-		if err != nil {
-			return cdata, err
+		if scheme == compressionCustom {
+			fail(&ChunkError{Reason: "unhandled custom compression type"})
+			continue
 		}
-		chunkDecompressed.Reset()
-		_, err = chunkDecompressed.ReadFrom(zr)
+		chunkData, err := dec.decompress(scheme, compressed)
 		if err != nil {
-			return cdata, err
-		}
-		chunkDecompressedBytes := chunkDecompressed.Bytes()
-
-		dataVersion := 0
-		blocks := [][]byte{}
-		blockData := [][]byte{}
-		blockStates := make([][]byte, 4096/16)
-		palettes := make([][]paletteEntry, 4096/16)
-		lights := [][]byte{}
-		lightsSky := [][]byte{}
-		ys := []int8{}
-		xPos, zPos := int(chunkNum&31)|rx<<5, int(chunkNum>>5)|rz<<5
-		chunkZPos := math.MaxInt64
-		chunkXPos := math.MaxInt64
-		chunkStatus := ""
+			fail(&ChunkError{Reason: "unable to decompress", Err: err})
+			continue
+		}
 
-		err = NbtWalk(chunkDecompressedBytes, func(path []string, idxes []int, ty NbtType, value []byte) {
-			if len(path) == 0 {
-				return
-			}
-			if len(path) <= 2 && ty == TagInt {
-				switch path[len(path)-1] {
-				case "xPos":
-					chunkXPos = int(int32(binary.BigEndian.Uint32(value)))
-				case "zPos":
-					chunkZPos = int(int32(binary.BigEndian.Uint32(value)))
-				}
+		if ce := handle(int(chunkNum), xPos, zPos, chunkData); ce != nil {
+			fail(ce)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// chunkConverter maps the raw sections of a chunk to nids and states,
+// reusing its scratch buffers between chunks.
+type chunkConverter struct {
+	bm        *BlockMapper
+	palNids   []uint16
+	palStates []render.Stateval
+
+	unknownCounts map[string]int
+}
+
+func newChunkConverter(bm *BlockMapper) *chunkConverter {
+	return &chunkConverter{
+		bm:        bm,
+		palNids:   make([]uint16, 0, 64),
+		palStates: make([]render.Stateval, 0, 64),
+
+		unknownCounts: map[string]int{},
+	}
+}
+
+func (c *chunkConverter) convert(rc *rawChunk) (ChunkDatum, error) {
+	bm := c.bm
+	if len(rc.sections) == 0 {
+		return ChunkDatum{}, nil
+	}
+
+	palettes := make([][]paletteEntry, len(rc.sections))
+	for i := range rc.sections {
+		palettes[i] = rc.sections[i].palette
+	}
+	bm.migrate(rc.dataVersion, palettes)
+
+	minSection := int(rc.sections[0].y)
+	numSections := int(rc.sections[len(rc.sections)-1].y) - minSection + 1
+	cd := ChunkDatum{
+		MinY:       minSection * 16,
+		Blocks:     make([][]uint16, numSections),
+		BlockState: make([][]render.Stateval, numSections),
+		Lights:     make([][]byte, numSections),
+		LightsSky:  make([][]byte, numSections),
+		Biomes:     make([][]uint8, numSections),
+	}
+
+	for i := range rc.blockEntities {
+		if interestingBlockEntity(rc.blockEntities[i].ID) {
+			cd.BlockEntities = append(cd.BlockEntities, rc.blockEntities[i].finish())
+		}
+	}
+
+	for si := range rc.sections {
+		sec := &rc.sections[si]
+		bi := int(sec.y) - minSection
+		cd.Lights[bi] = sec.light
+		cd.LightsSky[bi] = sec.skyLight
+		if len(sec.biomePalette) > 0 {
+			cd.Biomes[bi] = unpackBiomes(bm, sec.biomePalette, sec.biomeData)
+		} else if len(rc.legacyBiomes) > 0 {
+			cd.Biomes[bi] = legacyBiomesForSection(rc.legacyBiomes, rc.legacyBiomesInt, int(sec.y))
+		}
+
+		if len(sec.blocks) > 0 {
+			if len(sec.blocks) != 4096 || len(sec.blockData) != 2048 {
+				return ChunkDatum{}, fmt.Errorf("section %d: blocks/blockData length mismatch (%d/%d)", sec.y, len(sec.blocks), len(sec.blockData))
 			}
-			last := path[len(path)-1]
-			if last == "DataVersion" {
-				dataVersion = int(binary.BigEndian.Uint32(value))
-			} else if last == "Status" {
-				chunkStatus = string(value)
-			} else if path[0] == "sections" || len(path) > 1 && path[1] == "Sections" {
-				penult := path[len(path)-2]
-				if len(idxes) == 2 && len(path) > 4 && (path[3] == "Palette" || path[3] == "palette") {
-					cpal := &palettes[idxes[0]]
-					if idxes[1] >= len(*cpal) {
-						*cpal = append(*cpal, paletteEntry{})
-					}
-					entry := &(*cpal)[idxes[1]]
-					if last == "Name" {
-						entry.name = string(value)
-					} else if len(path) == 7 && path[5] == "Properties" {
-						entry.props = append(entry.props, last+"="+string(value))
-					}
-				} else if ty == TagByteArray {
-					if last == "Blocks" {
-						blocks = append(blocks, value)
-					} else if last == "Data" {
-						blockData = append(blockData, value)
-					} else if last == "BlockLight" {
-						// lights and lightsky are the only values that escape this
-						// function-- don't reference the reused chunk data buffer!
-						light := make([]byte, len(value))
-						copy(light, value)
-						lights = append(lights, light)
-					} else if last == "SkyLight" {
-						light := make([]byte, len(value))
-						copy(light, value)
-						lightsSky = append(lightsSky, light)
-					}
-				} else if ty == TagLongArray {
-					if last == "BlockStates" || last == "data" && penult == "block_states" {
-						blockStates[idxes[0]] = value
-					}
-				} else if ty == TagByte && last == "Y" {
-					ys = append(ys, int8(value[0]))
+			nb := make([]uint16, 4096)
+			ns := make([]render.Stateval, 4096)
+			for i, ob := range sec.blocks {
+				o := uint16(ob)<<4 | uint16((sec.blockData[i>>1]>>((i&1)<<2))&0xf)
+				nb[i] = bm.blockstateToNid[o]
+				ns[i] = bm.blockstateToNstate[o]
+				if nb[i] == 0 {
+					nb[i] = bm.blockstateToNid[o&^0xf]
+					ns[i] = bm.blockstateToNstate[o&^0xf]
 				}
 			}
-		})
-		if (chunkXPos != math.MaxInt64 && chunkXPos != xPos) || (chunkZPos != math.MaxInt64 && chunkZPos != zPos) {
-			log.Printf("chunk misplaced (corrupt region file?)-- expected %d,%d got %d,%d\n", xPos, zPos, chunkXPos, chunkZPos)
+			cd.Blocks[bi] = nb
+			cd.BlockState[bi] = ns
 			continue
 		}
-		if chunkStatus != "" && chunkStatus != "minecraft:full" {
-			continue // skip proto-chunks
-		}
-		if len(ys) == 25 && len(palettes[24]) > 1 && false {
-			// for pasting into https://www.brandonfowler.me/nbtreader/
-			fmt.Println(base64.StdEncoding.EncodeToString(chunkDecompressedBytes))
-			panic("stop")
-		}
-		for len(ys) > 1 && ys[0] < 0 {
-			// TODO: actually render negative Y
-			ys = ys[1:]
-			palettes = palettes[1:]
-			blockStates = blockStates[1:]
-		}
-		// omit the all-air sections on top
-		blockStates = blockStates[:len(ys)]
-		for i := len(blockStates) - 1; i >= 0; i-- {
-			if len(blockStates[i]) == 0 && (len(palettes[i]) == 0 || len(palettes[i]) == 1 && palettes[i][0].name == "minecraft:air") {
-				blockStates = blockStates[:i]
-			} else {
-				break
+
+		c.palNids = c.palNids[:0]
+		c.palStates = c.palStates[:0]
+		hasUnknown := false
+		for i := range sec.palette {
+			palName := sec.palette[i].name
+			nid, ok := bm.NameToNid[palName]
+			if !ok {
+				// draw it as a placeholder rather than losing the chunk
+				nid = bm.unknownNid(palName)
+				hasUnknown = true
 			}
+			c.palNids = append(c.palNids, nid)
+			c.palStates = append(c.palStates, bm.nidToSmap[nid].GetList(sec.palette[i].props))
 		}
-		if !sort.SliceIsSorted(ys, func(i, j int) bool { return ys[i] < ys[j] }) {
-			log.Println("sections out of order somehow? Ys:", ys, "Blockstates:", len(blockStates), blockStates)
-			continue
-		}
-		bm.migrate(dataVersion, palettes)
-		nblocks := [][]uint16{}
-		nstates := [][]render.Stateval{}
-		if len(blocks) > 0 {
-			if len(blocks) != len(blockData) {
-				panic("blocks/blockData length mismatch in" + path)
-			}
-			for bi, bs := range blocks {
-				nb := make([]uint16, 4096)
-				ns := make([]render.Stateval, 4096)
-				for i, ob := range bs {
-					o := uint16(ob)<<4 | uint16((blockData[bi][i>>1]>>((i&1)<<2))&0xf)
-					nb[i] = bm.blockstateToNid[o]
-					ns[i] = bm.blockstateToNstate[o]
-					if nb[i] == 0 {
-						nb[i] = bm.blockstateToNid[o&^0xf]
-						ns[i] = bm.blockstateToNstate[o&^0xf]
-					}
-				}
-				nblocks = append(nblocks, nb)
-				nstates = append(nstates, ns)
+
+		vals := make([]uint16, 16*16*16)
+		states := make([]render.Stateval, 16*16*16)
+		if len(sec.blockStates) == 0 {
+			// empty segment, fill as appropriate
+			if hasUnknown {
+				c.countUnknown(sec.palette[:1], nil)
 			}
-		} else if len(blockStates) > 0 {
-			for bi, bs := range blockStates {
-				palNids = palNids[:0]
-				palStates = palStates[:0]
-				for i := range palettes[bi] {
-					palName := palettes[bi][i].name
-					nid, ok := bm.NameToNid[palName]
-					if !ok {
-						return cdata, fmt.Errorf("unable to map palette name %s to an id", palName)
-					}
-					palNids = append(palNids, nid)
-					palStates = append(palStates, bm.nidToSmap[nid].GetList(palettes[bi][i].props))
+			if len(c.palNids) > 0 && c.palNids[0] != 0 {
+				// only have to fill if it's not air (usually is water)
+				for i := range vals {
+					vals[i] = c.palNids[0]
 				}
-				if len(bs) == 0 {
-					// empty segment, fill as appropriate
-					vals := make([]uint16, 16*16*16)
-					states := make([]render.Stateval, 16*16*16)
-					if len(palettes[bi]) > 0 && palettes[bi][0].name != "minecraft:air" {
-						// only have to fill if it's not air (usually is water)
-						p := palettes[bi][0]
-						v := bm.NameToNid[p.name]
-						for i := range 4096 {
-							vals[i] = v
-						}
-						if s := bm.nidToSmap[v].GetList(p.props); s != 0 {
-							for i := range 4096 {
-								states[i] = s
-							}
-						}
+				if s := c.palStates[0]; s != 0 {
+					for i := range states {
+						states[i] = s
 					}
-					nblocks = append(nblocks, vals)
-					nstates = append(nstates, states)
-					continue
-				}
-				if bi >= len(palettes) {
-					fmt.Println("wtf, a blockstate without an associated palette?")
-					break
-				}
-				var vals []uint16
-				states := make([]render.Stateval, 16*16*16)
-				if dataVersion < 2529 {
-					// before 1.16 snapshot 20w17a
-					vals = blockstatesToShortsPacked(bs)
-				} else {
-					vals = blockstatesToShorts116(bs)
 				}
-				for i, v := range vals {
-					vals[i] = palNids[v]
-					states[i] = palStates[v]
+			}
+		} else {
+			if rc.dataVersion < 2529 {
+				// before 1.16 snapshot 20w17a
+				vals = blockstatesToShortsPacked(sec.blockStates)
+			} else {
+				vals = blockstatesToShorts116(sec.blockStates)
+			}
+			if hasUnknown {
+				c.countUnknown(sec.palette, vals)
+			}
+			for i, v := range vals {
+				if int(v) >= len(c.palNids) {
+					return ChunkDatum{}, fmt.Errorf("section %d: palette index %d out of range (%d entries)", sec.y, v, len(c.palNids))
 				}
-				nblocks = append(nblocks, vals)
-				nstates = append(nstates, states)
+				vals[i] = c.palNids[v]
+				states[i] = c.palStates[v]
 			}
 		}
-		cdata[chunkNum] = ChunkDatum{nblocks, nstates, lights, lightsSky}
-		if err != nil {
-			return cdata, err
+		cd.Blocks[bi] = vals
+		cd.BlockState[bi] = states
+	}
+
+	// fill any gaps between sections with air
+	for bi := range cd.Blocks {
+		if cd.Blocks[bi] == nil {
+			cd.Blocks[bi] = make([]uint16, 4096)
+			cd.BlockState[bi] = make([]render.Stateval, 4096)
+		}
+	}
+
+	if len(c.unknownCounts) > 0 {
+		bm.countUnknown(c.unknownCounts)
+		clear(c.unknownCounts)
+	}
+
+	return cd, nil
+}
+
+// countUnknown tallies how many blocks of a section use palette entries
+// that aren't in blockmeta. A nil idxes means the whole section is palette[0].
+func (c *chunkConverter) countUnknown(palette []paletteEntry, idxes []uint16) {
+	if idxes == nil {
+		if _, ok := c.bm.NameToNid[palette[0].name]; !ok {
+			c.unknownCounts[palette[0].name] += 4096
+		}
+		return
+	}
+	counts := make([]int, len(palette))
+	for _, v := range idxes {
+		if int(v) < len(counts) {
+			counts[v]++
+		}
+	}
+	for i, count := range counts {
+		if _, ok := c.bm.NameToNid[palette[i].name]; !ok && count > 0 {
+			c.unknownCounts[palette[i].name] += count
+		}
+	}
+}
+
+// unpackBiomes converts a 1.18+ section's biome palette and packed indexes
+// to biome ids. Like block states since 1.16, entries don't span longs.
+func unpackBiomes(bm *BlockMapper, palette []string, data []byte) []uint8 {
+	ids := make([]uint8, len(palette))
+	for i, name := range palette {
+		ids[i] = bm.biomeID(name)
+	}
+	ret := make([]uint8, 64)
+	if len(palette) == 1 || len(data) == 0 {
+		for i := range ret {
+			ret[i] = ids[0]
+		}
+		return ret
+	}
+	bits := 1
+	for 1<<bits < len(palette) {
+		bits++
+	}
+	perLong := 64 / bits
+	mask := uint64(1)<<bits - 1
+	for i := range ret {
+		o := (i / perLong) * 8
+		if o+8 > len(data) {
+			break
+		}
+		idx := int(binary.BigEndian.Uint64(data[o:]) >> ((i % perLong) * bits) & mask)
+		if idx < len(ids) {
+			ret[i] = ids[idx]
 		}
 	}
+	return ret
+}
 
-	return cdata, nil
+// legacyBiomesForSection extracts a section's 4x4x4 biome cells from the
+// chunk-wide Level.Biomes array used before 1.18.
+func legacyBiomesForSection(biomes []byte, isInt bool, sectionY int) []uint8 {
+	get := func(i int) int {
+		if isInt {
+			return int(int32(binary.BigEndian.Uint32(biomes[i*4:])))
+		}
+		return int(biomes[i])
+	}
+	n := len(biomes)
+	if isInt {
+		n /= 4
+	}
+	ret := make([]uint8, 64)
+	if n == 256 {
+		// 2D biomes, one per column: sample the middle of each 4x4 cell
+		for z := range 4 {
+			for x := range 4 {
+				id := render.BiomeByLegacyID(get(x*4 + 2 + (z*4+2)*16))
+				for y := range 4 {
+					ret[x+z*4+y*16] = id
+				}
+			}
+		}
+		return ret
+	}
+	// 3D biomes, starting from y=0
+	for y := range 4 {
+		cy := sectionY*4 + y
+		if cy < 0 {
+			cy = 0
+		}
+		if (cy+1)*16 > n {
+			cy = n/16 - 1
+		}
+		if cy < 0 {
+			break
+		}
+		for i := range 16 {
+			ret[i+y*16] = render.BiomeByLegacyID(get(cy*16 + i))
+		}
+	}
+	return ret
 }
 
 // 1.16 64-bit BlockState long array to uint16 array
 func blockstatesToShorts116(value []byte) []uint16 {
+	value = value[:len(value)&^7]
 	bpb := (64 * (len(value) / 8)) / 4096
 	if bpb < 4 {
 		bpb = 4
 	}
 
 	ret := make([]uint16, 4096)
-	if bpb == 4 {
+	if bpb == 4 && len(value) >= 2048 {
 		// fast case: a nibble
 		for i := 0; i < 4096; i += 2 {
 			b := uint16(value[(i/2)&^7+7-(i/2)&7])
@@ -377,7 +704,7 @@ func blockstatesToShorts116(value []byte) []uint16 {
 // pre-1.16, blockstates are packed to use every bit possible
 func blockstatesToShortsPacked(value []byte) []uint16 {
 	bpb := (64 * (len(value) / 8)) / 4096
-	if 64%bpb == 0 {
+	if bpb == 0 || 64%bpb == 0 {
 		// simple case: the state bits fit into longs with no slop
 		return blockstatesToShorts116(value)
 	}
@@ -387,8 +714,13 @@ func blockstatesToShortsPacked(value []byte) []uint16 {
 	var bitbuf uint32
 	bits := 0
 	vptr := 0
+	end := len(value) &^ 7
 	for i := 0; i < 4096; i++ {
 		for bits < bpb {
+			if vptr >= end {
+				// too short, leave the rest as palette entry 0
+				return ret
+			}
 			// n.b.: value is a representation of *big endian* longs
 			// this bit twiddling reads it in the right order
 			bitbuf |= (uint32(value[vptr&^7+(7-vptr&7)]) << bits)
@@ -0,0 +1,190 @@
+package region
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/rmmh/cubeographer/go/render"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnknownBlocks(t *testing.T) {
+	bm := testBlockMapper(t)
+	stone := bm.NameToNid["minecraft:stone"]
+
+	rc := &rawChunk{
+		dataVersion: 3700,
+		sections: []rawSection{
+			{y: -1, palette: []paletteEntry{{name: "mod:ore"}}},
+			{y: 0, palette: []paletteEntry{{name: "minecraft:stone"}, {name: "mod:pipe"}}, blockStates: make([]byte, 8*256)},
+		},
+	}
+	// the first 16 blocks of section 0 are pipes
+	for i := range 8 {
+		rc.sections[1].blockStates[i] = 0x11
+	}
+
+	cd, err := newChunkConverter(bm).convert(rc)
+	require.NoError(t, err)
+	require.Equal(t, -16, cd.MinY)
+
+	ore, pipe := cd.Blocks[0][0], cd.Blocks[1][0]
+	require.NotEqual(t, ore, pipe)
+	require.Equal(t, stone, cd.Blocks[1][16])
+	for _, nid := range []uint16{ore, pipe} {
+		require.Equal(t, render.UnknownBlockName, bm.NidToName[nid])
+		require.Equal(t, bm.Tmpl[bm.unknownBase], bm.Tmpl[nid])
+		require.True(t, bm.IsSolid(nid))
+	}
+	require.Equal(t, pipe, bm.unknownNid("mod:pipe"))
+	require.Equal(t, map[string]int{"mod:ore": 4096, "mod:pipe": 16}, bm.UnknownBlocks())
+
+	// once the reserved nids run out, further blocks share the placeholder's
+	for i := 2; i < maxUnknownNids+10; i++ {
+		bm.unknownNid(fmt.Sprintf("mod:block%d", i))
+	}
+	require.Equal(t, bm.unknownBase, bm.unknownNid("mod:block300"))
+}
+
+func TestAirBlocks(t *testing.T) {
+	bm := testBlockMapper(t)
+	rc := &rawChunk{
+		dataVersion: 3700,
+		sections: []rawSection{{y: 0, palette: []paletteEntry{
+			{name: "minecraft:air"}, {name: "minecraft:cave_air"}, {name: "minecraft:void_air"}, {name: "minecraft:stone"},
+		}, blockStates: make([]byte, 8*256)}},
+	}
+	// air, cave air, void air and stone in a row, in the low bits of the first long
+	rc.sections[0].blockStates[7] = 0x10
+	rc.sections[0].blockStates[6] = 0x32
+	cd, err := newChunkConverter(bm).convert(rc)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{0, 0, 0, bm.NameToNid["minecraft:stone"]}, cd.Blocks[0][:4])
+	require.Empty(t, bm.UnknownBlocks())
+}
+
+func TestDescribe(t *testing.T) {
+	meta := render.BlockEntryMetadata{
+		Blocks: []render.BlockEntry{
+			{Name: "minecraft:air", DisplayName: "Air"},
+			{Name: "minecraft:oak_slab", DisplayName: "Oak Slab", States: [][]string{
+				{"type", "bottom", "double", "top"},
+				{"waterlogged", "false", "true"},
+			}},
+			{Name: render.UnknownBlockName, DisplayName: "Unknown Block"},
+		},
+		Biomes: make([]render.BiomeColors, len(render.Biomes)),
+	}
+	buf, err := json.Marshal(meta)
+	require.NoError(t, err)
+	bm, err := LoadBlockMapper(buf)
+	require.NoError(t, err)
+
+	slab := bm.NameToNid["minecraft:oak_slab"]
+	name, display, props := bm.Describe(slab, bm.nidToSmap[slab].Get("type=top,waterlogged=true"))
+	require.Equal(t, "minecraft:oak_slab", name)
+	require.Equal(t, "Oak Slab", display)
+	require.Equal(t, map[string]string{"type": "top", "waterlogged": "true"}, props)
+	_, _, props = bm.Describe(slab, 0)
+	require.Equal(t, map[string]string{"type": "bottom", "waterlogged": "false"}, props)
+
+	name, display, props = bm.Describe(bm.unknownNid("mod:pipe"), 0)
+	require.Equal(t, "mod:pipe", name)
+	require.Equal(t, "Unknown Block", display)
+	require.Nil(t, props)
+
+	name, _, _ = bm.Describe(0, 0)
+	require.Equal(t, "minecraft:air", name)
+}
+
+func TestReadRegionChunkErrors(t *testing.T) {
+	// a region with three broken chunks, each in its own sector
+	hdr := make([]byte, 8192)
+	var sectors []byte
+	addChunk := func(idx int, length uint32, scheme byte, data []byte) {
+		sector := make([]byte, 4096)
+		binary.BigEndian.PutUint32(sector, length)
+		sector[4] = scheme
+		copy(sector[5:], data)
+		binary.BigEndian.PutUint32(hdr[idx*4:], uint32(2+len(sectors)/4096)<<8|1)
+		sectors = append(sectors, sector...)
+	}
+	addChunk(1, 10, compressionCustom, nil)
+	addChunk(2+3*32, 9000, compressionZlib, nil)
+	addChunk(5, 5, 42, []byte("junk"))
+
+	dir := t.TempDir()
+	fn := path.Join(dir, "r.-1.2.mca")
+	require.NoError(t, os.WriteFile(fn, append(hdr, sectors...), 0644))
+
+	cdata, err := ReadRegion(fn, &BlockMapper{}, nil)
+	require.Len(t, cdata, 1024)
+
+	var chunkErrs ChunkErrors
+	require.True(t, errors.As(err, &chunkErrs), "got %v", err)
+	require.Len(t, chunkErrs, 3)
+
+	// chunks are read in file order
+	require.Equal(t, 1, chunkErrs[0].Index)
+	require.Equal(t, []int{-31, 64}, []int{chunkErrs[0].X, chunkErrs[0].Z})
+	require.Equal(t, 2+3*32, chunkErrs[1].Index)
+	require.Equal(t, []int{-30, 67}, []int{chunkErrs[1].X, chunkErrs[1].Z})
+	require.Equal(t, 5, chunkErrs[2].Index)
+	require.Error(t, chunkErrs[2].Err)
+
+	_, err = ReadRegion(path.Join(dir, "r.0.0.mca"), &BlockMapper{}, nil)
+	require.Error(t, err)
+	require.False(t, errors.As(err, &chunkErrs))
+}
+
+func TestBiomes(t *testing.T) {
+	bm := testBlockMapper(t)
+	plains, swamp, desert := render.BiomeByName("minecraft:plains"), render.BiomeByName("minecraft:swamp"), render.BiomeByName("minecraft:desert")
+
+	// 1.18+: a palette of 3 biomes takes 2 bits per entry, 32 per long
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data, 1|2<<62)
+	binary.BigEndian.PutUint64(data[8:], 2)
+	b := unpackBiomes(bm, []string{"minecraft:plains", "minecraft:swamp", "minecraft:desert"}, data)
+	require.Equal(t, swamp, b[0])
+	require.Equal(t, plains, b[1])
+	require.Equal(t, desert, b[31])
+	require.Equal(t, desert, b[32])
+	require.Equal(t, plains, b[63])
+
+	single := unpackBiomes(bm, []string{"minecraft:swamp"}, nil)
+	require.Equal(t, swamp, single[17])
+	require.Equal(t, "minecraft:swamp", bm.BiomeName(swamp))
+
+	// unknown (e.g. modded) biomes keep their names, and are tinted like plains
+	weird := unpackBiomes(bm, []string{"somemod:weird_biome"}, nil)[0]
+	require.NotEqual(t, plains, weird)
+	require.Equal(t, weird, unpackBiomes(bm, []string{"somemod:weird_biome"}, nil)[0])
+	require.Equal(t, "somemod:weird_biome", bm.BiomeName(weird))
+	require.Equal(t, bm.BiomeColors(plains), bm.BiomeColors(weird))
+	require.Equal(t, []string{"somemod:weird_biome"}, bm.UnknownBiomes())
+	for i := range 300 {
+		bm.biomeID(fmt.Sprintf("somemod:biome%d", i))
+	}
+	require.Equal(t, "unknown", bm.BiomeName(bm.biomeID("somemod:biome299")))
+
+	// 1.15-1.17: 4x4x64 ints, so section 1 starts at the 4th layer of cells
+	legacy := make([]byte, 1024*4)
+	binary.BigEndian.PutUint32(legacy[(4*16+5)*4:], 6) // swamp
+	b = legacyBiomesForSection(legacy, true, 1)
+	require.Equal(t, swamp, b[5])
+	require.Equal(t, render.BiomeByLegacyID(0), b[4])
+
+	// before 1.15: a 16x16 byte map
+	legacy = make([]byte, 256)
+	legacy[2+2*16] = 2 // desert
+	b = legacyBiomesForSection(legacy, false, 3)
+	require.Equal(t, desert, b[0])
+	require.Equal(t, desert, b[48])
+	require.NotEqual(t, desert, b[1])
+}
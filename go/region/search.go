@@ -0,0 +1,252 @@
+package region
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BlockQuery matches blocks by name, and optionally by state properties,
+// written like minecraft:chest[type=single,facing=north].
+type BlockQuery struct {
+	Name  string
+	Props map[string]string
+}
+
+// ParseBlockQuery parses a block name with optional [property=value,...]
+// predicates. Names without a namespace are in minecraft:.
+func ParseBlockQuery(s string) (BlockQuery, error) {
+	q := BlockQuery{}
+	name, props, hasProps := strings.Cut(strings.TrimSpace(s), "[")
+	if name == "" {
+		return q, errors.New("missing block name")
+	}
+	if !strings.Contains(name, ":") {
+		name = "minecraft:" + name
+	}
+	q.Name = name
+	if hasProps {
+		props, ok := strings.CutSuffix(props, "]")
+		if !ok {
+			return q, fmt.Errorf("%q: missing ]", s)
+		}
+		q.Props = map[string]string{}
+		for _, prop := range strings.Split(props, ",") {
+			k, v, ok := strings.Cut(strings.TrimSpace(prop), "=")
+			if !ok || k == "" {
+				return q, fmt.Errorf("%q: property %q isn't name=value", s, prop)
+			}
+			q.Props[k] = v
+		}
+	}
+	return q, nil
+}
+
+func (q *BlockQuery) matches(name string, props map[string]string) bool {
+	if name != q.Name {
+		return false
+	}
+	for k, v := range q.Props {
+		if props[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Box is an inclusive range of block positions.
+type Box struct {
+	MinX, MinY, MinZ int
+	MaxX, MaxY, MaxZ int
+}
+
+// EverywhereBox contains every position.
+var EverywhereBox = Box{math.MinInt, math.MinInt, math.MinInt, math.MaxInt, math.MaxInt, math.MaxInt}
+
+// ParseBox parses two corners, x1,y1,z1,x2,y2,z2, in either order.
+func ParseBox(s string) (Box, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 6 {
+		return Box{}, fmt.Errorf("box %q isn't x1,y1,z1,x2,y2,z2", s)
+	}
+	var v [6]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return Box{}, fmt.Errorf("box %q: %w", s, err)
+		}
+		v[i] = n
+	}
+	return Box{
+		min(v[0], v[3]), min(v[1], v[4]), min(v[2], v[5]),
+		max(v[0], v[3]), max(v[1], v[4]), max(v[2], v[5]),
+	}, nil
+}
+
+func (b *Box) Contains(x, y, z int) bool {
+	return x >= b.MinX && x <= b.MaxX && y >= b.MinY && y <= b.MaxY && z >= b.MinZ && z <= b.MaxZ
+}
+
+// OverlapsRegion reports whether any of a region's columns are in the box.
+func (b *Box) OverlapsRegion(rx, rz int) bool {
+	return rx*512+511 >= b.MinX && rx*512 <= b.MaxX && rz*512+511 >= b.MinZ && rz*512 <= b.MaxZ
+}
+
+// BlockMatch is a block found by SearchRegion.
+type BlockMatch struct {
+	X     int               `json:"x"`
+	Y     int               `json:"y"`
+	Z     int               `json:"z"`
+	Name  string            `json:"name"`
+	Props map[string]string `json:"properties,omitempty"`
+}
+
+// String formats a match like a block query, after its position.
+func (m *BlockMatch) String() string {
+	s := fmt.Sprintf("%d %d %d %s", m.X, m.Y, m.Z, m.Name)
+	if len(m.Props) == 0 {
+		return s
+	}
+	var parts []string
+	for k, v := range m.Props {
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+	return s + "[" + strings.Join(parts, ",") + "]"
+}
+
+// SearchRegion finds the blocks in a region file that match any of the queries
+// and are within box, in the order they're stored, stopping after limit matches
+// if it's positive.
+// Only the chunks overlapping the box are read, and sections whose palettes
+// have no matching entries are skipped without unpacking their block states.
+func SearchRegion(path string, bm *BlockMapper, queries []BlockQuery, box Box, limit int) ([]BlockMatch, error) {
+	rx, rz, err := ParseRegionPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if !box.OverlapsRegion(rx, rz) {
+		return nil, nil
+	}
+	var wanted []int
+	for cz := range 32 {
+		for cx := range 32 {
+			x, z := rx*512+cx*16, rz*512+cz*16
+			if x+15 >= box.MinX && x <= box.MaxX && z+15 >= box.MinZ && z <= box.MaxZ {
+				wanted = append(wanted, cx+cz*32)
+			}
+		}
+	}
+
+	names := map[string]bool{}
+	for _, q := range queries {
+		names[q.Name] = true
+	}
+	var matches []BlockMatch
+	matchAny := func(name string, props map[string]string) bool {
+		for i := range queries {
+			if queries[i].matches(name, props) {
+				return true
+			}
+		}
+		return false
+	}
+	full := func() bool { return limit > 0 && len(matches) >= limit }
+
+	err = readChunks(path, wanted, func(chunkNum, xPos, zPos int, data []byte) *ChunkError {
+		if full() {
+			return nil
+		}
+		rc, err := parseChunk(data)
+		if err != nil {
+			return &ChunkError{Reason: "unable to parse NBT", Err: err}
+		}
+		if rc.status != "" && rc.status != "minecraft:full" {
+			return nil // skip proto-chunks
+		}
+		palettes := make([][]paletteEntry, len(rc.sections))
+		for i := range rc.sections {
+			palettes[i] = rc.sections[i].palette
+		}
+		bm.migrate(rc.dataVersion, palettes)
+
+		for si := range rc.sections {
+			sec := &rc.sections[si]
+			baseX, baseY, baseZ := xPos*16, int(sec.y)*16, zPos*16
+			if baseY > box.MaxY || baseY+15 < box.MinY {
+				continue
+			}
+			add := func(i int, name string, props map[string]string) {
+				x, y, z := baseX+(i&15), baseY+(i>>8), baseZ+((i>>4)&15)
+				if box.Contains(x, y, z) && !full() {
+					matches = append(matches, BlockMatch{X: x, Y: y, Z: z, Name: name, Props: props})
+				}
+			}
+
+			if len(sec.blocks) > 0 {
+				// pre-1.13 sections have no palette, so each block is mapped
+				if len(sec.blocks) != 4096 || len(sec.blockData) != 2048 {
+					continue
+				}
+				for i, ob := range sec.blocks {
+					o := uint16(ob)<<4 | uint16((sec.blockData[i>>1]>>((i&1)<<2))&0xf)
+					nid, state := bm.blockstateToNid[o], bm.blockstateToNstate[o]
+					if nid == 0 {
+						nid, state = bm.blockstateToNid[o&^0xf], bm.blockstateToNstate[o&^0xf]
+					}
+					if nid == 0 {
+						continue
+					}
+					name, _, props := bm.Describe(nid, state)
+					if matchAny(name, props) {
+						add(i, name, props)
+					}
+				}
+				continue
+			}
+
+			hits := make([]map[string]string, len(sec.palette))
+			anyHits := false
+			for pi := range sec.palette {
+				entry := &sec.palette[pi]
+				if !names[entry.name] {
+					continue
+				}
+				props := map[string]string{}
+				for _, prop := range entry.props {
+					k, v, _ := strings.Cut(prop, "=")
+					props[k] = v
+				}
+				if matchAny(entry.name, props) {
+					hits[pi] = props
+					anyHits = true
+				}
+			}
+			if !anyHits {
+				continue
+			}
+			var idxes []uint16
+			if len(sec.blockStates) == 0 {
+				idxes = make([]uint16, 4096) // all palette[0]
+			} else if rc.dataVersion < 2529 {
+				idxes = blockstatesToShortsPacked(sec.blockStates)
+			} else {
+				idxes = blockstatesToShorts116(sec.blockStates)
+			}
+			for i, pi := range idxes {
+				if int(pi) < len(hits) && hits[pi] != nil {
+					props := hits[pi]
+					if len(props) == 0 {
+						props = nil
+					}
+					add(i, sec.palette[pi].name, props)
+				}
+			}
+		}
+		return nil
+	})
+	return matches, err
+}
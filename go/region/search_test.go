@@ -0,0 +1,86 @@
+package region
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBlockQuery(t *testing.T) {
+	q, err := ParseBlockQuery("chest[type=single, facing=north]")
+	require.NoError(t, err)
+	require.Equal(t, BlockQuery{Name: "minecraft:chest", Props: map[string]string{"type": "single", "facing": "north"}}, q)
+
+	q, err = ParseBlockQuery("mod:pipe")
+	require.NoError(t, err)
+	require.Equal(t, BlockQuery{Name: "mod:pipe"}, q)
+
+	for _, bad := range []string{"", "[type=single]", "chest[type=single", "chest[single]"} {
+		_, err = ParseBlockQuery(bad)
+		require.Error(t, err, bad)
+	}
+
+	box, err := ParseBox("10,80,-5,-10,0,5")
+	require.NoError(t, err)
+	require.Equal(t, Box{-10, 0, -5, 10, 80, 5}, box)
+	_, err = ParseBox("1,2,3")
+	require.Error(t, err)
+}
+
+func TestSearchRegion(t *testing.T) {
+	// 4 bits per block, 16 to a long
+	idxes := make([]uint16, 4096)
+	set := func(x, y, z int, v uint16) {
+		idxes[x+z*16+y*256] = v
+	}
+	set(5, 3, 2, 1)
+	set(6, 3, 2, 2)
+	set(7, 15, 2, 1)
+	chunk := encodeSNBT(t, `{DataVersion: 3700, Status: "minecraft:full", sections: [
+		{Y: -1b, block_states: {palette: [{Name: "minecraft:stone"}]}},
+		{Y: 0b, block_states: {data: %s, palette: [
+			{Name: "minecraft:air"},
+			{Name: "minecraft:chest", Properties: {type: "single"}},
+			{Name: "minecraft:chest", Properties: {type: "left"}}
+		]}}
+	]}`, packStates(idxes, 4, false))
+
+	// the chunk at 1,2 in region -1,0
+	var w Writer
+	require.NoError(t, w.AddNBT(-31, 2, chunk, time.Time{}))
+	fn := path.Join(t.TempDir(), w.Filename())
+	require.NoError(t, w.WriteFile(fn))
+
+	bm := testBlockMapper(t)
+	chest := []BlockQuery{{Name: "minecraft:chest"}}
+	matches, err := SearchRegion(fn, bm, chest, EverywhereBox, 0)
+	require.NoError(t, err)
+	require.Equal(t, []BlockMatch{
+		{X: -491, Y: 3, Z: 34, Name: "minecraft:chest", Props: map[string]string{"type": "single"}},
+		{X: -490, Y: 3, Z: 34, Name: "minecraft:chest", Props: map[string]string{"type": "left"}},
+		{X: -489, Y: 15, Z: 34, Name: "minecraft:chest", Props: map[string]string{"type": "single"}},
+	}, matches)
+	require.Equal(t, "-491 3 34 minecraft:chest[type=single]", matches[0].String())
+
+	single := []BlockQuery{{Name: "minecraft:chest", Props: map[string]string{"type": "single"}}}
+	matches, err = SearchRegion(fn, bm, single, Box{-500, 0, 0, -480, 10, 40}, 0)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	require.Equal(t, -491, matches[0].X)
+
+	matches, err = SearchRegion(fn, bm, chest, EverywhereBox, 2)
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+
+	// the whole section below is stone, with no states to unpack
+	matches, err = SearchRegion(fn, bm, []BlockQuery{{Name: "minecraft:stone"}}, Box{-491, -16, 34, -491, 0, 34}, 0)
+	require.NoError(t, err)
+	require.Len(t, matches, 16)
+
+	// a box elsewhere doesn't read the region at all
+	matches, err = SearchRegion(path.Join(t.TempDir(), "r.5.5.mca"), bm, chest, Box{0, 0, 0, 10, 10, 10}, 0)
+	require.NoError(t, err)
+	require.Nil(t, matches)
+}
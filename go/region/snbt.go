@@ -0,0 +1,106 @@
+package region
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rmmh/cubeographer/go/region/nbt"
+)
+
+// NbtPath selects tags within NBT, like sections[*].block_states.palette.
+// Each element is a compound key, an index like "[3]", or a wildcard,
+// "*" for any key or "[*]" for any index.
+type NbtPath []string
+
+var nbtPathRe = regexp.MustCompile(`^([^.\[\]]*)((?:\[(?:\d+|\*)\])*)$`)
+
+// ParseNbtPath parses a path of keys separated by dots, each followed by
+// any number of list indexes.
+func ParseNbtPath(s string) (NbtPath, error) {
+	var p NbtPath
+	for _, part := range strings.Split(s, ".") {
+		m := nbtPathRe.FindStringSubmatch(part)
+		if m == nil || m[1] == "" {
+			return nil, fmt.Errorf("bad NBT path %q", s)
+		}
+		p = append(p, m[1])
+		for _, idx := range strings.SplitAfter(m[2], "]") {
+			if idx != "" {
+				p = append(p, idx)
+			}
+		}
+	}
+	return p, nil
+}
+
+// findNbt calls fn with each tag within v matched by p, and its full path.
+func findNbt(v nbt.Value, p NbtPath, prefix string, fn func(path string, v nbt.Value)) {
+	if len(p) == 0 {
+		fn(prefix, v)
+		return
+	}
+	switch v := v.(type) {
+	case nbt.Compound:
+		for _, f := range v {
+			if p[0] == f.Name || p[0] == "*" {
+				path := f.Name
+				if prefix != "" {
+					path = prefix + "." + f.Name
+				}
+				findNbt(f.Value, p[1:], path, fn)
+			}
+		}
+	case nbt.AnyList:
+		for i := range v.Len() {
+			label := "[" + strconv.Itoa(i) + "]"
+			if p[0] == label || p[0] == "[*]" {
+				findNbt(v.Index(i), p[1:], prefix+label, fn)
+			}
+		}
+	}
+}
+
+// PrintNBT pretty-prints NBT as SNBT, or as JSON if asJSON is set. If any paths
+// are given, only the tags they match are printed, labeled with their paths.
+func PrintNBT(w io.Writer, buf []byte, paths []NbtPath, asJSON bool) error {
+	_, root, err := nbt.Decode(buf)
+	if err != nil {
+		return err
+	}
+	var out string
+	if len(paths) == 0 {
+		if asJSON {
+			js, err := json.MarshalIndent(root, "", "  ")
+			if err != nil {
+				return err
+			}
+			out = string(js) + "\n"
+		} else {
+			out = nbt.FormatSNBT(root, "    ") + "\n"
+		}
+	} else {
+		var found nbt.Compound
+		for _, p := range paths {
+			findNbt(root, p, "", func(path string, v nbt.Value) {
+				found = append(found, nbt.Field{Name: path, Value: v})
+			})
+		}
+		if asJSON {
+			js, err := json.MarshalIndent(found, "", "  ")
+			if err != nil {
+				return err
+			}
+			out = string(js) + "\n"
+		} else {
+			for _, f := range found {
+				out += f.Name + ": " + nbt.FormatSNBT(f.Value, "    ") + "\n"
+			}
+		}
+	}
+	_, err = io.WriteString(w, out)
+	return err
+}
@@ -0,0 +1,108 @@
+package region
+
+import (
+	"bytes"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintNBT(t *testing.T) {
+	chunk := encodeSNBT(t, `{DataVersion: 3700, Status: "minecraft:full",
+		sections: [
+			{Y: -4b, block_states: {palette: [{Name: "minecraft:stone"}]}},
+			{Y: 0b, block_states: {palette: [
+				{Name: "minecraft:air"},
+				{Name: "minecraft:chest", Properties: {type: "single"}}
+			]}}
+		],
+		Pos: [1.5d, -64d],
+		heights: [L; 1L, 1099511627776L],
+		"custom name": "say \"hi\""}`)
+
+	print := func(asJSON bool, paths ...string) string {
+		var ps []NbtPath
+		for _, s := range paths {
+			p, err := ParseNbtPath(s)
+			require.NoError(t, err)
+			ps = append(ps, p)
+		}
+		var out bytes.Buffer
+		require.NoError(t, PrintNBT(&out, chunk, ps, asJSON))
+		return out.String()
+	}
+
+	require.Equal(t, `{
+    DataVersion: 3700,
+    Status: "minecraft:full",
+    sections: [
+        {
+            Y: -4b,
+            block_states: {
+                palette: [
+                    {
+                        Name: "minecraft:stone"
+                    }
+                ]
+            }
+        },
+        {
+            Y: 0b,
+            block_states: {
+                palette: [
+                    {
+                        Name: "minecraft:air"
+                    },
+                    {
+                        Name: "minecraft:chest",
+                        Properties: {
+                            type: "single"
+                        }
+                    }
+                ]
+            }
+        }
+    ],
+    Pos: [1.5d, -64d],
+    heights: [L; 1L, 1099511627776L],
+    "custom name": "say \"hi\""
+}
+`, print(false))
+
+	require.Equal(t, `sections[0].block_states.palette[0].Name: "minecraft:stone"
+sections[1].block_states.palette[0].Name: "minecraft:air"
+sections[1].block_states.palette[1].Name: "minecraft:chest"
+`, print(false, "sections[*].block_states.palette[*].Name"))
+
+	require.JSONEq(t, `{
+		"sections[1].block_states.palette[1]": {"Name": "minecraft:chest", "Properties": {"type": "single"}},
+		"Pos": [1.5, -64]
+	}`, print(true, "sections[1].*.palette[1]", "Pos"))
+
+	require.JSONEq(t, `{"sections[0]": {"Y": -4, "block_states": {"palette": [{"Name": "minecraft:stone"}]}}}`,
+		print(true, "sections[0]"))
+
+	for _, bad := range []string{"", "a..b", "a[x]", "[0]", "a[0"} {
+		_, err := ParseNbtPath(bad)
+		require.Error(t, err, bad)
+	}
+
+	// the chunk at 1,2 in region -1,0
+	var w Writer
+	require.NoError(t, w.AddNBT(-31, 2, chunk, time.Time{}))
+	fn := path.Join(t.TempDir(), w.Filename())
+	require.NoError(t, w.WriteFile(fn))
+
+	buf, err := ReadChunkNBT(fn, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, chunk, buf)
+	buf, err = ReadChunkNBT(fn, -31, 2)
+	require.NoError(t, err)
+	require.Equal(t, chunk, buf)
+	_, err = ReadChunkNBT(fn, 2, 2)
+	require.Error(t, err)
+	_, err = ReadChunkNBT(fn, 40, 2)
+	require.Error(t, err)
+}
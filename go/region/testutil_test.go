@@ -0,0 +1,43 @@
+package region
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/rmmh/cubeographer/go/region/nbt"
+	"github.com/rmmh/cubeographer/go/render"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeSNBT gives the NBT of a compound written as SNBT, with the
+// arrays formatted in for each %s.
+func encodeSNBT(t testing.TB, format string, arrays ...nbt.Value) []byte {
+	args := make([]any, len(arrays))
+	for i, a := range arrays {
+		args[i] = nbt.FormatSNBT(a, "")
+	}
+	v, err := nbt.ParseSNBT(fmt.Sprintf(format, args...))
+	require.NoError(t, err)
+	buf, err := nbt.Encode("", v.(nbt.Compound))
+	require.NoError(t, err)
+	return buf
+}
+
+// testBlockMapper knows stone, and has the placeholder for unknown blocks.
+func testBlockMapper(t testing.TB) *BlockMapper {
+	meta := render.BlockEntryMetadata{
+		Blocks: []render.BlockEntry{
+			{Name: "air"},
+			{Name: "minecraft:stone", Solid: true, Templates: []render.ModelEntry{{Layer: render.LayerCube, Template: []uint32{1 << 24, 0b111111}}}},
+			{Name: render.UnknownBlockName, Solid: true, Templates: []render.ModelEntry{{Layer: render.LayerCubeFallback, Template: []uint32{2 << 24, 0b111111}}}},
+		},
+		Biomes:       make([]render.BiomeColors, len(render.Biomes)),
+		WorldVersion: 3700,
+	}
+	buf, err := json.Marshal(meta)
+	require.NoError(t, err)
+	bm, err := LoadBlockMapper(buf)
+	require.NoError(t, err)
+	return bm
+}
@@ -0,0 +1,284 @@
+package region
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"time"
+
+	"github.com/klauspost/compress/zlib"
+	"github.com/rmmh/cubeographer/go/region/nbt"
+)
+
+// Data versions that change how Writer lays out chunks.
+const (
+	// 20w17a (1.16) stopped block states from spanning longs
+	DataVersionUnpackedStates = 2529
+	// 21w43a (1.18) moved everything out of Level and added block_states
+	DataVersionNoLevel = 2844
+)
+
+// Block is a block state, like minecraft:chest[type=single].
+type Block struct {
+	Name  string
+	Props map[string]string
+}
+
+// Section is a 16x16x16 cube of blocks to write.
+type Section struct {
+	// Y is the section's index, so it covers Y*16 through Y*16+15.
+	Y int
+	// Palette holds the section's block states, and must not be empty.
+	Palette []Block
+	// Blocks holds an index into Palette for each block, ordered
+	// x + z*16 + y*256 like ChunkDatum.Blocks. Nil means all Palette[0].
+	Blocks []uint16
+	// BlockLight and SkyLight are 2048 bytes of nibbles, or nil.
+	BlockLight, SkyLight []byte
+	// Biome fills the section, if set. Only written since 1.18.
+	Biome string
+}
+
+// Chunk is a chunk to write with a Writer.
+type Chunk struct {
+	// X and Z are world chunk coordinates.
+	X, Z int
+	// DataVersion picks the format, from 1.13 (1519) on.
+	DataVersion int
+	Sections    []Section
+	// BlockEntities are written as they are.
+	BlockEntities []nbt.Compound
+	// Modified is when the chunk was saved, or now if zero.
+	Modified time.Time
+}
+
+// packStates packs palette indexes into big endian longs, bpb bits to each.
+// Before 1.16 indexes are packed tightly, spanning longs.
+func packStates(idxes []uint16, bpb int, spanning bool) []int64 {
+	perLong := 64 / bpb
+	n := (len(idxes) + perLong - 1) / perLong
+	if spanning {
+		n = (len(idxes)*bpb + 63) / 64
+	}
+	longs := make([]uint64, n)
+	bit := 0
+	for _, v := range idxes {
+		if !spanning && bit%64+bpb > 64 {
+			bit += 64 - bit%64
+		}
+		longs[bit/64] |= uint64(v) << (bit % 64)
+		if bit%64+bpb > 64 {
+			longs[bit/64+1] |= uint64(v) >> (64 - bit%64)
+		}
+		bit += bpb
+	}
+	ret := make([]int64, n)
+	for i, l := range longs {
+		ret[i] = int64(l)
+	}
+	return ret
+}
+
+func (b Block) compound() nbt.Compound {
+	c := nbt.Compound{{Name: "Name", Value: b.Name}}
+	if len(b.Props) > 0 {
+		// sorted by name
+		v, _ := nbt.MarshalValue(b.Props)
+		c = append(c, nbt.Field{Name: "Properties", Value: v})
+	}
+	return c
+}
+
+func nibbles(light []byte) []int8 {
+	ret := make([]int8, len(light))
+	for i, b := range light {
+		ret[i] = int8(b)
+	}
+	return ret
+}
+
+func (s *Section) compound(dataVersion int) (nbt.Compound, error) {
+	if len(s.Palette) == 0 {
+		return nil, fmt.Errorf("section %d has no palette", s.Y)
+	}
+	if s.Blocks != nil && len(s.Blocks) != 4096 {
+		return nil, fmt.Errorf("section %d has %d blocks, not 4096", s.Y, len(s.Blocks))
+	}
+	for _, v := range s.Blocks {
+		if int(v) >= len(s.Palette) {
+			return nil, fmt.Errorf("section %d: palette index %d out of range (%d entries)", s.Y, v, len(s.Palette))
+		}
+	}
+	palette := make(nbt.List[nbt.Compound], len(s.Palette))
+	for i, b := range s.Palette {
+		palette[i] = b.compound()
+	}
+	bpb := max(4, bits.Len(uint(len(s.Palette)-1)))
+	idxes := s.Blocks
+	if idxes == nil {
+		idxes = make([]uint16, 4096)
+	}
+
+	c := nbt.Compound{{Name: "Y", Value: int8(s.Y)}}
+	if dataVersion >= DataVersionNoLevel {
+		states := nbt.Compound{{Name: "palette", Value: palette}}
+		if len(s.Palette) > 1 {
+			states = append(states, nbt.Field{Name: "data", Value: packStates(idxes, bpb, false)})
+		}
+		c = append(c, nbt.Field{Name: "block_states", Value: states})
+		if s.Biome != "" {
+			c = append(c, nbt.Field{Name: "biomes", Value: nbt.Compound{{Name: "palette", Value: nbt.List[string]{s.Biome}}}})
+		}
+	} else {
+		c = append(c,
+			nbt.Field{Name: "Palette", Value: palette},
+			nbt.Field{Name: "BlockStates", Value: packStates(idxes, bpb, dataVersion < DataVersionUnpackedStates)})
+	}
+	if s.BlockLight != nil {
+		c = append(c, nbt.Field{Name: "BlockLight", Value: nibbles(s.BlockLight)})
+	}
+	if s.SkyLight != nil {
+		c = append(c, nbt.Field{Name: "SkyLight", Value: nibbles(s.SkyLight)})
+	}
+	return c, nil
+}
+
+// Encode gives the chunk's NBT, as the game would save it.
+func (c *Chunk) Encode() ([]byte, error) {
+	sections := make(nbt.List[nbt.Compound], len(c.Sections))
+	for i := range c.Sections {
+		sec, err := c.Sections[i].compound(c.DataVersion)
+		if err != nil {
+			return nil, err
+		}
+		sections[i] = sec
+	}
+	blockEntities := nbt.List[nbt.Compound](c.BlockEntities)
+	if blockEntities == nil {
+		blockEntities = nbt.List[nbt.Compound]{}
+	}
+
+	var root nbt.Compound
+	if c.DataVersion >= DataVersionNoLevel {
+		root = nbt.Compound{
+			{Name: "DataVersion", Value: int32(c.DataVersion)},
+			{Name: "xPos", Value: int32(c.X)},
+			{Name: "zPos", Value: int32(c.Z)},
+			{Name: "Status", Value: "minecraft:full"},
+			{Name: "sections", Value: sections},
+			{Name: "block_entities", Value: blockEntities},
+		}
+		if len(c.Sections) > 0 {
+			minY := c.Sections[0].Y
+			for _, s := range c.Sections {
+				minY = min(minY, s.Y)
+			}
+			root = append(root, nbt.Field{Name: "yPos", Value: int32(minY)})
+		}
+	} else {
+		root = nbt.Compound{
+			{Name: "DataVersion", Value: int32(c.DataVersion)},
+			{Name: "Level", Value: nbt.Compound{
+				{Name: "xPos", Value: int32(c.X)},
+				{Name: "zPos", Value: int32(c.Z)},
+				{Name: "Sections", Value: sections},
+				{Name: "TileEntities", Value: blockEntities},
+			}},
+		}
+	}
+	return nbt.Encode("", root)
+}
+
+// Writer builds a region file, for tests and synthetic worlds.
+// The zero value is an empty region.
+type Writer struct {
+	rx, rz     int
+	hasRegion  bool
+	chunks     [1024][]byte
+	timestamps [1024]uint32
+}
+
+// Add encodes a chunk and adds it to the region, replacing any chunk
+// already at its position. All the chunks must be in the same region.
+func (w *Writer) Add(c *Chunk) error {
+	buf, err := c.Encode()
+	if err != nil {
+		return fmt.Errorf("chunk %d,%d: %w", c.X, c.Z, err)
+	}
+	return w.AddNBT(c.X, c.Z, buf, c.Modified)
+}
+
+// AddNBT adds a chunk's uncompressed NBT to the region as it is.
+func (w *Writer) AddNBT(cx, cz int, buf []byte, modified time.Time) error {
+	if !w.hasRegion {
+		w.rx, w.rz, w.hasRegion = cx>>5, cz>>5, true
+	} else if cx>>5 != w.rx || cz>>5 != w.rz {
+		return fmt.Errorf("chunk %d,%d isn't in region %d,%d", cx, cz, w.rx, w.rz)
+	}
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+	var zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	zw.Write(buf)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	i := (cx & 31) + (cz&31)*32
+	w.chunks[i] = zbuf.Bytes()
+	w.timestamps[i] = uint32(modified.Unix())
+	return nil
+}
+
+// Filename gives the name of the region file, like r.-1.2.mca.
+func (w *Writer) Filename() string {
+	return fmt.Sprintf("r.%d.%d.mca", w.rx, w.rz)
+}
+
+// WriteTo writes the region file: the header of chunk offsets and
+// timestamps, then each chunk padded out to whole sectors.
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	hdr := make([]byte, 8192)
+	var sectors []byte
+	for i, chunk := range w.chunks {
+		if chunk == nil {
+			continue
+		}
+		size := (len(chunk) + 5 + 4095) / 4096
+		if size > 255 {
+			return 0, fmt.Errorf("chunk %d is too large (%d sectors)", i, size)
+		}
+		sector := make([]byte, size*4096)
+		binary.BigEndian.PutUint32(sector, uint32(len(chunk)+1))
+		sector[4] = compressionZlib
+		copy(sector[5:], chunk)
+		binary.BigEndian.PutUint32(hdr[i*4:], uint32(2+len(sectors)/4096)<<8|uint32(size))
+		binary.BigEndian.PutUint32(hdr[4096+i*4:], w.timestamps[i])
+		sectors = append(sectors, sector...)
+	}
+	n, err := out.Write(append(hdr, sectors...))
+	return int64(n), err
+}
+
+// WriteFile writes the region file to a path, whose name must match the region.
+func (w *Writer) WriteFile(path string) error {
+	rx, rz, err := ParseRegionPath(path)
+	if err != nil {
+		return err
+	}
+	if w.hasRegion && (rx != w.rx || rz != w.rz) {
+		return fmt.Errorf("%s doesn't hold region %d,%d", path, w.rx, w.rz)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := w.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,97 @@
+package region
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/rmmh/cubeographer/go/region/nbt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	bm := testBlockMapper(t)
+	stone := bm.NameToNid["minecraft:stone"]
+	chest := bm.unknownNid("minecraft:chest")
+
+	palette := []Block{{Name: "minecraft:stone"}, {Name: "minecraft:chest", Props: map[string]string{"type": "single", "facing": "north"}}}
+	// 17 entries needs 5 bits, which span longs before 1.16
+	for range 15 {
+		palette = append(palette, Block{Name: "minecraft:stone"})
+	}
+	blocks := make([]uint16, 4096)
+	for i := range blocks {
+		blocks[i] = uint16(i % 17)
+	}
+	light := bytes.Repeat([]byte{0xf0}, 2048)
+
+	modified := time.Unix(1700000000, 0)
+	for _, dataVersion := range []int{1976, 2586, 3700} {
+		var w Writer
+		for i := range 3 {
+			require.NoError(t, w.Add(&Chunk{
+				X: -32 + i, Z: 64 + i*2,
+				DataVersion: dataVersion,
+				Sections: []Section{
+					{Y: 1, Palette: palette, Blocks: blocks, BlockLight: light, Biome: "minecraft:desert"},
+					{Y: -1, Palette: palette[:1]},
+				},
+				BlockEntities: []nbt.Compound{{{Name: "id", Value: "minecraft:chest"}, {Name: "x", Value: int32(0)}, {Name: "y", Value: int32(16)}, {Name: "z", Value: int32(0)}}},
+				Modified:      modified.Add(time.Duration(i) * time.Second),
+			}))
+		}
+		require.Equal(t, "r.-1.2.mca", w.Filename())
+		fn := path.Join(t.TempDir(), w.Filename())
+		require.NoError(t, w.WriteFile(fn))
+
+		hdr, err := ReadRegionHeader(fn)
+		require.NoError(t, err)
+		require.Equal(t, uint32(1700000002), hdr.Timestamps[2+4*32])
+		require.Equal(t, uint32(2<<8|1), hdr.Offsets[0])
+		require.Zero(t, hdr.Offsets[1])
+
+		cdata, err := ReadRegion(fn, bm, nil)
+		require.NoError(t, err, dataVersion)
+		cd := cdata[1+2*32]
+		require.Equal(t, -16, cd.MinY)
+		require.Len(t, cd.Blocks, 3)
+		require.Equal(t, stone, cd.Blocks[0][100])
+		require.NotContains(t, cd.Blocks[1], stone)
+		for i, v := range blocks {
+			want := stone
+			if v == 1 {
+				want = chest
+			}
+			require.Equal(t, want, cd.Blocks[2][i], "version %d block %d", dataVersion, i)
+		}
+		require.Equal(t, light, cd.Lights[2])
+		require.Len(t, cd.BlockEntities, 1)
+		if dataVersion >= DataVersionNoLevel {
+			require.Equal(t, cd.Biomes[2][0], cd.Biomes[2][63])
+		}
+
+		// the chunk NBT comes back out as it went in
+		buf, err := ReadChunkNBT(fn, -31, 66)
+		require.NoError(t, err)
+		_, root, err := nbt.Decode(buf)
+		require.NoError(t, err)
+		require.Equal(t, int32(dataVersion), root.Get("DataVersion"))
+	}
+
+	var w Writer
+	require.NoError(t, w.Add(&Chunk{X: 0, Z: 0}))
+	require.ErrorContains(t, w.Add(&Chunk{X: 32, Z: 0}), "isn't in region 0,0")
+	require.ErrorContains(t, w.Add(&Chunk{Sections: []Section{{Y: 0}}}), "no palette")
+	require.ErrorContains(t, w.Add(&Chunk{Sections: []Section{{Palette: palette[:1], Blocks: blocks}}}), "out of range")
+	require.ErrorContains(t, w.WriteFile(path.Join(t.TempDir(), "r.1.0.mca")), "doesn't hold region 0,0")
+
+	// an empty region is just a header
+	var empty Writer
+	fn := path.Join(t.TempDir(), "r.5.5.mca")
+	require.NoError(t, empty.WriteFile(fn))
+	st, err := os.Stat(fn)
+	require.NoError(t, err)
+	require.Equal(t, int64(8192), st.Size())
+}
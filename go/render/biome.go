@@ -0,0 +1,235 @@
+package render
+
+import (
+	"image"
+	"math"
+	"strings"
+)
+
+type grassModifier int
+
+const (
+	grassNormal grassModifier = iota
+	grassDarkForest
+	grassSwamp
+)
+
+// Biome holds the climate values used to pick colors from the colormaps,
+// and the fixed colors some biomes use instead.
+// Values are from minecraft/data/worldgen/biome/OverworldBiomes.java and friends.
+type Biome struct {
+	Name                  string
+	Temperature, Downfall float64
+	Water                 uint32 // 0 for the default
+	Grass, Foliage        uint32 // 0 to use the colormaps
+	modifier              grassModifier
+}
+
+const (
+	defaultWaterColor   = 0x3f76e4
+	defaultGrassColor   = 0x91bd59 // plains, used if the colormap is missing
+	defaultFoliageColor = 0x77ab2f
+)
+
+// Biomes is indexed by the biome ids stored in region.ChunkDatum.
+// The first entry is used for unknown biomes.
+var Biomes = []Biome{
+	{Name: "plains", Temperature: 0.8, Downfall: 0.4},
+	{Name: "the_void", Temperature: 0.5, Downfall: 0.5},
+	{Name: "sunflower_plains", Temperature: 0.8, Downfall: 0.4},
+	{Name: "snowy_plains", Temperature: 0.0, Downfall: 0.5},
+	{Name: "ice_spikes", Temperature: 0.0, Downfall: 0.5},
+	{Name: "desert", Temperature: 2.0, Downfall: 0.0},
+	{Name: "swamp", Temperature: 0.8, Downfall: 0.9, Water: 0x617b64, Foliage: 0x6a7039, modifier: grassSwamp},
+	{Name: "mangrove_swamp", Temperature: 0.8, Downfall: 0.9, Water: 0x3a7a6a, Foliage: 0x8db127, modifier: grassSwamp},
+	{Name: "forest", Temperature: 0.7, Downfall: 0.8},
+	{Name: "flower_forest", Temperature: 0.7, Downfall: 0.8},
+	{Name: "birch_forest", Temperature: 0.6, Downfall: 0.6},
+	{Name: "dark_forest", Temperature: 0.7, Downfall: 0.8, modifier: grassDarkForest},
+	{Name: "pale_garden", Temperature: 0.7, Downfall: 0.8, Water: 0x76889d, Grass: 0x778272, Foliage: 0x878d76},
+	{Name: "old_growth_birch_forest", Temperature: 0.6, Downfall: 0.6},
+	{Name: "old_growth_pine_taiga", Temperature: 0.3, Downfall: 0.8},
+	{Name: "old_growth_spruce_taiga", Temperature: 0.25, Downfall: 0.8},
+	{Name: "taiga", Temperature: 0.25, Downfall: 0.8},
+	{Name: "snowy_taiga", Temperature: -0.5, Downfall: 0.4, Water: 0x3d57d6},
+	{Name: "savanna", Temperature: 2.0, Downfall: 0.0},
+	{Name: "savanna_plateau", Temperature: 2.0, Downfall: 0.0},
+	{Name: "windswept_hills", Temperature: 0.2, Downfall: 0.3},
+	{Name: "windswept_gravelly_hills", Temperature: 0.2, Downfall: 0.3},
+	{Name: "windswept_forest", Temperature: 0.2, Downfall: 0.3},
+	{Name: "windswept_savanna", Temperature: 2.0, Downfall: 0.0},
+	{Name: "jungle", Temperature: 0.95, Downfall: 0.9},
+	{Name: "sparse_jungle", Temperature: 0.95, Downfall: 0.8},
+	{Name: "bamboo_jungle", Temperature: 0.95, Downfall: 0.9},
+	{Name: "badlands", Temperature: 2.0, Downfall: 0.0, Grass: 0x90814d, Foliage: 0x9e814d},
+	{Name: "eroded_badlands", Temperature: 2.0, Downfall: 0.0, Grass: 0x90814d, Foliage: 0x9e814d},
+	{Name: "wooded_badlands", Temperature: 2.0, Downfall: 0.0, Grass: 0x90814d, Foliage: 0x9e814d},
+	{Name: "meadow", Temperature: 0.5, Downfall: 0.8, Water: 0x0e4ecf},
+	{Name: "cherry_grove", Temperature: 0.5, Downfall: 0.8, Water: 0x5db7ef, Grass: 0xb6db61, Foliage: 0xb6db61},
+	{Name: "grove", Temperature: -0.2, Downfall: 0.8},
+	{Name: "snowy_slopes", Temperature: -0.3, Downfall: 0.9},
+	{Name: "frozen_peaks", Temperature: -0.7, Downfall: 0.9},
+	{Name: "jagged_peaks", Temperature: -0.7, Downfall: 0.9},
+	{Name: "stony_peaks", Temperature: 1.0, Downfall: 0.3},
+	{Name: "river", Temperature: 0.5, Downfall: 0.5},
+	{Name: "frozen_river", Temperature: 0.0, Downfall: 0.5, Water: 0x3938c9},
+	{Name: "beach", Temperature: 0.8, Downfall: 0.4},
+	{Name: "snowy_beach", Temperature: 0.05, Downfall: 0.3, Water: 0x3d57d6},
+	{Name: "stony_shore", Temperature: 0.2, Downfall: 0.3},
+	{Name: "warm_ocean", Temperature: 0.5, Downfall: 0.5, Water: 0x43d5ee},
+	{Name: "lukewarm_ocean", Temperature: 0.5, Downfall: 0.5, Water: 0x45adf2},
+	{Name: "deep_lukewarm_ocean", Temperature: 0.5, Downfall: 0.5, Water: 0x45adf2},
+	{Name: "ocean", Temperature: 0.5, Downfall: 0.5},
+	{Name: "deep_ocean", Temperature: 0.5, Downfall: 0.5},
+	{Name: "cold_ocean", Temperature: 0.5, Downfall: 0.5, Water: 0x3d57d6},
+	{Name: "deep_cold_ocean", Temperature: 0.5, Downfall: 0.5, Water: 0x3d57d6},
+	{Name: "frozen_ocean", Temperature: 0.0, Downfall: 0.5, Water: 0x3938c9},
+	{Name: "deep_frozen_ocean", Temperature: 0.5, Downfall: 0.5, Water: 0x3938c9},
+	{Name: "mushroom_fields", Temperature: 0.9, Downfall: 1.0},
+	{Name: "dripstone_caves", Temperature: 0.8, Downfall: 0.4},
+	{Name: "lush_caves", Temperature: 0.5, Downfall: 0.5},
+	{Name: "deep_dark", Temperature: 0.8, Downfall: 0.4},
+	{Name: "nether_wastes", Temperature: 2.0, Downfall: 0.0},
+	{Name: "warped_forest", Temperature: 2.0, Downfall: 0.0},
+	{Name: "crimson_forest", Temperature: 2.0, Downfall: 0.0},
+	{Name: "soul_sand_valley", Temperature: 2.0, Downfall: 0.0},
+	{Name: "basalt_deltas", Temperature: 2.0, Downfall: 0.0},
+	{Name: "the_end", Temperature: 0.5, Downfall: 0.5},
+	{Name: "end_highlands", Temperature: 0.5, Downfall: 0.5},
+	{Name: "end_midlands", Temperature: 0.5, Downfall: 0.5},
+	{Name: "small_end_islands", Temperature: 0.5, Downfall: 0.5},
+	{Name: "end_barrens", Temperature: 0.5, Downfall: 0.5},
+}
+
+// numeric biome ids used before 1.18, mapped to their modern equivalents
+var legacyBiomeNames = map[int]string{
+	0: "ocean", 1: "plains", 2: "desert", 3: "windswept_hills", 4: "forest",
+	5: "taiga", 6: "swamp", 7: "river", 8: "nether_wastes", 9: "the_end",
+	10: "frozen_ocean", 11: "frozen_river", 12: "snowy_plains", 13: "snowy_plains",
+	14: "mushroom_fields", 15: "mushroom_fields", 16: "beach", 17: "desert",
+	18: "forest", 19: "taiga", 20: "windswept_hills", 21: "jungle", 22: "jungle",
+	23: "sparse_jungle", 24: "deep_ocean", 25: "stony_shore", 26: "snowy_beach",
+	27: "birch_forest", 28: "birch_forest", 29: "dark_forest", 30: "snowy_taiga",
+	31: "snowy_taiga", 32: "old_growth_pine_taiga", 33: "old_growth_pine_taiga",
+	34: "windswept_forest", 35: "savanna", 36: "savanna_plateau", 37: "badlands",
+	38: "wooded_badlands", 39: "badlands", 40: "small_end_islands", 41: "end_midlands",
+	42: "end_highlands", 43: "end_barrens", 44: "warm_ocean", 45: "lukewarm_ocean",
+	46: "cold_ocean", 47: "warm_ocean", 48: "deep_lukewarm_ocean", 49: "deep_cold_ocean",
+	50: "deep_frozen_ocean", 127: "the_void", 129: "sunflower_plains", 130: "desert",
+	131: "windswept_gravelly_hills", 132: "flower_forest", 133: "taiga", 134: "swamp",
+	140: "ice_spikes", 149: "jungle", 151: "sparse_jungle", 155: "old_growth_birch_forest",
+	156: "old_growth_birch_forest", 157: "dark_forest", 158: "snowy_taiga",
+	160: "old_growth_spruce_taiga", 161: "old_growth_spruce_taiga",
+	162: "windswept_gravelly_hills", 163: "windswept_savanna", 164: "windswept_savanna",
+	165: "eroded_badlands", 166: "wooded_badlands", 167: "badlands", 168: "bamboo_jungle",
+	169: "bamboo_jungle", 170: "soul_sand_valley", 171: "crimson_forest",
+	172: "warped_forest", 173: "basalt_deltas", 174: "dripstone_caves", 175: "lush_caves",
+}
+
+var biomeIDs = func() map[string]uint8 {
+	m := map[string]uint8{}
+	for i, b := range Biomes {
+		m[b.Name] = uint8(i)
+	}
+	return m
+}()
+
+// BiomeByName gives the id of a biome from a 1.18+ palette, like "minecraft:swamp".
+// Unknown (e.g. modded) biomes are treated as plains.
+func BiomeByName(name string) uint8 {
+	id, _ := LookupBiome(name)
+	return id
+}
+
+// LookupBiome is like BiomeByName, but reports whether the biome is known.
+func LookupBiome(name string) (uint8, bool) {
+	id, ok := biomeIDs[strings.TrimPrefix(name, "minecraft:")]
+	return id, ok
+}
+
+// BiomeByLegacyID gives the id of a biome from the numeric ids used before 1.18.
+func BiomeByLegacyID(id int) uint8 {
+	return biomeIDs[legacyBiomeNames[id]]
+}
+
+// BiomeColors are the final tints for a biome, as 0xRRGGBB.
+type BiomeColors struct {
+	Name    string `json:"name"`
+	Grass   uint32 `json:"grass"`
+	Foliage uint32 `json:"foliage"`
+	Water   uint32 `json:"water"`
+}
+
+// sampleColormap looks up a color the same way as the game's GrassColor/FoliageColor.
+func sampleColormap(colormap image.Image, temperature, downfall float64, fallback uint32) uint32 {
+	if colormap == nil {
+		return fallback
+	}
+	temperature = math.Min(math.Max(temperature, 0), 1)
+	downfall = math.Min(math.Max(downfall, 0), 1) * temperature
+	rect := colormap.Bounds()
+	x := int((1 - temperature) * float64(rect.Dx()-1))
+	y := int((1 - downfall) * float64(rect.Dy()-1))
+	r, g, b, _ := colormap.At(rect.Min.X+x, rect.Min.Y+y).RGBA()
+	return (r>>8)<<16 | (g>>8)<<8 | b>>8
+}
+
+func computeBiomeColors(grassMap, foliageMap image.Image) []BiomeColors {
+	ret := make([]BiomeColors, len(Biomes))
+	for i, b := range Biomes {
+		c := BiomeColors{Name: b.Name, Grass: b.Grass, Foliage: b.Foliage, Water: b.Water}
+		if c.Grass == 0 {
+			c.Grass = sampleColormap(grassMap, b.Temperature, b.Downfall, defaultGrassColor)
+		}
+		switch b.modifier {
+		case grassDarkForest:
+			c.Grass = ((c.Grass & 0xfefefe) + 0x28340a) >> 1
+		case grassSwamp:
+			// the game alternates between this and 0x4c763c with noise
+			c.Grass = 0x6a7039
+		}
+		if c.Foliage == 0 {
+			c.Foliage = sampleColormap(foliageMap, b.Temperature, b.Downfall, defaultFoliageColor)
+		}
+		if c.Water == 0 {
+			c.Water = defaultWaterColor
+		}
+		ret[i] = c
+	}
+	return ret
+}
+
+// TintKind says which biome color a tinted texture uses.
+type TintKind uint8
+
+const (
+	TintGrass TintKind = iota
+	TintFoliage
+	TintWater
+	// fixed colors that don't depend on the biome
+	TintBirch
+	TintSpruce
+	TintLilyPad
+	TintRedstone
+)
+
+func tintKindFor(name string) TintKind {
+	switch name {
+	case "water", "bubble_column":
+		return TintWater
+	case "birch_leaves":
+		return TintBirch
+	case "spruce_leaves":
+		return TintSpruce
+	case "lily_pad":
+		return TintLilyPad
+	case "redstone_wire":
+		return TintRedstone
+	case "vine":
+		return TintFoliage
+	}
+	if strings.HasSuffix(name, "_leaves") {
+		return TintFoliage
+	}
+	return TintGrass
+}
@@ -0,0 +1,306 @@
+package render
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"math"
+
+	rp "github.com/rmmh/cubeographer/go/resourcepack"
+)
+
+// The MODEL layer draws each element of a block model as a box. Its
+// templates have a pair of words for each texture of each element:
+//
+//	0: 8b texture, the rest filled in with the position
+//	1: 1b tint, 1b texture bit 8, 16b element (bits 12-27),
+//	   6b faces always drawn (bits 6-11), 6b faces culled by neighbors
+//
+// scanRegion replaces bits 6-11 with the brightest light of the block's faces.
+// The elements' boxes, UVs and rotations are kept in the element table.
+
+// ElementTableWidth is the width in texels of the element table image.
+const ElementTableWidth = 512
+
+// elementTexels is how many RGB texels each element takes in the table:
+//
+//	0: from, 1: to, 2: rotation origin, as (v+16)*4
+//	3: rotation axis<<4 | rescale<<3 | angle/22.5+2, then 2b UV rotation for each face
+//	4+2*face: u0, v0    5+2*face: u1, v1, as v*255/16
+//
+// with faces ordered W E S N U D.
+const elementTexels = 16
+
+// maxElements is how many distinct elements the templates can refer to.
+const maxElements = 1 << 16
+
+// elementFaces are the faces of an element, in the order of the visibility bits.
+var elementFaces = [6]string{"west", "east", "south", "north", "up", "down"}
+
+type elementData [elementTexels * 3]byte
+
+func coordByte(v float64) byte {
+	return byte(math.Min(math.Max(math.Round((v+16)*4), 0), 255))
+}
+
+func uvByte(v float64) byte {
+	return byte(math.Min(math.Max(math.Round(v*255/16), 0), 255))
+}
+
+func (e *elementData) setCoord(texel int, v [3]float64) {
+	for i := range v {
+		e[texel*3+i] = coordByte(v[i])
+	}
+}
+
+// defaultUV gives the UVs the game uses for a face without any,
+// which show the part of the texture matching where the face is.
+func defaultUV(face string, from, to []float64) []float64 {
+	switch face {
+	case "down":
+		return []float64{from[0], 16 - to[2], to[0], 16 - from[2]}
+	case "up":
+		return []float64{from[0], from[2], to[0], to[2]}
+	case "north":
+		return []float64{16 - to[0], 16 - to[1], 16 - from[0], 16 - from[1]}
+	case "south":
+		return []float64{from[0], 16 - to[1], to[0], 16 - from[1]}
+	case "west":
+		return []float64{from[2], 16 - to[1], to[2], 16 - from[1]}
+	case "east":
+		return []float64{16 - to[2], 16 - to[1], 16 - from[2], 16 - from[1]}
+	}
+	return nil
+}
+
+// rotate90 turns a vector a quarter turn about the X or Y axis, the same way
+// as a blockstate's x or y rotation.
+func rotate90(v [3]float64, axis int) [3]float64 {
+	if axis == 0 {
+		return [3]float64{v[0], -v[2], v[1]}
+	}
+	return [3]float64{-v[2], v[1], v[0]}
+}
+
+func point(s []float64, def float64) [3]float64 {
+	if len(s) != 3 {
+		return [3]float64{def, def, def}
+	}
+	return [3]float64{s[0], s[1], s[2]}
+}
+
+// rotateElement bakes a blockstate's rotation into a copy of an element.
+// Faces move along with the box, and without uvlock the up and down faces
+// turn with a y rotation. Other faces keep their UVs, which is close enough
+// for the blocks that rotate that way.
+func rotateElement(el *rp.ModelElement, ms *rp.ModelSpec) *rp.ModelElement {
+	var e rp.ModelElement
+	buf, _ := json.Marshal(el)
+	json.Unmarshal(buf, &e)
+
+	// fill in the UVs before the faces move
+	faces := map[string]rp.BlockModelFace{}
+	for name, face := range e.Faces {
+		if face.UV == nil {
+			face.UV = defaultUV(name, e.From, e.To)
+		}
+		faces[name] = face
+	}
+	e.Faces = faces
+
+	steps := [2]int{}
+	if ms.X != nil {
+		steps[0] = (*ms.X / 90) & 3
+	}
+	if ms.Y != nil {
+		steps[1] = (*ms.Y / 90) & 3
+	}
+	uvlock := ms.UVLock != nil && *ms.UVLock
+	from, to := point(e.From, 0), point(e.To, 16)
+	origin, axis := point(e.Rotation.Origin, 8), [3]float64{}
+	switch e.Rotation.Axis {
+	case "x":
+		axis[0] = 1
+	case "y":
+		axis[1] = 1
+	case "z":
+		axis[2] = 1
+	}
+	center := [3]float64{8, 8, 8}
+	around := func(v [3]float64, ax int) [3]float64 {
+		for i := range v {
+			v[i] -= center[i]
+		}
+		v = rotate90(v, ax)
+		for i := range v {
+			v[i] += center[i]
+		}
+		return v
+	}
+	for ax, n := range steps {
+		for range n {
+			from, to = around(from, ax), around(to, ax)
+			origin = around(origin, ax)
+			axis = rotate90(axis, ax)
+			f := e.Faces
+			if ax == 0 {
+				f["north"], f["down"], f["south"], f["up"] = f["down"], f["south"], f["up"], f["north"]
+			} else {
+				f["north"], f["east"], f["south"], f["west"] = f["west"], f["north"], f["east"], f["south"]
+				if !uvlock {
+					for name, turn := range map[string]int{"up": 90, "down": 270} {
+						if face, ok := f[name]; ok {
+							r := turn
+							if face.Rotation != nil {
+								r += *face.Rotation
+							}
+							r %= 360
+							face.Rotation = &r
+							f[name] = face
+						}
+					}
+				}
+			}
+		}
+	}
+	for i := range from {
+		from[i], to[i] = math.Min(from[i], to[i]), math.Max(from[i], to[i])
+	}
+	e.From, e.To = from[:], to[:]
+	e.Rotation.Origin = origin[:]
+	e.Rotation.Axis = ""
+	for i, name := range []string{"x", "y", "z"} {
+		if axis[i] != 0 {
+			e.Rotation.Axis = name
+			if axis[i] < 0 {
+				e.Rotation.Angle = -e.Rotation.Angle
+			}
+		}
+	}
+	return &e
+}
+
+// encodeElement packs an element, with its blockstate rotation baked in, into the element table's form.
+func encodeElement(e *rp.ModelElement) elementData {
+	var d elementData
+	from, to := point(e.From, 0), point(e.To, 16)
+	d.setCoord(0, from)
+	d.setCoord(1, to)
+	d.setCoord(2, point(e.Rotation.Origin, 8))
+	if e.Rotation.Angle != 0 {
+		axis := map[string]byte{"x": 1, "y": 2, "z": 3}[e.Rotation.Axis]
+		angle := byte(math.Min(math.Max(math.Round(e.Rotation.Angle/22.5), -2), 2) + 2)
+		d[9] = axis<<4 | angle
+		if e.Rotation.Rescale != nil && *e.Rotation.Rescale {
+			d[9] |= 1 << 3
+		}
+	}
+	turns := uint16(0)
+	for i, name := range elementFaces {
+		face := e.Faces[name]
+		if face.Texture == "" {
+			continue
+		}
+		if face.Rotation != nil {
+			turns |= uint16(*face.Rotation/90&3) << (2 * i)
+		}
+		uv := face.UV
+		if len(uv) != 4 {
+			uv = defaultUV(name, from[:], to[:])
+		}
+		o := (4 + 2*i) * 3
+		d[o], d[o+1] = uvByte(uv[0]), uvByte(uv[1])
+		d[o+3], d[o+4] = uvByte(uv[2]), uvByte(uv[3])
+	}
+	d[10], d[11] = byte(turns), byte(turns>>8)
+	return d
+}
+
+// elementID gives the element's index in the table, adding it if it's new.
+// Blocks with the same shape share elements.
+func (s *StateConverter) elementID(d elementData) (uint32, bool) {
+	if id, ok := s.elementIDs[d]; ok {
+		return id, true
+	}
+	if len(s.elements) >= maxElements {
+		return 0, false
+	}
+	if s.elementIDs == nil {
+		s.elementIDs = map[elementData]uint32{}
+	}
+	id := uint32(len(s.elements))
+	s.elementIDs[d] = id
+	s.elements = append(s.elements, d)
+	return id, true
+}
+
+// resolveTexture follows a face's #references to a texture name.
+func resolveTexture(m *rp.Model, tex string) string {
+	for i := 0; i < 8 && len(tex) > 0 && tex[0] == '#'; i++ {
+		tex = m.Textures[tex[1:]]
+	}
+	if len(tex) == 0 || tex[0] == '#' {
+		return ""
+	}
+	return tex
+}
+
+// renderElements draws a model as its elements, with one instance for each
+// texture of each element.
+func (s *StateConverter) renderElements(model *rp.Model, ms *rp.ModelSpec) *ModelEntry {
+	if len(model.Elements) == 0 {
+		return nil
+	}
+	m := &ModelEntry{Layer: LayerModel}
+	for _, el := range model.Elements {
+		e := rotateElement(el, ms)
+		id, ok := s.elementID(encodeElement(e))
+		if !ok {
+			return nil
+		}
+		first := len(m.Textures)
+		for i, name := range elementFaces {
+			face := e.Faces[name]
+			tex := resolveTexture(model, face.Texture)
+			if tex == "" {
+				continue
+			}
+			flags := id << 12
+			if face.TintIndex != nil {
+				flags |= 1 << 31
+			}
+			j := first
+			for j < len(m.Textures) && (m.Textures[j] != tex || m.Template[2*j+1]&^0xfff != flags) {
+				j++
+			}
+			if j == len(m.Textures) {
+				m.Textures = append(m.Textures, tex)
+				m.Template = append(m.Template, 0, flags)
+			}
+			if face.CullFace != "" {
+				m.Template[2*j+1] |= 1 << i
+			} else {
+				m.Template[2*j+1] |= 1 << (6 + i)
+			}
+		}
+	}
+	if len(m.Textures) == 0 {
+		return nil
+	}
+	return m
+}
+
+// elementTable draws the element table, with elementTexels texels
+// for each element in rows ElementTableWidth texels wide.
+func elementTable(elements []elementData) *image.NRGBA {
+	perRow := ElementTableWidth / elementTexels
+	rows := max(1, (len(elements)+perRow-1)/perRow)
+	img := image.NewNRGBA(image.Rect(0, 0, ElementTableWidth, rows))
+	for i, d := range elements {
+		for t := range elementTexels {
+			x := i%perRow*elementTexels + t
+			img.SetNRGBA(x, i/perRow, color.NRGBA{d[t*3], d[t*3+1], d[t*3+2], 255})
+		}
+	}
+	return img
+}
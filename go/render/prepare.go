@@ -8,6 +8,7 @@ import (
 	"image/draw"
 	"reflect"
 	"sort"
+	"strconv"
 
 	rp "github.com/rmmh/cubeographer/go/resourcepack"
 	"github.com/samber/lo"
@@ -28,6 +29,7 @@ var LayerNames = []string{
 	"CROSS",
 	"CROP",
 	"CUBE_FALLBACK",
+	"MODEL",
 }
 
 type LayerNumber int
@@ -38,6 +40,7 @@ const (
 	LayerCross
 	LayerCrop
 	LayerCubeFallback
+	LayerModel
 	NumRenderLayers
 )
 
@@ -56,9 +59,10 @@ type BlockEntry struct {
 }
 
 type BlockEntryMetadata struct {
-	Blocks       []BlockEntry `json:"blocks"`
-	Version      string       `json:"version"`
-	WorldVersion int          `json:"world_version"`
+	Blocks       []BlockEntry  `json:"blocks"`
+	Biomes       []BiomeColors `json:"biomes"`
+	Version      string        `json:"version"`
+	WorldVersion int           `json:"world_version"`
 }
 
 func getCubeFaces(m *rp.Model, faces [6]rp.BlockModelFace) ([]string, bool) {
@@ -148,6 +152,10 @@ type StateConverter struct {
 	Models     map[string]*rp.Model
 	ColumnTops map[string]string
 	Debug      string
+
+	// the element table of the MODEL layer
+	elements   []elementData
+	elementIDs map[elementData]uint32
 }
 
 func (s *StateConverter) referencedTexturesModel(model *rp.Model) ([]string, bool) {
@@ -182,51 +190,6 @@ func (s *StateConverter) referencedTexturesModel(model *rp.Model) ([]string, boo
 	return out, tinted
 }
 
-func (s *StateConverter) referencedTextures(st *rp.BlockState) ([]string, bool) {
-	out := []string{}
-	tinted := false
-
-	// fallback: draw ANY texture from ANY sub-model as a cube
-	for _, vs := range st.Variants {
-		for _, v := range vs {
-			model := s.Models[rp.RemoveDefaultPrefix(v.Model)]
-			if model.Textures != nil {
-				for _, tex := range model.Textures {
-					out = append(out, tex)
-				}
-			}
-			for _, el := range model.Elements {
-				for _, face := range el.Faces {
-					if face.TintIndex != nil {
-						tinted = true
-					}
-				}
-			}
-			parent := s.Models[rp.RemoveDefaultPrefix(model.Parent)]
-			if parent != nil {
-				for _, el := range parent.Elements {
-					for _, face := range el.Faces {
-						if face.TintIndex != nil {
-							tinted = true
-						}
-					}
-				}
-			}
-		}
-	}
-	for _, m := range st.Multipart {
-		for _, v := range m.Apply {
-			model := s.Models[rp.RemoveDefaultPrefix(v.Model)]
-			if model.Textures != nil {
-				for _, tex := range model.Textures {
-					out = append(out, tex)
-				}
-			}
-		}
-	}
-	return out, tinted
-}
-
 func (s *StateConverter) applyRotations(ms *rp.ModelSpec, model *rp.Model) *rp.Model {
 	// clone model
 	var m rp.Model
@@ -235,7 +198,16 @@ func (s *StateConverter) applyRotations(ms *rp.ModelSpec, model *rp.Model) *rp.M
 
 	// -Z north, -X west
 
-	for ms.X != nil && *ms.X != 0 && *ms.X%90 == 0 {
+	// count down copies, so the pack's variants are left as they were
+	x, y := 0, 0
+	if ms.X != nil {
+		x = *ms.X
+	}
+	if ms.Y != nil {
+		y = *ms.Y
+	}
+
+	for x != 0 && x%90 == 0 {
 		for i := range m.Elements {
 			e := m.Elements[i]
 			/*
@@ -244,10 +216,10 @@ func (s *StateConverter) applyRotations(ms *rp.ModelSpec, model *rp.Model) *rp.M
 			e.Faces["north"], e.Faces["down"], e.Faces["south"], e.Faces["up"] =
 				e.Faces["down"], e.Faces["south"], e.Faces["up"], e.Faces["north"]
 		}
-		*ms.X -= 90
+		x -= 90
 	}
 
-	for ms.Y != nil && *ms.Y != 0 && *ms.Y%90 == 0 {
+	for y != 0 && y%90 == 0 {
 		for i := range m.Elements {
 			e := m.Elements[i]
 			/*
@@ -256,7 +228,7 @@ func (s *StateConverter) applyRotations(ms *rp.ModelSpec, model *rp.Model) *rp.M
 			e.Faces["north"], e.Faces["east"], e.Faces["south"], e.Faces["west"] =
 				e.Faces["west"], e.Faces["north"], e.Faces["east"], e.Faces["south"]
 		}
-		*ms.Y -= 90
+		y -= 90
 	}
 
 	// fmt.Printf("ROT\n>> %#v\n>> %#v\n", model.Elements[0].Faces, m.Elements[0].Faces)
@@ -288,15 +260,21 @@ func (s *StateConverter) resolveInheritance(model *rp.Model) {
 	}
 }
 
-func (s *StateConverter) renderModelSpec(name string, ms *rp.ModelSpec) ModelEntry {
+// model finds the model a ModelSpec refers to, with its parents resolved.
+func (s *StateConverter) model(ms *rp.ModelSpec) *rp.Model {
 	modelName := rp.RemoveDefaultPrefix(ms.Model)
-	// TODO: check for modelspec X/Y rotations etc
 	model := s.Models[modelName]
 	if model == nil {
 		fmt.Println(lo.Keys(s.Models))
 		panic(fmt.Sprintf("unable to find model for %s", modelName))
 	}
 	s.resolveInheritance(model)
+	return model
+}
+
+func (s *StateConverter) renderModelSpec(name string, ms *rp.ModelSpec) ModelEntry {
+	model := s.model(ms)
+	unrotated := model
 
 	rotated := false
 
@@ -359,6 +337,13 @@ func (s *StateConverter) renderModelSpec(name string, ms *rp.ModelSpec) ModelEnt
 			Template: []uint32{0, 0b1111111}}
 	}
 
+	if elSpec := s.renderElements(unrotated, ms); elSpec != nil {
+		if s.Debug == "all" || s.Debug == name {
+			fmt.Printf("MODEL %#v\n", elSpec)
+		}
+		return *elSpec
+	}
+
 	if s.Debug == "all" || s.Debug == name {
 		modelJ, _ := json.MarshalIndent(model, "", "  ")
 		fmt.Printf("FALLBACK %#v %s\n", ms, string(modelJ))
@@ -381,6 +366,25 @@ func (s *StateConverter) renderModelSpec(name string, ms *rp.ModelSpec) ModelEnt
 	return ModelEntry{Layer: -1}
 }
 
+// renderParts composes the models a multipart blockstate applies in one state.
+// More than one model is drawn as all of their elements together.
+func (s *StateConverter) renderParts(name string, specs []*rp.ModelSpec) ModelEntry {
+	if len(specs) == 1 {
+		return s.renderModelSpec(name, specs[0])
+	}
+	m := ModelEntry{Layer: LayerModel}
+	for _, ms := range specs {
+		if part := s.renderElements(s.model(ms), ms); part != nil {
+			m.Textures = append(m.Textures, part.Textures...)
+			m.Template = append(m.Template, part.Template...)
+		}
+	}
+	if s.Debug == "all" || s.Debug == name {
+		fmt.Printf("MULTIPART %#v\n", m)
+	}
+	return m
+}
+
 func (s *StateConverter) Render(name string, st *rp.BlockState) BlockEntry {
 	slist := buildStateList(st)
 	smap := BuildStateMap(slist)
@@ -392,35 +396,53 @@ func (s *StateConverter) Render(name string, st *rp.BlockState) BlockEntry {
 	}
 	if len(st.Variants) > 0 {
 		tmpls := make([]ModelEntry, smap.Max()+1)
-		for props, models := range st.Variants {
-			tmpls[int(smap.Get(props))] = s.renderModelSpec(name, &models[0])
+		// variants like "normal" can share a state, so go in a fixed order
+		variants := lo.Keys(st.Variants)
+		sort.Strings(variants)
+		for _, props := range variants {
+			tmpls[int(smap.Get(props))] = s.renderModelSpec(name, &st.Variants[props][0])
 		}
 		return BlockEntry{Name: name, States: slist, Templates: tmpls}
 	}
 
-	// fallback: draw ANY texture from ANY sub-model as a cube
-	textures, tinted := s.referencedTextures(st)
-	sort.Strings(textures)
-	for _, tex := range textures {
-		if tex[0] == '#' {
-			continue
-		}
-		tint := uint32(0b111111)
-		if tinted {
-			tint |= 1 << 31
-		}
-		if s.Debug == "all" || s.Debug == name {
-			modelJ, _ := json.MarshalIndent(st, "", "  ")
-			fmt.Printf("FALLBACKMULTI %#v %s\n", name, string(modelJ))
+	if len(st.Multipart) > 0 {
+		tmpls := make([]ModelEntry, smap.Max()+1)
+		// most states apply the same parts as many others, so each set of
+		// parts is rendered once, and its states share the template (adding
+		// texture ids below ORs the same bits in again, so that's harmless)
+		rendered := map[string]ModelEntry{}
+		for state := range tmpls {
+			props, ok := stateProps(slist, Stateval(state))
+			if !ok {
+				continue
+			}
+			var specs []*rp.ModelSpec
+			var parts []byte
+			for i, part := range st.Multipart {
+				if len(part.Apply) > 0 && (part.When == nil || part.When.Matches(props)) {
+					specs = append(specs, &part.Apply[0])
+					parts = strconv.AppendInt(append(parts, ','), int64(i), 10)
+				}
+			}
+			m, ok := rendered[string(parts)]
+			if !ok {
+				m = s.renderParts(name, specs)
+				rendered[string(parts)] = m
+			}
+			tmpls[state] = m
 		}
-		return BlockEntry{Name: name, States: slist, Templates: []ModelEntry{
-			{Layer: LayerCubeFallback, Textures: []string{tex}, Template: []uint32{0, tint}}}}
+		return BlockEntry{Name: name, States: slist, Templates: tmpls}
 	}
 
 	return BlockEntry{}
 }
 
-func Prepare(pack *rp.ResourceJar, genDebug string) (BlockEntryMetadata, []*image.RGBA) {
+// Prepare builds the block templates and a texture atlas for each layer.
+// It also returns a map for each layer giving the TintKind of each tinted texture,
+// as a 32x16 grayscale image indexed like the atlas, and the MODEL layer's element table.
+func Prepare(pack *rp.ResourceJar, genDebug string) (BlockEntryMetadata, []*image.RGBA, []*image.Gray, *image.NRGBA) {
+	pack.Textures[unknownTextureName] = unknownTexture()
+
 	// Classify textures as opaque, transparent (cutout), translucent
 	// This is used to infer solidity-- a cube with all opaque sides
 	// is a definite occluder.
@@ -445,7 +467,9 @@ func Prepare(pack *rp.ResourceJar, genDebug string) (BlockEntryMetadata, []*imag
 	meta := BlockEntryMetadata{
 		Blocks: []BlockEntry{
 			{Name: "air"}, {Name: "cave_air"}, {Name: "void_air"},
+			unknownBlockEntry(),
 		},
+		Biomes:       computeBiomeColors(pack.Textures["colormap/grass"], pack.Textures["colormap/foliage"]),
 		Version:      pack.Version,
 		WorldVersion: pack.WorldVersion,
 	}
@@ -459,7 +483,12 @@ func Prepare(pack *rp.ResourceJar, genDebug string) (BlockEntryMetadata, []*imag
 		}),
 	}
 
-	for name, st := range pack.BlockStates {
+	// models are resolved in place as they're rendered, so render
+	// in a fixed order to get the same output from the same jar
+	names := lo.Keys(pack.BlockStates)
+	sort.Strings(names)
+	for _, name := range names {
+		st := pack.BlockStates[name]
 		entry := converter.Render(name, st)
 		if len(entry.Templates) > 0 {
 			*blockEntries = append(*blockEntries, entry)
@@ -477,6 +506,7 @@ func Prepare(pack *rp.ResourceJar, genDebug string) (BlockEntryMetadata, []*imag
 	}
 
 	atlases := []*image.RGBA{}
+	tints := []*image.Gray{}
 	texIDs := []map[string]int{}
 
 	for i := 0; i < int(NumRenderLayers); i++ {
@@ -494,6 +524,7 @@ func Prepare(pack *rp.ResourceJar, genDebug string) (BlockEntryMetadata, []*imag
 		}
 
 		atlases = append(atlases, atlas)
+		tints = append(tints, image.NewGray(image.Rect(0, 0, 32, 16)))
 		texIDs = append(texIDs, map[string]int{"air": 0})
 	}
 
@@ -513,6 +544,10 @@ func Prepare(pack *rp.ResourceJar, genDebug string) (BlockEntryMetadata, []*imag
 		} else if nameToOldID[b] > 0 {
 			return false
 		}
+		// the placeholder for unknown blocks must always get an atlas slot
+		if a == UnknownBlockName || b == UnknownBlockName {
+			return a == UnknownBlockName
+		}
 		diff := (pack.StringCounts[a] + pack.StringCounts["minecraft:"+a]) - (pack.StringCounts[b] + pack.StringCounts["minecraft:"+b])
 		if diff != 0 {
 			return diff > 0
@@ -522,6 +557,8 @@ func Prepare(pack *rp.ResourceJar, genDebug string) (BlockEntryMetadata, []*imag
 
 	for i := range *blockEntries {
 		ent := &(*blockEntries)[i]
+		shortName := rp.RemoveDefaultPrefix(ent.Name)
+		tinted := false
 		splatTexture := func(layer LayerNumber, name string, place int) {
 			if place == 0 {
 				place = texIDs[layer][name]
@@ -534,12 +571,18 @@ func Prepare(pack *rp.ResourceJar, genDebug string) (BlockEntryMetadata, []*imag
 			x0 := (place * 16) % 512
 			y0 := (place / 32) * 16
 			draw.Draw(atlases[layer], image.Rect(x0, y0, x0+16, y0+16), tex, image.Point{}, draw.Src)
+			if tinted {
+				tints[layer].SetGray(place%32, place/32, color.Gray{uint8(tintKindFor(shortName))})
+			}
 		}
 
-		if tr, ok := pack.Translations["block.minecraft."+ent.Name]; ok {
+		if tr, ok := pack.Translations["block.minecraft."+shortName]; ok {
 			ent.DisplayName = tr
 		}
 
+		// a block is only solid if all its states are, so that
+		// bottom slabs don't hide the faces of the blocks above them
+		partial := false
 		for _, model := range ent.Templates {
 			if model.Textures == nil {
 				continue
@@ -549,6 +592,12 @@ func Prepare(pack *rp.ResourceJar, genDebug string) (BlockEntryMetadata, []*imag
 			}
 
 			layer := model.Layer
+			tinted = shortName == "water"
+			for i := 1; i < len(model.Template); i += 2 {
+				if model.Template[i]&(1<<31) != 0 {
+					tinted = true
+				}
+			}
 			if len(model.Textures)+len(texIDs[layer]) >= 512 {
 				fmt.Println("warn: overrun for", ent.Name)
 				break
@@ -579,17 +628,17 @@ func Prepare(pack *rp.ResourceJar, genDebug string) (BlockEntryMetadata, []*imag
 			if tid >= 512 {
 				panic(fmt.Sprintf("texID too large! %#v: %v", ent, tid))
 			}
-			if layer == LayerCube || layer == LayerVoxel || layer == LayerCubeFallback {
-				solid := true
-				for _, tex := range model.Textures {
-					if textureClasses[tex] != TexOpaque {
-						solid = false
-					}
-				}
-				if solid {
-					ent.Solid = true
+			solid := layer == LayerCube || layer == LayerVoxel || layer == LayerCubeFallback
+			for _, tex := range model.Textures {
+				if textureClasses[tex] != TexOpaque {
+					solid = false
 				}
 			}
+			if solid {
+				ent.Solid = true
+			} else {
+				partial = true
+			}
 			model.Template[0] |= uint32(tid) << 24
 			model.Template[1] |= uint32(tid>>8) << 30
 			if ent.Name == "minecraft:grass_block" && len(model.Template) == 4 {
@@ -597,14 +646,14 @@ func Prepare(pack *rp.ResourceJar, genDebug string) (BlockEntryMetadata, []*imag
 				// * the dirt sides and bottom (no top)
 				// * & len(model.Template) == 4 {the tinted grass top and side overlay (no bottom)
 				model.Template[2] |= uint32(texIDs[layer][model.Textures[2]]) << 24
-			} else if layer == LayerVoxel && len(model.Textures) > 1 {
+			} else if (layer == LayerVoxel || layer == LayerModel) && len(model.Textures) > 1 {
 				for i, t := range model.Textures {
 					tid := texIDs[layer][t]
 					model.Template[2*i] |= uint32(tid) << 24
 					model.Template[2*i+1] |= uint32(tid>>8) << 30
 				}
 			}
-			if ent.Name == "water" {
+			if shortName == "water" {
 				model.Template[1] |= 1 << 31
 			}
 			if genDebug == "all" || genDebug == ent.Name {
@@ -613,6 +662,9 @@ func Prepare(pack *rp.ResourceJar, genDebug string) (BlockEntryMetadata, []*imag
 					model.Template[0], model.Template[1])
 			}
 		}
+		if partial {
+			ent.Solid = false
+		}
 	}
-	return meta, atlases
+	return meta, atlases, tints, elementTable(converter.elements)
 }
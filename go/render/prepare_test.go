@@ -0,0 +1,449 @@
+package render_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/rmmh/cubeographer/go/region"
+	"github.com/rmmh/cubeographer/go/render"
+	rp "github.com/rmmh/cubeographer/go/resourcepack"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/require"
+)
+
+// testPackJSON is a miniature client jar: just enough blockstates and
+// models to reach each way Prepare draws blocks.
+var testPackJSON = map[string]string{
+	"version.json": `{"name": "test", "world_version": 4556}`,
+
+	"assets/minecraft/lang/en_us.json": `{"block.minecraft.stone": "Stone", "block.minecraft.poppy": "Poppy"}`,
+
+	"assets/minecraft/blockstates/stone.json":      `{"variants": {"": {"model": "minecraft:block/stone"}}}`,
+	"assets/minecraft/blockstates/glass.json":      `{"variants": {"": {"model": "minecraft:block/glass"}}}`,
+	"assets/minecraft/blockstates/oak_leaves.json": `{"variants": {"": {"model": "minecraft:block/oak_leaves"}}}`,
+	"assets/minecraft/blockstates/oak_log.json": `{"variants": {
+		"axis=x": {"model": "minecraft:block/oak_log", "x": 90, "y": 90},
+		"axis=y": {"model": "minecraft:block/oak_log"},
+		"axis=z": {"model": "minecraft:block/oak_log", "x": 90}}}`,
+	"assets/minecraft/blockstates/poppy.json": `{"variants": {"": {"model": "minecraft:block/poppy"}}}`,
+	"assets/minecraft/blockstates/wheat.json": `{"variants": {
+		"age=0": {"model": "minecraft:block/wheat_stage0"},
+		"age=1": {"model": "minecraft:block/wheat_stage1"}}}`,
+	"assets/minecraft/blockstates/oak_slab.json": `{"variants": {
+		"type=bottom": {"model": "minecraft:block/oak_slab"},
+		"type=double": {"model": "minecraft:block/oak_planks"},
+		"type=top": {"model": "minecraft:block/oak_slab_top"}}}`,
+	"assets/minecraft/blockstates/oak_stairs.json": `{"variants": {
+		"facing=east": {"model": "minecraft:block/oak_stairs"},
+		"facing=north": {"model": "minecraft:block/oak_stairs", "y": 270, "uvlock": true}}}`,
+	"assets/minecraft/blockstates/redstone_wire.json": `{"multipart": [
+		{"when": {"OR": [{"north": "none", "east": "none"}, {"north": "side|up", "east": "side|up"}]},
+			"apply": {"model": "minecraft:block/redstone_dust_dot"}},
+		{"when": {"north": "side|up"}, "apply": {"model": "minecraft:block/redstone_dust_side"}},
+		{"when": {"east": "side|up"}, "apply": {"model": "minecraft:block/redstone_dust_side", "y": 90}},
+		{"when": {"AND": [{"north": "up"}, {"east": "side|up"}]}, "apply": {"model": "minecraft:block/redstone_dust_up"}}]}`,
+	"assets/minecraft/blockstates/oak_fence.json": `{"multipart": [
+		{"apply": {"model": "minecraft:block/oak_fence_post"}},
+		{"when": {"north": "true"}, "apply": {"model": "minecraft:block/oak_fence_side", "uvlock": true}},
+		{"when": {"east": "true"}, "apply": {"model": "minecraft:block/oak_fence_side", "y": 90, "uvlock": true}}]}`,
+
+	"assets/minecraft/models/block/block.json": `{}`,
+	"assets/minecraft/models/block/cube.json": `{"parent": "block/block", "elements": [{"from": [0, 0, 0], "to": [16, 16, 16], "faces": {
+		"down": {"texture": "#down", "cullface": "down"},
+		"up": {"texture": "#up", "cullface": "up"},
+		"north": {"texture": "#north", "cullface": "north"},
+		"south": {"texture": "#south", "cullface": "south"},
+		"west": {"texture": "#west", "cullface": "west"},
+		"east": {"texture": "#east", "cullface": "east"}}}]}`,
+	"assets/minecraft/models/block/cube_all.json": `{"parent": "block/cube", "textures": {"particle": "#all",
+		"down": "#all", "up": "#all", "north": "#all", "east": "#all", "south": "#all", "west": "#all"}}`,
+	"assets/minecraft/models/block/cube_column.json": `{"parent": "block/cube", "textures": {"particle": "#side",
+		"down": "#end", "up": "#end", "north": "#side", "east": "#side", "south": "#side", "west": "#side"}}`,
+	"assets/minecraft/models/block/leaves.json": `{"parent": "block/block", "elements": [{"from": [0, 0, 0], "to": [16, 16, 16], "faces": {
+		"down": {"uv": [0, 0, 16, 16], "texture": "#all", "tintindex": 0, "cullface": "down"},
+		"up": {"uv": [0, 0, 16, 16], "texture": "#all", "tintindex": 0, "cullface": "up"},
+		"north": {"uv": [0, 0, 16, 16], "texture": "#all", "tintindex": 0, "cullface": "north"},
+		"south": {"uv": [0, 0, 16, 16], "texture": "#all", "tintindex": 0, "cullface": "south"},
+		"west": {"uv": [0, 0, 16, 16], "texture": "#all", "tintindex": 0, "cullface": "west"},
+		"east": {"uv": [0, 0, 16, 16], "texture": "#all", "tintindex": 0, "cullface": "east"}}}]}`,
+	"assets/minecraft/models/block/cross.json": `{"ambientocclusion": false, "textures": {"particle": "#cross"}, "elements": [
+		{"from": [0.8, 0, 8], "to": [15.2, 16, 8], "rotation": {"origin": [8, 8, 8], "axis": "y", "angle": 45}, "shade": false,
+			"faces": {"north": {"texture": "#cross"}, "south": {"texture": "#cross"}}},
+		{"from": [8, 0, 0.8], "to": [8, 16, 15.2], "rotation": {"origin": [8, 8, 8], "axis": "y", "angle": 45}, "shade": false,
+			"faces": {"west": {"texture": "#cross"}, "east": {"texture": "#cross"}}}]}`,
+	"assets/minecraft/models/block/crop.json": `{"ambientocclusion": false, "textures": {"particle": "#crop"}, "elements": [
+		{"from": [4, -1, 0], "to": [4, 15, 16], "shade": false, "faces": {"west": {"texture": "#crop"}, "east": {"texture": "#crop"}}},
+		{"from": [12, -1, 0], "to": [12, 15, 16], "shade": false, "faces": {"west": {"texture": "#crop"}, "east": {"texture": "#crop"}}},
+		{"from": [0, -1, 4], "to": [16, 15, 4], "shade": false, "faces": {"north": {"texture": "#crop"}, "south": {"texture": "#crop"}}},
+		{"from": [0, -1, 12], "to": [16, 15, 12], "shade": false, "faces": {"north": {"texture": "#crop"}, "south": {"texture": "#crop"}}}]}`,
+	"assets/minecraft/models/block/fence_post.json": `{"textures": {"particle": "#texture"}, "elements": [
+		{"from": [6, 0, 6], "to": [10, 16, 10], "faces": {"up": {"texture": "#texture", "cullface": "up"}, "north": {"texture": "#texture"}}}]}`,
+	"assets/minecraft/models/block/fence_side.json": `{"textures": {"particle": "#texture"}, "elements": [
+		{"from": [7, 12, 0], "to": [9, 15, 9], "faces": {"north": {"texture": "#texture", "cullface": "north"}}}]}`,
+	"assets/minecraft/models/block/redstone_dust_dot.json": `{"textures": {"particle": "block/redstone_dust_dot"}, "elements": [
+		{"from": [5, 0.25, 5], "to": [11, 0.25, 11], "faces": {"up": {"texture": "block/redstone_dust_dot", "tintindex": 0}}}]}`,
+	"assets/minecraft/models/block/redstone_dust_side.json": `{"textures": {"line": "block/redstone_dust_line"}, "elements": [
+		{"from": [0, 0.25, 0], "to": [16, 0.25, 8], "faces": {"up": {"texture": "#line", "tintindex": 0}}}]}`,
+	"assets/minecraft/models/block/redstone_dust_up.json": `{"textures": {"line": "block/redstone_dust_line"}, "elements": [
+		{"from": [0, 0, 0.25], "to": [16, 16, 0.25], "faces": {"south": {"texture": "#line", "tintindex": 0}}}]}`,
+
+	"assets/minecraft/models/block/slab.json": `{"parent": "block/block", "textures": {"particle": "#side"}, "elements": [
+		{"from": [0, 0, 0], "to": [16, 8, 16], "faces": {
+			"down": {"uv": [0, 0, 16, 16], "texture": "#bottom", "cullface": "down"},
+			"up": {"uv": [0, 0, 16, 16], "texture": "#top"},
+			"north": {"uv": [0, 8, 16, 16], "texture": "#side", "cullface": "north"},
+			"south": {"uv": [0, 8, 16, 16], "texture": "#side", "cullface": "south"},
+			"west": {"uv": [0, 8, 16, 16], "texture": "#side", "cullface": "west"},
+			"east": {"uv": [0, 8, 16, 16], "texture": "#side", "cullface": "east"}}}]}`,
+	"assets/minecraft/models/block/slab_top.json": `{"parent": "block/block", "textures": {"particle": "#side"}, "elements": [
+		{"from": [0, 8, 0], "to": [16, 16, 16], "faces": {
+			"down": {"uv": [0, 0, 16, 16], "texture": "#bottom"},
+			"up": {"uv": [0, 0, 16, 16], "texture": "#top", "cullface": "up"},
+			"north": {"uv": [0, 0, 16, 8], "texture": "#side", "cullface": "north"},
+			"south": {"uv": [0, 0, 16, 8], "texture": "#side", "cullface": "south"},
+			"west": {"uv": [0, 0, 16, 8], "texture": "#side", "cullface": "west"},
+			"east": {"uv": [0, 0, 16, 8], "texture": "#side", "cullface": "east"}}}]}`,
+	"assets/minecraft/models/block/stairs.json": `{"parent": "block/block", "textures": {"particle": "#side"}, "elements": [
+		{"from": [0, 0, 0], "to": [16, 8, 16], "faces": {
+			"down": {"texture": "#bottom", "cullface": "down"},
+			"up": {"texture": "#top"},
+			"north": {"texture": "#side", "cullface": "north"},
+			"south": {"texture": "#side", "cullface": "south"},
+			"west": {"texture": "#side", "cullface": "west"},
+			"east": {"texture": "#side", "cullface": "east"}}},
+		{"from": [8, 8, 0], "to": [16, 16, 16], "faces": {
+			"up": {"texture": "#top", "cullface": "up"},
+			"north": {"texture": "#side", "cullface": "north"},
+			"south": {"texture": "#side", "cullface": "south"},
+			"west": {"texture": "#side"},
+			"east": {"texture": "#side", "cullface": "east"}}}]}`,
+
+	"assets/minecraft/models/block/stone.json":          `{"parent": "minecraft:block/cube_all", "textures": {"all": "minecraft:block/stone"}}`,
+	"assets/minecraft/models/block/glass.json":          `{"parent": "minecraft:block/cube_all", "textures": {"all": "minecraft:block/glass"}}`,
+	"assets/minecraft/models/block/oak_leaves.json":     `{"parent": "minecraft:block/leaves", "textures": {"all": "minecraft:block/oak_leaves"}}`,
+	"assets/minecraft/models/block/oak_log.json":        `{"parent": "minecraft:block/cube_column", "textures": {"end": "minecraft:block/oak_log_top", "side": "minecraft:block/oak_log"}}`,
+	"assets/minecraft/models/block/poppy.json":          `{"parent": "minecraft:block/cross", "textures": {"cross": "minecraft:block/poppy"}}`,
+	"assets/minecraft/models/block/wheat_stage0.json":   `{"parent": "minecraft:block/crop", "textures": {"crop": "minecraft:block/wheat_stage0"}}`,
+	"assets/minecraft/models/block/wheat_stage1.json":   `{"parent": "minecraft:block/crop", "textures": {"crop": "minecraft:block/wheat_stage1"}}`,
+	"assets/minecraft/models/block/oak_planks.json":     `{"parent": "minecraft:block/cube_all", "textures": {"all": "minecraft:block/oak_planks"}}`,
+	"assets/minecraft/models/block/oak_slab.json":       `{"parent": "minecraft:block/slab", "textures": {"bottom": "minecraft:block/oak_planks", "top": "minecraft:block/oak_planks", "side": "minecraft:block/oak_planks"}}`,
+	"assets/minecraft/models/block/oak_slab_top.json":   `{"parent": "minecraft:block/slab_top", "textures": {"bottom": "minecraft:block/oak_planks", "top": "minecraft:block/oak_planks", "side": "minecraft:block/oak_planks"}}`,
+	"assets/minecraft/models/block/oak_stairs.json":     `{"parent": "minecraft:block/stairs", "textures": {"bottom": "minecraft:block/oak_planks", "top": "minecraft:block/oak_planks", "side": "minecraft:block/oak_log"}}`,
+	"assets/minecraft/models/block/oak_fence_post.json": `{"parent": "minecraft:block/fence_post", "textures": {"texture": "minecraft:block/oak_planks"}}`,
+	"assets/minecraft/models/block/oak_fence_side.json": `{"parent": "minecraft:block/fence_side", "textures": {"texture": "minecraft:block/oak_planks"}}`,
+}
+
+// each texture is filled with its own color, so it can be found in the atlases
+var testPackColors = map[string]color.RGBA{
+	"block/stone":              {0x80, 0x80, 0x80, 0xff},
+	"block/glass":              {0xc0, 0xe0, 0xff, 0xff},
+	"block/oak_leaves":         {0x30, 0x90, 0x20, 0xff},
+	"block/oak_log":            {0x60, 0x40, 0x20, 0xff},
+	"block/oak_log_top":        {0xa0, 0x80, 0x50, 0xff},
+	"block/oak_planks":         {0xa0, 0x82, 0x4e, 0xff},
+	"block/poppy":              {0xe0, 0x20, 0x20, 0xff},
+	"block/wheat_stage0":       {0x10, 0xa0, 0x10, 0xff},
+	"block/wheat_stage1":       {0x20, 0xb0, 0x20, 0xff},
+	"block/redstone_dust_dot":  {0xff, 0xff, 0xff, 0xff},
+	"block/redstone_dust_line": {0xfe, 0xfe, 0xfe, 0xff},
+	"colormap/grass":           {0x11, 0x22, 0x33, 0xff},
+	"colormap/foliage":         {0x44, 0x55, 0x66, 0xff},
+}
+
+// testPackTexture draws a 16x16 texture. Glass, leaves and plants
+// have see-through pixels, so they aren't solid.
+func testPackTexture(name string, c color.RGBA) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+	switch name {
+	case "block/glass", "block/oak_leaves", "block/poppy", "block/wheat_stage0", "block/wheat_stage1",
+		"block/redstone_dust_dot", "block/redstone_dust_line":
+		draw.Draw(img, image.Rect(2, 2, 14, 14), image.Transparent, image.Point{}, draw.Src)
+	}
+	return img
+}
+
+// writeTestPack builds the resource pack as a jar and loads it.
+func writeTestPack(t *testing.T) *rp.ResourceJar {
+	fn := path.Join(t.TempDir(), "client.jar")
+	f, err := os.Create(fn)
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for name, data := range testPackJSON {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	for name, c := range testPackColors {
+		w, err := zw.Create("assets/minecraft/textures/" + name + ".png")
+		require.NoError(t, err)
+		require.NoError(t, png.Encode(w, testPackTexture(name, c)))
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	jar, err := zip.OpenReader(fn)
+	require.NoError(t, err)
+	defer jar.Close()
+	pack, err := rp.JarFromZip(jar)
+	require.NoError(t, err)
+	return pack
+}
+
+// atlasTexture gives where a texture id was drawn in an atlas.
+func atlasTexture(atlas *image.RGBA, tid int) *image.RGBA {
+	x0, y0 := tid*16%512, tid/32*16
+	return atlas.SubImage(image.Rect(x0, y0, x0+16, y0+16)).(*image.RGBA)
+}
+
+func TestPrepareTestPack(t *testing.T) {
+	pack := writeTestPack(t)
+	require.Equal(t, "test", pack.Version)
+	require.Equal(t, 4556, pack.WorldVersion)
+	require.Len(t, pack.BlockStates, 10)
+	require.Len(t, pack.Textures, len(testPackColors))
+
+	meta, atlases, tints, elements := render.Prepare(pack, "")
+	require.Len(t, atlases, int(render.NumRenderLayers))
+	require.Equal(t, render.ElementTableWidth, elements.Rect.Dx())
+	require.Equal(t, 4556, meta.WorldVersion)
+
+	blocks := map[string]render.BlockEntry{}
+	for _, b := range meta.Blocks {
+		blocks[b.Name] = b
+	}
+	require.Len(t, blocks, 14)
+	require.Equal(t, "Stone", blocks["minecraft:stone"].DisplayName)
+	require.Equal(t, "Poppy", blocks["minecraft:poppy"].DisplayName)
+
+	// checkTexture checks that a template draws the right texture
+	checkTexture := func(layer render.LayerNumber, tmpl []uint32, i int, tex string) {
+		t.Helper()
+		tid := int(tmpl[2*i]>>24) | int(tmpl[2*i+1]>>30&1)<<8
+		require.NotZero(t, tid, tex)
+		want := testPackTexture(tex, testPackColors[tex])
+		got := atlasTexture(atlases[layer], tid)
+		for y := range 16 {
+			for x := range 16 {
+				require.Equal(t, want.At(x, y), got.At(got.Rect.Min.X+x, got.Rect.Min.Y+y), "%s at %d,%d", tex, x, y)
+			}
+		}
+	}
+
+	// the placeholder always gets the first slot of its layer
+	unknown := blocks[render.UnknownBlockName]
+	require.True(t, unknown.Solid)
+	require.Equal(t, uint32(1<<24), unknown.Templates[0].Template[0])
+	require.Equal(t, color.RGBA{248, 0, 248, 255}, atlases[render.LayerCubeFallback].At(16, 0))
+
+	// untinted cubes are voxels, one per texture
+	stone := blocks["minecraft:stone"]
+	require.True(t, stone.Solid)
+	require.Len(t, stone.Templates, 1)
+	require.Equal(t, render.LayerVoxel, stone.Templates[0].Layer)
+	require.Equal(t, uint32(0b111111), stone.Templates[0].Template[1])
+	checkTexture(render.LayerVoxel, stone.Templates[0].Template, 0, "block/stone")
+
+	glass := blocks["minecraft:glass"]
+	require.False(t, glass.Solid)
+	require.Equal(t, render.LayerVoxel, glass.Templates[0].Layer)
+	checkTexture(render.LayerVoxel, glass.Templates[0].Template, 0, "block/glass")
+
+	// rotations move the end texture around, faces ordered W E S N U D
+	log := blocks["minecraft:oak_log"]
+	require.True(t, log.Solid)
+	require.Equal(t, [][]string{{"axis", "x", "y", "z"}}, log.States)
+	// templates are indexed by state, so there's room for axis=3
+	require.Len(t, log.Templates, 4)
+	for i, want := range []struct {
+		textures []string
+		faces    []uint32
+	}{
+		{[]string{"block/oak_log_top", "block/oak_log"}, []uint32{0b000011, 0b111100}},
+		{[]string{"block/oak_log", "block/oak_log_top"}, []uint32{0b001111, 0b110000}},
+		{[]string{"block/oak_log", "block/oak_log_top"}, []uint32{0b110011, 0b001100}},
+	} {
+		tmpl := log.Templates[i]
+		require.Equal(t, render.LayerVoxel, tmpl.Layer, i)
+		require.Equal(t, want.textures, tmpl.Textures, i)
+		for j, tex := range want.textures {
+			require.Equal(t, want.faces[j], tmpl.Template[2*j+1]&0b111111, "state %d %s", i, tex)
+			checkTexture(render.LayerVoxel, tmpl.Template, j, tex)
+		}
+	}
+
+	// tinted cubes are cubes, with the tint kind in the layer's tint map
+	leaves := blocks["minecraft:oak_leaves"]
+	require.False(t, leaves.Solid)
+	require.Equal(t, render.LayerCube, leaves.Templates[0].Layer)
+	require.Equal(t, uint32(0b111111|1<<31), leaves.Templates[0].Template[1])
+	checkTexture(render.LayerCube, leaves.Templates[0].Template, 0, "block/oak_leaves")
+	tid := int(leaves.Templates[0].Template[0] >> 24)
+	require.Equal(t, uint8(render.TintFoliage), tints[render.LayerCube].GrayAt(tid%32, tid/32).Y)
+
+	poppy := blocks["minecraft:poppy"]
+	require.False(t, poppy.Solid)
+	require.Equal(t, render.LayerCross, poppy.Templates[0].Layer)
+	checkTexture(render.LayerCross, poppy.Templates[0].Template, 0, "block/poppy")
+
+	wheat := blocks["minecraft:wheat"]
+	require.Len(t, wheat.Templates, 2)
+	for i, tmpl := range wheat.Templates {
+		require.Equal(t, render.LayerCrop, tmpl.Layer)
+		checkTexture(render.LayerCrop, tmpl.Template, 0, []string{"block/wheat_stage0", "block/wheat_stage1"}[i])
+	}
+
+	// other models are drawn as their elements, one instance per texture of each
+	// element, with faces that don't touch a neighbor always drawn
+	element := func(tmpl []uint32, i int) (from, to [3]float64) {
+		id := int(tmpl[2*i+1] >> 12 & 0xffff)
+		for j := range 3 {
+			from[j] = float64(elements.Pix[id*16*4+j])/4 - 16
+			to[j] = float64(elements.Pix[(id*16+1)*4+j])/4 - 16
+		}
+		return from, to
+	}
+	slab := blocks["minecraft:oak_slab"]
+	require.False(t, slab.Solid, "the double slab isn't enough")
+	require.Equal(t, [][]string{{"type", "bottom", "double", "top"}}, slab.States)
+	bottom, top := slab.Templates[0], slab.Templates[2]
+	require.Equal(t, render.LayerModel, bottom.Layer)
+	require.Equal(t, []string{"block/oak_planks"}, bottom.Textures)
+	require.Equal(t, uint32(0b010000<<6|0b101111), bottom.Template[1]&0xfff)
+	checkTexture(render.LayerModel, bottom.Template, 0, "block/oak_planks")
+	from, to := element(bottom.Template, 0)
+	require.Equal(t, [3]float64{0, 0, 0}, from)
+	require.Equal(t, [3]float64{16, 8, 16}, to)
+	require.Equal(t, render.LayerModel, top.Layer)
+	require.Equal(t, uint32(0b100000<<6|0b011111), top.Template[1]&0xfff)
+	from, _ = element(top.Template, 0)
+	require.Equal(t, [3]float64{0, 8, 0}, from)
+	require.Equal(t, render.LayerVoxel, slab.Templates[1].Layer)
+
+	// turned stairs turn their elements, and share the lower one with the slab
+	stairs := blocks["minecraft:oak_stairs"]
+	require.Equal(t, [][]string{{"facing", "east", "north"}}, stairs.States)
+	east, north := stairs.Templates[0], stairs.Templates[1]
+	require.Equal(t, []string{"block/oak_log", "block/oak_planks", "block/oak_log", "block/oak_planks"}, north.Textures)
+	require.Equal(t, bottom.Template[1]>>12, north.Template[1]>>12)
+	require.Equal(t, bottom.Template[1]>>12, east.Template[1]>>12)
+	from, to = element(east.Template, 2)
+	require.Equal(t, [3]float64{8, 8, 0}, from)
+	require.Equal(t, [3]float64{16, 16, 16}, to)
+	from, to = element(north.Template, 2)
+	require.Equal(t, [3]float64{0, 8, 0}, from)
+	require.Equal(t, [3]float64{16, 16, 8}, to)
+	// the riser faced west, and faces south once turned to the north
+	require.Equal(t, uint32(0b000100<<6|0b001011), north.Template[5]&0xfff)
+	require.Equal(t, uint32(0b010000), north.Template[7]&0xfff)
+	checkTexture(render.LayerModel, north.Template, 2, "block/oak_log")
+	checkTexture(render.LayerModel, north.Template, 3, "block/oak_planks")
+
+	// multipart blocks draw every part that applies in each state
+	fence := blocks["minecraft:oak_fence"]
+	require.False(t, fence.Solid)
+	require.Equal(t, [][]string{{"east", "false", "true"}, {"north", "false", "true"}}, fence.States)
+	fenceMap := render.BuildStateMap(fence.States)
+	post := fence.Templates[fenceMap.Get("east=false,north=false")]
+	require.Equal(t, render.LayerModel, post.Layer)
+	require.Len(t, post.Textures, 1)
+	both := fence.Templates[fenceMap.Get("east=true,north=true")]
+	require.Equal(t, render.LayerModel, both.Layer)
+	require.Equal(t, []string{"block/oak_planks", "block/oak_planks", "block/oak_planks"}, both.Textures)
+	require.Equal(t, post.Template[:2], both.Template[:2])
+	from, to = element(both.Template, 1)
+	require.Equal(t, [3]float64{7, 12, 0}, from)
+	require.Equal(t, [3]float64{9, 15, 9}, to)
+	from, to = element(both.Template, 2)
+	require.Equal(t, [3]float64{7, 12, 7}, from)
+	require.Equal(t, [3]float64{16, 15, 9}, to)
+	require.Equal(t, uint32(0b000010), both.Template[5]&0xfff, "the east side turned to face east")
+
+	// "side|up" matches either value, and OR and AND combine clauses
+	wire := blocks["minecraft:redstone_wire"]
+	require.Equal(t, [][]string{{"east", "none", "side", "up"}, {"north", "none", "side", "up"}}, wire.States)
+	wireMap := render.BuildStateMap(wire.States)
+	for props, want := range map[string][]string{
+		"east=none,north=none": {"block/redstone_dust_dot"},
+		"east=none,north=side": {"block/redstone_dust_line"},
+		"east=side,north=up":   {"block/redstone_dust_dot", "block/redstone_dust_line", "block/redstone_dust_line", "block/redstone_dust_line"},
+		"east=none,north=up":   {"block/redstone_dust_line"},
+	} {
+		tmpl := wire.Templates[wireMap.Get(props)]
+		require.Equal(t, want, tmpl.Textures, props)
+		for i := range want {
+			require.NotZero(t, tmpl.Template[2*i+1]&(1<<31), "%s is tinted", props)
+			checkTexture(render.LayerModel, tmpl.Template, i, want[i])
+		}
+	}
+	// states that apply the same parts share a template
+	sideSide := wire.Templates[wireMap.Get("east=side,north=side")]
+	require.Same(t, &sideSide.Template[0], &wire.Templates[wireMap.Get("east=up,north=side")].Template[0])
+	require.NotSame(t, &sideSide.Template[0], &wire.Templates[wireMap.Get("east=side,north=up")].Template[0])
+	tid = int(wire.Templates[0].Template[0] >> 24)
+	require.Equal(t, uint8(render.TintRedstone), tints[render.LayerModel].GrayAt(tid%32, tid/32).Y)
+	// the fourth value of two bits isn't a state
+	require.Empty(t, wire.Templates[wireMap.Get("east=up")+1].Textures)
+
+	// biome colors come from the colormaps
+	plains := meta.Biomes[render.BiomeByName("plains")]
+	require.Equal(t, uint32(0x112233), plains.Grass)
+	require.Equal(t, uint32(0x445566), plains.Foliage)
+
+	// and the result loads like a real blockmeta.json
+	buf, err := json.Marshal(meta)
+	require.NoError(t, err)
+	bm, err := region.LoadBlockMapper(buf)
+	require.NoError(t, err)
+	require.Equal(t, 4556, bm.WorldVersion())
+	for name, solid := range map[string]bool{
+		"minecraft:stone": true, "minecraft:glass": false, "minecraft:oak_log": true,
+		"minecraft:oak_leaves": false, "minecraft:poppy": false, render.UnknownBlockName: true,
+	} {
+		nid, ok := bm.NameToNid[name]
+		require.True(t, ok, name)
+		require.Equal(t, solid, bm.IsSolid(nid), name)
+	}
+	nid := bm.NameToNid["minecraft:oak_log"]
+	require.Equal(t, uint8(render.LayerVoxel), bm.Layer[nid][2])
+	require.Equal(t, log.Templates[2].Template, bm.Tmpl[nid][2])
+	name, display, props := bm.Describe(nid, 2)
+	require.Equal(t, "minecraft:oak_log", name)
+	require.Equal(t, "", display)
+	require.Equal(t, map[string]string{"axis": "z"}, props)
+}
+
+// generated holds blockmeta.json and the atlases as they'd be written.
+func generated(t *testing.T, pack *rp.ResourceJar) [][]byte {
+	meta, atlases, tints, elements := render.Prepare(pack, "")
+	buf, err := json.Marshal(meta)
+	require.NoError(t, err)
+	ret := [][]byte{buf}
+	atlases = append(atlases, (*image.RGBA)(elements))
+	for _, img := range append(lo.Map(atlases, func(a *image.RGBA, _ int) image.Image { return a }),
+		lo.Map(tints, func(g *image.Gray, _ int) image.Image { return g })...) {
+		var out bytes.Buffer
+		require.NoError(t, png.Encode(&out, img))
+		ret = append(ret, out.Bytes())
+	}
+	return ret
+}
+
+func TestPrepareDeterministic(t *testing.T) {
+	pack := writeTestPack(t)
+	want := generated(t, pack)
+	for range 5 {
+		require.Equal(t, want, generated(t, writeTestPack(t)))
+	}
+	// and preparing the same pack again changes nothing
+	require.Equal(t, want, generated(t, pack))
+}
@@ -52,11 +52,11 @@ func buildStateList(st *resourcepack.BlockState) [][]string {
 					default:
 						panic("unhandled when")
 					}
-					if val == "side|up" {
-						val = "side" // literally used once
-					}
-					if stringSliceSearch(attrs[attr], val) == -1 {
-						attrs[attr] = append(attrs[attr], val)
+					// values like "side|up" match any of them
+					for _, val := range strings.Split(val, "|") {
+						if stringSliceSearch(attrs[attr], val) == -1 {
+							attrs[attr] = append(attrs[attr], val)
+						}
 					}
 				}
 			}
@@ -126,6 +126,24 @@ func BuildStateMap(sl [][]string) Statemap {
 	return m
 }
 
+// stateProps gives the property values of a state, or false if it isn't
+// a real state because a value is past the end of its property's list.
+func stateProps(sl [][]string, state Stateval) (map[string]string, bool) {
+	props := map[string]string{}
+	offset := 0
+	for _, attrs := range sl {
+		values := attrs[1:]
+		attrBits := bits.Len(uint(len(values) - 1))
+		n := int(state>>offset) & (1<<attrBits - 1)
+		if n >= len(values) {
+			return nil, false
+		}
+		props[attrs[0]] = values[n]
+		offset += attrBits
+	}
+	return props, true
+}
+
 func (s Statemap) Get(properties string) Stateval {
 	return s.GetList(strings.Split(properties, ","))
 }
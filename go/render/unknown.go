@@ -0,0 +1,34 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// UnknownBlockName is the placeholder block drawn in place of blocks
+// missing from blockmeta.json, such as modded blocks or ones from a
+// newer version of the game.
+const UnknownBlockName = "cubeographer:unknown"
+
+const unknownTextureName = "cubeographer:block/unknown"
+
+// unknownTexture is the classic magenta and black "missing texture" checkerboard.
+func unknownTexture() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{0, 0, 0, 255}}, image.Point{}, draw.Src)
+	magenta := &image.Uniform{color.RGBA{248, 0, 248, 255}}
+	draw.Draw(img, image.Rect(0, 0, 8, 8), magenta, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(8, 8, 16, 16), magenta, image.Point{}, draw.Src)
+	return img
+}
+
+func unknownBlockEntry() BlockEntry {
+	return BlockEntry{
+		Name:        UnknownBlockName,
+		DisplayName: "Unknown Block",
+		Templates: []ModelEntry{
+			{Layer: LayerCubeFallback, Textures: []string{unknownTextureName}, Template: []uint32{0, 0b111111}},
+		},
+	}
+}
@@ -183,6 +183,32 @@ func (c BlockStateWhenClause) MarshalJSON() ([]byte, error) {
 	return json.Marshal(c.Clauses[0])
 }
 
+// Matches reports whether a block with the given properties meets the clause.
+// A value like "side|up" matches any of its alternatives.
+func (c *BlockStateWhenClause) Matches(props map[string]string) bool {
+	for _, clause := range c.Clauses {
+		if clauseMatches(clause, props) == c.IsOr {
+			return c.IsOr
+		}
+	}
+	return !c.IsOr
+}
+
+func clauseMatches(clause map[string]any, props map[string]string) bool {
+	for attr, want := range clause {
+		found := false
+		for _, val := range strings.Split(fmt.Sprint(want), "|") {
+			if props[attr] == val {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 type BlockState struct {
 	Variants  map[string]SingleOrSlice[ModelSpec] `json:"variants,omitempty"`
 	Multipart []struct {
@@ -39,6 +39,20 @@ func TestBlockstateUnmarshal(t *testing.T) {
   ]
 }`), &b)
 	require.NoError(t, err)
+
+	north := map[string]string{"facing": "north", "powered": "true"}
+	require.True(t, b.Multipart[0].When.Matches(north))
+	require.False(t, b.Multipart[1].When.Matches(north))
+	north["powered"] = "false"
+	require.True(t, b.Multipart[1].When.Matches(north))
+}
+
+func TestWhenClauseMatches(t *testing.T) {
+	var c BlockStateWhenClause
+	require.NoError(t, json.Unmarshal([]byte(`{"OR": [{"north": "side|up"}, {"east": "none", "up": true}]}`), &c))
+	require.True(t, c.Matches(map[string]string{"north": "up"}))
+	require.True(t, c.Matches(map[string]string{"north": "none", "east": "none", "up": "true"}))
+	require.False(t, c.Matches(map[string]string{"north": "none", "east": "none", "up": "false"}))
 }
 
 func TestJarFromZip(t *testing.T) {
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/rmmh/cubeographer/go/region"
+)
+
+// defaultSearchLimit caps the matches returned by the search API,
+// since searching for a common block could find millions.
+const defaultSearchLimit = 1000
+
+// searchRegions searches the regions of a directory that overlap box in parallel,
+// and returns the matches in region order, and whether there were more than
+// limit (if it's positive). Once the regions before one already hold more than
+// limit matches, it's skipped, so the same matches are returned however the
+// regions were scheduled. Unreadable chunks are skipped, like in scanRegion.
+// It stops early if ctx is cancelled.
+func searchRegions(ctx context.Context, regionDir string, bm *region.BlockMapper, queries []region.BlockQuery, box region.Box, limit, numProcs int) ([]region.BlockMatch, bool, error) {
+	entries, err := os.ReadDir(regionDir)
+	if err != nil {
+		return nil, false, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".mca") {
+			continue
+		}
+		rx, rz, err := region.ParseRegionPath(entry.Name())
+		if err == nil && box.OverlapsRegion(rx, rz) {
+			files = append(files, entry.Name())
+		}
+	}
+
+	perRegion := 0
+	if limit > 0 {
+		perRegion = limit + 1 // one extra, to tell if there are more
+	}
+	results := make([][]region.BlockMatch, len(files))
+	var lock sync.Mutex
+	// foundBefore counts the matches of the regions before i that have
+	// been searched so far, which only grows as more are finished
+	foundBefore := func(i int) int {
+		lock.Lock()
+		defer lock.Unlock()
+		n := 0
+		for _, r := range results[:i] {
+			n += len(r)
+		}
+		return n
+	}
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range max(numProcs, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if ctx.Err() != nil || (limit > 0 && foundBefore(i) > limit) {
+					continue
+				}
+				matches, err := region.SearchRegion(path.Join(regionDir, files[i]), bm, queries, box, perRegion)
+				var chunkErrs region.ChunkErrors
+				if errors.As(err, &chunkErrs) {
+					for _, ce := range chunkErrs {
+						log.Printf("%s: skipping %v", files[i], ce)
+					}
+				} else if err != nil {
+					log.Printf("%s: %v", files[i], err)
+				}
+				lock.Lock()
+				results[i] = matches
+				lock.Unlock()
+			}
+		}()
+	}
+feed:
+	for i := range files {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	var matches []region.BlockMatch
+	for _, r := range results {
+		matches = append(matches, r...)
+	}
+	if limit > 0 && len(matches) > limit {
+		return matches[:limit], true, nil
+	}
+	return matches, false, nil
+}
+
+// search prints the blocks in each configured map that match any of
+// the queries, one per line.
+func search(cfg *config, numProcs int, queries []region.BlockQuery, box region.Box, limit int) {
+	dims, err := cfg.dimensions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	bm, err := makeBlockMapper(cfg.DataDir)
+	if err != nil {
+		log.Fatalf("%v (generate it with gen first)", err)
+	}
+	for _, dim := range dims {
+		matches, truncated, err := searchRegions(context.Background(), dim.regionDir, bm, queries, box, limit, numProcs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range matches {
+			fmt.Printf("%s %s\n", dim.key, m.String())
+		}
+		if truncated {
+			log.Printf("stopped after %d matches in %s", limit, dim.key)
+		}
+	}
+}
+
+// searchHandler finds blocks matching one or more ?block= queries, within
+// an optional ?box=x1,y1,z1,x2,y2,z2, returning up to ?limit= of them.
+func (s *server) searchHandler(w http.ResponseWriter, r *http.Request) {
+	dim, ok := s.dims[mux.Vars(r)["world"]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	params := r.URL.Query()
+	var queries []region.BlockQuery
+	for _, b := range params["block"] {
+		q, err := region.ParseBlockQuery(b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		queries = append(queries, q)
+	}
+	if len(queries) == 0 {
+		http.Error(w, "missing block", http.StatusBadRequest)
+		return
+	}
+	box := region.EverywhereBox
+	if b := params.Get("box"); b != "" {
+		var err error
+		if box, err = region.ParseBox(b); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultSearchLimit
+	if l := params.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			http.Error(w, "bad limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	matches, truncated, err := searchRegions(r.Context(), dim.regionDir, s.bm, queries, box, limit, s.numProcs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if matches == nil {
+		matches = []region.BlockMatch{}
+	}
+	writeJSON(w, struct {
+		Matches   []region.BlockMatch `json:"matches"`
+		Truncated bool                `json:"truncated"`
+	}{matches, truncated})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rmmh/cubeographer/go/region"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchHandler(t *testing.T) {
+	regionDir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(regionDir, "r.0.0.mca"), make([]byte, 8192), 0644))
+	s := &server{
+		dims: map[string]dimension{"0": newDimension("minecraft:overworld", regionDir)},
+		bm:   testBlockMapper(t),
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/{world}/api/search", s.searchHandler)
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	w := get("/0/api/search?block=minecraft:spawner&block=chest%5Btype%3Dsingle%5D&box=0,0,0,100,100,100")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"matches": [], "truncated": false}`, w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, get("/0/api/search").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/0/api/search?block=chest%5Btype").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/0/api/search?block=chest&box=1,2").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/0/api/search?block=chest&limit=0").Code)
+	assert.Equal(t, http.StatusNotFound, get("/1/api/search?block=chest").Code)
+}
+
+func TestSearchRegions(t *testing.T) {
+	regionDir := t.TempDir()
+	bm := testBlockMapper(t)
+	// three stone blocks in each of eight regions
+	for rx := range 8 {
+		blocks := make([]uint16, 4096)
+		for i := range 3 {
+			blocks[i] = 1
+		}
+		var w region.Writer
+		require.NoError(t, w.Add(&region.Chunk{
+			X: rx * 32, Z: 0, DataVersion: 3700, Modified: time.Unix(1700000000, 0),
+			Sections: []region.Section{{Y: 4, Palette: []region.Block{{Name: "minecraft:air"}, {Name: "minecraft:stone"}}, Blocks: blocks}},
+		}))
+		require.NoError(t, w.WriteFile(path.Join(regionDir, w.Filename())))
+	}
+	queries := []region.BlockQuery{{Name: "minecraft:stone"}}
+
+	all, truncated, err := searchRegions(context.Background(), regionDir, bm, queries, region.EverywhereBox, 0, 1)
+	require.NoError(t, err)
+	require.False(t, truncated)
+	require.Len(t, all, 24)
+
+	// however the regions are scheduled, the first matches are the same
+	for range 20 {
+		matches, truncated, err := searchRegions(context.Background(), regionDir, bm, queries, region.EverywhereBox, 7, 8)
+		require.NoError(t, err)
+		require.True(t, truncated)
+		require.Equal(t, all[:7], matches)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = searchRegions(ctx, regionDir, bm, queries, region.EverywhereBox, 0, 2)
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,10 +15,12 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/rmmh/cubeographer/go/region"
+	"github.com/samber/lo"
 )
 
 var (
-	cmtRe = regexp.MustCompile(`([^/]+)/map/r\.(\d+)\.(\d+)\.\d+\.cmt$`)
+	// generated files for a region: the .cmt tile for each quadrant, and the points of interest
+	mapFileRe = regexp.MustCompile(`([^/]+)/map/r\.(-?\d+)\.(-?\d+)\.(?:\d+\.cmt|poi\.json)$`)
 )
 
 type workItem struct {
@@ -27,34 +30,47 @@ type workItem struct {
 	done  chan<- struct{}
 }
 
+// workKey identifies a region being rendered.
+type workKey struct {
+	world  string
+	rx, rz int
+}
+
 type server struct {
-	regionDir  map[string]string
-	readRegion map[string]region.ReadRegionFunc
-	dataDir    string
-	pruneCaves bool
+	dims      map[string]dimension // by key, which is also the URL prefix
+	dimKeys   []string             // in the order they were configured
+	manifests map[string]*manifest
+	dataDir   string
+	numProcs  int
 
 	binaryTime time.Time
 	bm         *region.BlockMapper
 
 	workQueue chan *workItem
+	updates   *updateHub
+	rerender  *renderQueue // regions the watcher has seen change
 
-	working  map[int64][]*workItem
+	working  map[workKey][]*workItem
 	workLock sync.Mutex
+
+	etags *etagCache
+}
+
+// rootHandler sends viewers to the first map.
+func (s *server) rootHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/"+s.dimKeys[0]+"/", http.StatusFound)
 }
 
 func (s *server) indexHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Cache-Control", "no-cache")
-	http.ServeFile(w, r, path.Join(s.dataDir, "index.html"))
+	s.serveFile(w, r, path.Join(s.dataDir, "index.html"), cacheRevalidate)
 }
 
 func (s *server) indexJsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Cache-Control", "no-cache")
-	http.ServeFile(w, r, path.Join(s.dataDir, "index.js"))
+	s.serveFile(w, r, path.Join(s.dataDir, "index.js"), cacheRevalidate)
 }
 
 func (s *server) textureHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Cache-Control", "no-cache")
-	http.ServeFile(w, r, path.Join(s.dataDir, r.URL.Path))
+	s.serveFile(w, r, path.Join(s.dataDir, r.URL.Path), cacheTextures)
 }
 
 func (s *server) isStale(r string) bool {
@@ -65,12 +81,20 @@ func (s *server) isStale(r string) bool {
 	if st.ModTime().Before(s.binaryTime) {
 		return true
 	}
+	if m := mapFileRe.FindStringSubmatch(r); m != nil && s.manifests[m[1]] != nil {
+		changed, _, err := s.manifests[m[1]].changed(s.regionPath(m[1], m[2], m[3]))
+		return changed && err == nil
+	}
 	return false
 }
 
+func (s *server) regionPath(world string, rx, rz any) string {
+	return path.Join(s.dims[world].regionDir, fmt.Sprintf("r.%v.%v.mca", rx, rz))
+}
+
 func (s *server) mapWorker() {
 	for item := range s.workQueue {
-		itemKey := int64(item.rx) + int64(item.rz)<<32
+		itemKey := workKey{item.world, item.rx, item.rz}
 		s.workLock.Lock()
 		_, exists := s.working[itemKey]
 		s.working[itemKey] = append(s.working[itemKey], item)
@@ -80,14 +104,31 @@ func (s *server) mapWorker() {
 			// dispatch the event when ready
 			continue
 		}
-		scanRegion(&scanRegionConfig{
-			dir:        s.regionDir[item.world],
-			readRegion: s.readRegion[item.world],
+		man := s.manifests[item.world]
+		var source *manifestRegion
+		if man != nil {
+			_, source, _ = man.changed(s.regionPath(item.world, item.rx, item.rz))
+		}
+		// bad chunks are skipped rather than failing the whole request,
+		// so only region-level errors end up here
+		dim := s.dims[item.world]
+		minY, maxY, err := scanRegion(&scanRegionConfig{
+			dir:        dim.regionDir,
+			readRegion: dim.readRegion,
 			outdir:     path.Join(s.dataDir, item.world, "map"),
 			file:       fmt.Sprintf("r.%d.%d.mca", item.rx, item.rz),
 			bm:         s.bm,
-			prune:      s.pruneCaves,
+			prune:      dim.prune,
+			ceiling:    dim.ceiling,
 		})
+		if err != nil {
+			log.Printf("unable to render %s r.%d.%d: %v", item.world, item.rx, item.rz, err)
+		} else if source != nil {
+			man.record(fmt.Sprintf("r.%d.%d.mca", item.rx, item.rz), source, minY, maxY)
+			if err := man.save(); err != nil {
+				log.Printf("unable to save manifest for %s: %v", item.world, err)
+			}
+		}
 		s.workLock.Lock()
 		for _, wait := range s.working[itemKey] {
 			close(wait.done)
@@ -98,12 +139,12 @@ func (s *server) mapWorker() {
 }
 
 func (s *server) awaitUpdate(filename string) {
-	m := cmtRe.FindStringSubmatch(filename)
+	m := mapFileRe.FindStringSubmatch(filename)
 	if len(m) == 0 {
 		return
 	}
 	world := m[1]
-	if s.regionDir[world] == "" && s.readRegion == nil {
+	if _, ok := s.dims[world]; !ok {
 		return
 	}
 	rx, _ := strconv.Atoi(m[2])
@@ -120,15 +161,18 @@ func (s *server) awaitUpdate(filename string) {
 }
 
 func (s *server) mapHandler(w http.ResponseWriter, r *http.Request) {
-	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-		w.Header().Add("Content-Encoding", "gzip")
-	}
-	w.Header().Add("Cache-Control", "no-cache")
-	log.Printf("%s stale=%v", r.URL.Path, s.isStale(r.URL.Path))
-	if s.isStale(r.URL.Path) {
+	stale := s.isStale(r.URL.Path)
+	log.Printf("%s stale=%v", r.URL.Path, stale)
+	if stale {
 		s.awaitUpdate(r.URL.Path)
 	}
-	http.ServeFile(w, r, path.Join(s.dataDir, r.URL.Path))
+	fname := path.Join(s.dataDir, r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, ".cmt") {
+		// tiles are always stored gzipped
+		s.serveGzipped(w, r, fname)
+	} else {
+		s.serveFile(w, r, fname, cacheRevalidate)
+	}
 }
 
 func (s *server) worldRedirHandler(w http.ResponseWriter, r *http.Request) {
@@ -136,53 +180,79 @@ func (s *server) worldRedirHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, r.URL.Path[strings.IndexByte(r.URL.Path[1:], '/')+1:], http.StatusFound)
 }
 
-func serve(numProcs int, regionDir string, dataDir string, pruneCaves bool) {
+// watchDebounce is how long a region file must go without being written to
+// before it's re-rendered, so that a save in progress isn't read.
+const watchDebounce = 10 * time.Second
+
+// serve renders the configured worlds' regions as they're requested,
+// and serves them with the viewer.
+func serve(cfg *config, numProcs int, watchInterval time.Duration) {
 	binaryStat, err := os.Stat(os.Args[0])
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	_, err = makeBlockMapper(dataDir)
-	if err != nil || *genDebug == "force" {
-		log.Println("regenerating block mapping")
-		generate(dataDir)
-	}
-	bm, err := makeBlockMapper(dataDir)
+	dims, err := cfg.dimensions()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	bm := loadBlockMapper(cfg.DataDir)
+	for _, worldDir := range lo.Uniq(lo.Map(dims, func(d dimension, _ int) string { return d.worldDir })) {
+		checkWorldVersion(bm, worldDir)
+	}
+
 	r := mux.NewRouter()
 	s := &server{
-		regionDir: map[string]string{"0": regionDir},
-		readRegion: map[string]region.ReadRegionFunc{
-			"test": region.FakeReadRegion,
-		},
-		dataDir:    dataDir,
-		pruneCaves: pruneCaves,
+		dims:       map[string]dimension{},
+		manifests:  map[string]*manifest{},
+		dataDir:    cfg.DataDir,
+		numProcs:   numProcs,
 		bm:         bm,
 		binaryTime: binaryStat.ModTime(),
 		workQueue:  make(chan *workItem),
-		working:    make(map[int64][]*workItem),
+		working:    make(map[workKey][]*workItem),
+		updates:    newUpdateHub(),
+		rerender:   newRenderQueue(),
+		etags:      newEtagCache(),
+	}
+
+	for _, dim := range dims {
+		s.dims[dim.key] = dim
+		s.dimKeys = append(s.dimKeys, dim.key)
+		s.manifests[dim.key], err = loadManifest(path.Join(cfg.DataDir, dim.key, "map"), bm, dim.prune)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("serving dimension %s of %s from %s at /%s/", dim.id, dim.worldDir, dim.regionDir, dim.key)
 	}
 
 	for i := 0; i < numProcs; i++ {
 		go s.mapWorker()
 	}
 
-	r.HandleFunc("/", s.indexHandler)
+	if watchInterval > 0 {
+		go s.watchRegions(context.Background(), watchInterval, watchDebounce)
+	}
+
+	r.HandleFunc("/", s.rootHandler)
 	r.HandleFunc("/index.js", s.indexJsHandler)
 	r.HandleFunc("/textures/{texture}", s.textureHandler)
-	r.HandleFunc("/map/{path}", s.mapHandler)
+	r.HandleFunc("/events", s.eventsHandler)
+	r.HandleFunc("/api/worlds", s.worldsHandler)
 
 	r.HandleFunc("/{world}/", s.indexHandler)
 	r.HandleFunc("/{world}/map/{path}", s.mapHandler)
+	r.HandleFunc("/{world}/events", s.eventsHandler)
+	r.HandleFunc("/{world}/api/regions", s.regionsHandler)
+	r.HandleFunc("/{world}/api/block", s.blockHandler)
+	r.HandleFunc("/{world}/api/search", s.searchHandler)
 	r.HandleFunc("/{world}/index.js", s.worldRedirHandler)
 	r.HandleFunc("/{world}/textures/{texture}", s.worldRedirHandler)
 
 	srv := &http.Server{
 		Handler:      r,
-		Addr:         "127.0.0.1:9999",
+		Addr:         cfg.Listen,
 		WriteTimeout: 120 * time.Second,
 		ReadTimeout:  10 * time.Second,
 	}
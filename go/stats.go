@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rmmh/cubeographer/go/region"
+)
+
+// stats summarizes each configured map: its world, how many regions and chunks
+// it has, when it was last saved, and how much of it has been rendered.
+func stats(w io.Writer, cfg *config) error {
+	dims, err := cfg.dimensions()
+	if err != nil {
+		return err
+	}
+	// without blockmeta nothing can have been rendered, but the rest still works
+	bm, err := makeBlockMapper(cfg.DataDir)
+	if err != nil {
+		log.Printf("%v (generate it with gen first)", err)
+	}
+
+	for _, dim := range dims {
+		fmt.Fprintf(w, "%s: %s from %s\n", dim.key, dim.id, dim.regionDir)
+		if ld := readLevelDat(dim.worldDir); ld != nil {
+			fmt.Fprintf(w, "  level %q, saved by %s (data version %d)\n", ld.LevelName, ld.VersionName, ld.DataVersion)
+		}
+
+		var man *manifest
+		if bm != nil {
+			if man, err = loadManifest(path.Join(cfg.DataDir, dim.key, "map"), bm, dim.prune); err != nil {
+				return err
+			}
+		}
+		entries, err := os.ReadDir(dim.regionDir)
+		if err != nil {
+			return err
+		}
+		var regions, chunks, rendered int
+		var size int64
+		var newest uint32
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry.Name(), ".mca") {
+				continue
+			}
+			fname := path.Join(dim.regionDir, entry.Name())
+			hdr, err := region.ReadRegionHeader(fname)
+			if err != nil {
+				log.Printf("%s: %v", fname, err)
+				continue
+			}
+			if st, err := os.Stat(fname); err == nil {
+				size += st.Size()
+			}
+			regions++
+			for i, offset := range hdr.Offsets {
+				if offset != 0 {
+					chunks++
+					newest = max(newest, hdr.Timestamps[i])
+				}
+			}
+			if man != nil {
+				if changed, _, err := man.changed(fname); err == nil && !changed {
+					rendered++
+				}
+			}
+		}
+		fmt.Fprintf(w, "  %d regions, %d chunks, %.1f MiB", regions, chunks, float64(size)/(1<<20))
+		if newest > 0 {
+			fmt.Fprintf(w, ", last saved %s", time.Unix(int64(newest), 0).UTC().Format(time.RFC3339))
+		}
+		fmt.Fprintln(w)
+		if man != nil {
+			fmt.Fprintf(w, "  %d of %d regions rendered to %s\n", rendered, regions, path.Join(cfg.DataDir, dim.key, "map"))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rmmh/cubeographer/go/region"
+	"github.com/rmmh/cubeographer/go/render"
+	"github.com/stretchr/testify/require"
+)
+
+// testBlockMapper has a block for each kind of template scanRegion
+// handles, with textures numbered in order.
+func testBlockMapper(t *testing.T) *region.BlockMapper {
+	cube := func(name string, tex uint32, solid bool) render.BlockEntry {
+		return render.BlockEntry{Name: name, Solid: solid, Templates: []render.ModelEntry{
+			{Layer: render.LayerCube, Template: []uint32{tex << 24, 0b111111}}}}
+	}
+	meta := render.BlockEntryMetadata{
+		Blocks: []render.BlockEntry{
+			{Name: "minecraft:air"},
+			cube("minecraft:stone", 1, true),
+			cube("minecraft:glass", 2, false),
+			{Name: "minecraft:water", Templates: []render.ModelEntry{
+				{Layer: render.LayerCube, Template: []uint32{3 << 24, 0b111111 | 1<<31}}}},
+			{Name: "minecraft:grass_block", Solid: true, Templates: []render.ModelEntry{
+				{Layer: render.LayerCube, Template: []uint32{4 << 24, 0b101111 | 1<<30, 5 << 24, 0b011111 | 3<<30}}}},
+			{Name: "minecraft:poppy", Templates: []render.ModelEntry{
+				{Layer: render.LayerCross, Template: []uint32{6 << 24, 0b1111111}}}},
+			{Name: "minecraft:wheat", Templates: []render.ModelEntry{
+				{Layer: render.LayerCrop, Template: []uint32{7 << 24, 0b1111111}}}},
+			{Name: "minecraft:oak_log", Solid: true, States: [][]string{{"axis", "x", "y", "z"}}, Templates: []render.ModelEntry{
+				{Layer: render.LayerCube, Template: []uint32{8 << 24, 0b111111}},
+				{Layer: render.LayerCube, Template: []uint32{9 << 24, 0b111111}},
+				{Layer: render.LayerCube, Template: []uint32{10 << 24, 0b111111}}}},
+			// a bottom slab: an element with its top always drawn, and a second
+			// texture on its sides
+			{Name: "minecraft:oak_slab", States: [][]string{{"type", "bottom", "top"}}, Templates: []render.ModelEntry{
+				{Layer: render.LayerModel, Template: []uint32{12 << 24, 3<<12 | 0b010000<<6 | 0b100000, 13 << 24, 3<<12 | 0b001111}}}},
+			// what's below the bottom of a chunk
+			cube("minecraft:bedrock", 14, true),
+			{Name: render.UnknownBlockName, Solid: true, Templates: []render.ModelEntry{
+				{Layer: render.LayerCubeFallback, Template: []uint32{11 << 24, 0b111111}}}},
+		},
+		Biomes:       make([]render.BiomeColors, len(render.Biomes)),
+		WorldVersion: 3700,
+	}
+	buf, err := json.Marshal(meta)
+	require.NoError(t, err)
+	bm, err := region.LoadBlockMapper(buf)
+	require.NoError(t, err)
+	return bm
+}
@@ -19,6 +19,7 @@ type blockVis struct {
 	passable  []uint64 // packed bitset representing whether a given cell is passable, with 0 meaning passable
 	reachable []uint32 // which faces can reach this cell?
 	// octahedral: 0: -x-y-z, 1: -x-y+z, 2: -x+y-z, ... 31: queued
+	minY int // world Y of the bottom of the lowest cell
 }
 
 const reachableQueued = 1 << 31
@@ -85,7 +86,9 @@ func (cv *blockVis) isPassable(x, y, z int) bool {
 	return cv.passable[i>>6]&(1<<(i&63)) == 0
 }
 
+// isVisible takes world Y coordinates, unlike the cell-relative methods above.
 func (cv *blockVis) isVisible(x, y, z int) bool {
+	y -= cv.minY
 	return cv.reachable[(x>>visDimBits)+(z>>visDimBits)*visWidth+(y>>visDimBits)*(visWidth*visWidth)] != 0
 }
 
@@ -174,17 +177,19 @@ zpos:
 	return faces >= 2
 }
 
-func makeBlockvis(chunks []region.ChunkDatum, bm Solider, mode visibilityMode) *blockVis {
+// netherLavaSea is the world Y just above the surface of the nether's lava sea.
+const netherLavaSea = 32
+
+// makeBlockvis computes which parts of a region might be visible from outside it.
+// If ceiling is nonzero, it's the world Y just above a roof (like the nether's
+// bedrock), and visibility floods in from the sides beneath the roof and from
+// the lava sea instead of from the top.
+func makeBlockvis(chunks []region.ChunkDatum, bm Solider, mode visibilityMode, ceiling int) *blockVis {
 	var cv blockVis
 
-	maxSectionCount := 0
-	for cx := range 32 {
-		for cz := range 32 {
-			if len(chunks[cx+cz*32].Blocks) > maxSectionCount {
-				maxSectionCount = len(chunks[cx+cz*32].Blocks)
-			}
-		}
-	}
+	bottom, top := regionYBounds(chunks)
+	cv.minY = bottom
+	maxSectionCount := (top - bottom) / 16
 	cv.passable = make([]uint64, visWidth*visWidth*maxSectionCount*(16/visDim)/64)
 	cv.reachable = make([]uint32, visWidth*visWidth*maxSectionCount*(16/visDim))
 
@@ -197,12 +202,26 @@ func makeBlockvis(chunks []region.ChunkDatum, bm Solider, mode visibilityMode) *
 
 	for cx := range 32 {
 		for cz := range 32 {
-			for ys, section := range chunks[cx+cz*32].Blocks {
+			chunk := &chunks[cx+cz*32]
+			if len(chunk.Blocks) == 0 {
+				continue
+			}
+			base := chunk.MinY - bottom
+			// chunks that start higher than the rest of the region
+			// (e.g. not yet upgraded to 1.18) are solid underneath
+			for y := 0; y < base; y += visDim {
+				for z := 0; z < 16; z += visDim {
+					for x := 0; x < 16; x += visDim {
+						cv.setSolid(cx*16+x, y, cz*16+z)
+					}
+				}
+			}
+			for ys, section := range chunk.Blocks {
 				for y := 0; y < 16; y += visDim {
 					for z := 0; z < 16; z += visDim {
 						for x := 0; x < 16; x += visDim {
 							if !isPassable(section, bm, x, y, z) {
-								cv.setSolid(cx*16+x, ys*16+y, cz*16+z)
+								cv.setSolid(cx*16+x, base+ys*16+y, cz*16+z)
 							}
 						}
 					}
@@ -289,13 +308,33 @@ func makeBlockvis(chunks []region.ChunkDatum, bm Solider, mode visibilityMode) *
 		}
 	}
 
-	fastFillTopDown(0, maxY-1, 0, visWidth)
+	// the highest cell that's open to the outside, from the top or sides
+	topY := maxY - 1
+	if ceiling != 0 {
+		topY = min(topY, (ceiling-bottom)>>visDimBits-1)
+		// Everything above the roof is unreachable, so the roof is only drawn
+		// where it can be seen from below. The caverns beneath it are open to
+		// the sides below, and to the lava sea, which spans them even where
+		// they don't reach the sides. Seeding the first gap under the roof
+		// instead would also seed pockets sealed inside the rock.
+		if y := (netherLavaSea - bottom) >> visDimBits; y >= 0 && y <= topY {
+			for z := range visWidth {
+				for x := range visWidth {
+					if cv.isPassable(x<<visDimBits, y<<visDimBits, z<<visDimBits) {
+						updateReachable(x, y, z, mask)
+					}
+				}
+			}
+		}
+	} else {
+		fastFillTopDown(0, maxY-1, 0, visWidth)
+	}
 
 	// Pushing the sides after the top gives faster BFS convergence.
 	// Doing this inside the loop when the iteration reaches the correct
 	// Y level is a few percent faster still, but makes the code even harder to read.
 	// Also, pushing top down gives faster BFS convergence
-	for y := maxY - 1; y >= 0; y-- {
+	for y := topY; y >= 0; y-- {
 		for x := range visWidth {
 			updateReachable(x, y, 0, mask)
 			updateReachable(x, y, visWidth-1, mask)
@@ -211,7 +211,7 @@ func TestMakeChunkvis(t *testing.T) {
 		}
 
 		for _, mode := range []visibilityMode{visOctahedral, visTriakisOctahedral} {
-			cv := makeBlockvis(r, m, mode)
+			cv := makeBlockvis(r, m, mode, 0)
 			for n, els := range lines {
 				for ho, _ := range els {
 					y := len(lines) - n
@@ -245,6 +245,34 @@ func TestMakeChunkvis(t *testing.T) {
 	}
 }
 
+func TestMakeBlockvisCeiling(t *testing.T) {
+	m := onlyZeroIsSolid(false)
+
+	empty := make([]uint16, 4096)
+	solid := make([]uint16, 4096)
+	for i := range solid {
+		solid[i] = 1
+	}
+
+	// a floor, a two-section cavern, a roof, and open air above it
+	r := make([]region.ChunkDatum, 1024)
+	for i := range r {
+		r[i].Blocks = [][]uint16{solid, empty, empty, solid, empty}
+	}
+
+	cv := makeBlockvis(r, m, visTriakisOctahedral, 0)
+	assert.True(t, cv.isVisible(100, 70, 100), "sky should be visible")
+	assert.True(t, cv.isVisible(100, 62, 100), "top of roof should be visible")
+
+	cv = makeBlockvis(r, m, visTriakisOctahedral, 64)
+	assert.False(t, cv.isVisible(100, 70, 100), "sky should be hidden")
+	assert.False(t, cv.isVisible(100, 62, 100), "top of roof should be hidden")
+	assert.True(t, cv.isVisible(100, 48, 100), "underside of roof should be visible")
+	assert.True(t, cv.isVisible(100, 30, 100), "cavern should be visible")
+	assert.True(t, cv.isVisible(100, 14, 100), "floor should be visible")
+	assert.False(t, cv.isVisible(100, 4, 100), "inside of floor should be hidden")
+}
+
 func TestOctahedronProperties(t *testing.T) {
 	octAxes := []uint{
 		octXPos, octXNeg,
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rmmh/cubeographer/go/region"
+)
+
+// regionUpdate is sent to viewers when a region's tiles have been re-rendered.
+type regionUpdate struct {
+	World string `json:"world"`
+	X     int    `json:"x"`
+	Z     int    `json:"z"`
+}
+
+// updateHub fans region updates out to the connected viewers.
+type updateHub struct {
+	lock sync.Mutex
+	subs map[chan regionUpdate]struct{}
+}
+
+func newUpdateHub() *updateHub {
+	return &updateHub{subs: map[chan regionUpdate]struct{}{}}
+}
+
+func (h *updateHub) subscribe() chan regionUpdate {
+	ch := make(chan regionUpdate, 64)
+	h.lock.Lock()
+	h.subs[ch] = struct{}{}
+	h.lock.Unlock()
+	return ch
+}
+
+func (h *updateHub) unsubscribe(ch chan regionUpdate) {
+	h.lock.Lock()
+	delete(h.subs, ch)
+	h.lock.Unlock()
+}
+
+func (h *updateHub) publish(u regionUpdate) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- u:
+		default:
+			// the viewer isn't keeping up, and will refetch
+			// the region the next time it changes
+		}
+	}
+}
+
+// eventsHandler streams region updates to a viewer as Server-Sent Events.
+// Under /{world}/, only that world's updates are sent.
+func (s *server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	world := mux.Vars(r)["world"]
+	rc := http.NewResponseController(w)
+	// the server's write timeout would otherwise end the stream
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	// subscribe before the viewer sees the response, so no updates are missed
+	ch := s.updates.subscribe()
+	defer s.updates.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case u := <-ch:
+			if world != "" && u.World != world {
+				continue
+			}
+			buf, _ := json.Marshal(u)
+			fmt.Fprintf(w, "event: region\ndata: %s\n\n", buf)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// renderQueue holds the regions waiting to be re-rendered after changing,
+// each at most once, in the order they changed.
+type renderQueue struct {
+	lock   sync.Mutex
+	keys   []workKey
+	queued map[workKey]bool
+	ready  chan struct{} // has a value when keys might not be empty
+}
+
+func newRenderQueue() *renderQueue {
+	return &renderQueue{queued: map[workKey]bool{}, ready: make(chan struct{}, 1)}
+}
+
+// signal wakes a waiting pop, if there isn't one already woken.
+func (q *renderQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// push queues a region, unless it's already waiting.
+func (q *renderQueue) push(k workKey) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.queued[k] {
+		return false
+	}
+	q.queued[k] = true
+	q.keys = append(q.keys, k)
+	q.signal()
+	return true
+}
+
+// pop waits for a region to be queued and takes it off the queue, so a
+// change made while it's being rendered queues it again. It returns
+// false if ctx is done first.
+func (q *renderQueue) pop(ctx context.Context) (workKey, bool) {
+	for {
+		q.lock.Lock()
+		if len(q.keys) > 0 {
+			k := q.keys[0]
+			q.keys = q.keys[1:]
+			delete(q.queued, k)
+			if len(q.keys) > 0 {
+				q.signal()
+			}
+			q.lock.Unlock()
+			return k, true
+		}
+		q.lock.Unlock()
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return workKey{}, false
+		}
+	}
+}
+
+// watchRegions polls the region directories for files the game has written to,
+// and re-renders the regions that have already been rendered once they've been
+// quiet for the debounce interval. Regions that haven't been rendered yet are
+// left to be rendered when they're first requested. It returns when ctx is done.
+func (s *server) watchRegions(ctx context.Context, interval, debounce time.Duration) {
+	type fileState struct {
+		modTime time.Time
+		size    int64
+		pending bool // changed, and waiting to settle
+	}
+	// re-renders share the map workers with viewers' requests,
+	// so only a few are waited on at a time
+	for range max(s.numProcs/2, 1) {
+		go s.rerenderChanged(ctx)
+	}
+	files := map[string]*fileState{}
+	first := true
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		for world, dim := range s.dims {
+			entries, err := os.ReadDir(dim.regionDir)
+			if err != nil {
+				log.Printf("watch %s: %v", dim.regionDir, err)
+				continue
+			}
+			for _, entry := range entries {
+				if !strings.HasSuffix(entry.Name(), ".mca") {
+					continue
+				}
+				info, err := entry.Info()
+				if err != nil {
+					continue
+				}
+				fn := path.Join(dim.regionDir, entry.Name())
+				st := files[fn]
+				if st == nil {
+					st = &fileState{modTime: info.ModTime(), size: info.Size()}
+					files[fn] = st
+					st.pending = !first
+					continue
+				}
+				if !info.ModTime().Equal(st.modTime) || info.Size() != st.size {
+					st.modTime, st.size = info.ModTime(), info.Size()
+					st.pending = true
+					continue
+				}
+				if !st.pending || now.Sub(st.modTime) < debounce {
+					continue
+				}
+				st.pending = false
+				s.queueChangedRegion(world, fn)
+				// faces along the neighbours' borders may have changed too
+				if rx, rz, err := region.ParseRegionPath(entry.Name()); err == nil {
+					for _, n := range neighborQuadrants {
+						s.queueChangedRegion(world, s.regionPath(world, rx+n.dx, rz+n.dz))
+					}
+				}
+			}
+		}
+		first = false
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// queueChangedRegion queues a region to be re-rendered if its chunks have
+// changed since it was last rendered.
+func (s *server) queueChangedRegion(world, fn string) {
+	man := s.manifests[world]
+	if man == nil {
+		return
+	}
+	man.lock.Lock()
+	_, rendered := man.Regions[path.Base(fn)]
+	man.lock.Unlock()
+	if !rendered {
+		return
+	}
+	changed, _, err := man.changed(fn)
+	if err != nil || !changed {
+		return
+	}
+	rx, rz, err := region.ParseRegionPath(path.Base(fn))
+	if err != nil {
+		return
+	}
+	if s.rerender.push(workKey{world, rx, rz}) {
+		log.Printf("%s %s changed, re-rendering", world, path.Base(fn))
+	}
+}
+
+// rerenderChanged renders the queued regions, and tells viewers as each is done.
+func (s *server) rerenderChanged(ctx context.Context) {
+	for {
+		k, ok := s.rerender.pop(ctx)
+		if !ok {
+			return
+		}
+		done := make(chan struct{})
+		s.workQueue <- &workItem{world: k.world, rx: k.rx, rz: k.rz, done: done}
+		<-done
+		s.updates.publish(regionUpdate{World: k.world, X: k.rx, Z: k.rz})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rmmh/cubeographer/go/region"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventsHandler(t *testing.T) {
+	s := &server{updates: newUpdateHub()}
+	r := mux.NewRouter()
+	r.HandleFunc("/{world}/events", s.eventsHandler)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/-1/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// other worlds' updates are filtered out
+	s.updates.publish(regionUpdate{World: "0", X: 1, Z: 2})
+	s.updates.publish(regionUpdate{World: "-1", X: -3, Z: 4})
+
+	lines := bufio.NewScanner(resp.Body)
+	var got []string
+	for len(got) < 2 && lines.Scan() {
+		if line := strings.TrimSpace(lines.Text()); line != "" {
+			got = append(got, line)
+		}
+	}
+	require.Equal(t, []string{"event: region", `data: {"world":"-1","x":-3,"z":4}`}, got)
+}
+
+func TestWatchRegions(t *testing.T) {
+	regionDir, dataDir := t.TempDir(), t.TempDir()
+	bm := testBlockMapper(t)
+	fn := path.Join(regionDir, "r.0.0.mca")
+	// writeRegion saves a chunk, with its timestamp as the file's
+	// modification time, so it's long past the debounce interval
+	writeRegion := func(modified time.Time) {
+		var w region.Writer
+		require.NoError(t, w.Add(&region.Chunk{
+			X: 0, Z: 0, DataVersion: 3700, Modified: modified,
+			Sections: []region.Section{{Y: 4, Palette: []region.Block{{Name: "minecraft:stone"}}}},
+		}))
+		require.NoError(t, w.WriteFile(fn))
+		require.NoError(t, os.Chtimes(fn, modified, modified))
+	}
+	writeRegion(time.Unix(1700000000, 0))
+
+	man, err := loadManifest(path.Join(dataDir, "0", "map"), bm, false)
+	require.NoError(t, err)
+	s := &server{
+		dims:      map[string]dimension{"0": newDimension("minecraft:overworld", regionDir)},
+		manifests: map[string]*manifest{"0": man},
+		dataDir:   dataDir,
+		numProcs:  2,
+		bm:        bm,
+		workQueue: make(chan *workItem),
+		working:   map[workKey][]*workItem{},
+		updates:   newUpdateHub(),
+		rerender:  newRenderQueue(),
+	}
+	go s.mapWorker()
+	// only regions that have been rendered are re-rendered
+	s.awaitUpdate("0/map/r.0.0.0.cmt")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := s.updates.subscribe()
+	go s.watchRegions(ctx, 10*time.Millisecond, time.Minute)
+	time.Sleep(100 * time.Millisecond) // for the files to be listed
+
+	writeRegion(time.Unix(1700000100, 0))
+	select {
+	case u := <-updates:
+		require.Equal(t, regionUpdate{World: "0", X: 0, Z: 0}, u)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update after the region changed")
+	}
+	select {
+	case u := <-updates:
+		t.Fatalf("a second update for one change: %+v", u)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRenderQueue(t *testing.T) {
+	q := newRenderQueue()
+	a, b := workKey{"0", 1, 2}, workKey{"0", -1, 0}
+	require.True(t, q.push(a))
+	require.True(t, q.push(b))
+	require.False(t, q.push(a), "already waiting")
+	k, ok := q.pop(context.Background())
+	require.True(t, ok)
+	require.Equal(t, a, k)
+	require.True(t, q.push(a), "changed again while rendering")
+	k, _ = q.pop(context.Background())
+	require.Equal(t, b, k)
+	k, _ = q.pop(context.Background())
+	require.Equal(t, a, k)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, ok = q.pop(ctx)
+	require.False(t, ok)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rmmh/cubeographer/go/region"
+)
+
+// dimension is one of a world's dimensions, each with its own region directory.
+type dimension struct {
+	// key names the dimension in URLs and output directories. The vanilla
+	// dimensions use their old DIM numbers ("0", "-1" and "1"), and datapack
+	// dimensions use their id with the separators replaced by dots.
+	key       string
+	id        string // e.g. "minecraft:the_nether"
+	regionDir string
+	// ceiling is the world Y just above a bedrock roof, like the nether's,
+	// or 0 if the dimension is open to the sky. The map of a dimension with a
+	// ceiling shows what's under the roof, instead of the roof itself.
+	ceiling int
+
+	// the rest come from the config of the world it's part of
+	world       string // the configured name, possibly empty
+	worldDir    string // where level.dat is
+	displayName string
+	prune       bool
+	readRegion  region.ReadRegionFunc // for tests; region.ReadRegion if nil
+}
+
+var vanillaDimensions = []dimension{
+	{key: "0", id: "minecraft:overworld"},
+	{key: "-1", id: "minecraft:the_nether", ceiling: 128},
+	{key: "1", id: "minecraft:the_end"},
+}
+
+// newDimension fills in the key and ceiling of a dimension from its id.
+func newDimension(id, regionDir string) dimension {
+	for _, d := range vanillaDimensions {
+		if d.id == id {
+			d.regionDir = regionDir
+			return d
+		}
+	}
+	return dimension{
+		key:       strings.NewReplacer(":", ".", "/", ".").Replace(id),
+		id:        id,
+		regionDir: regionDir,
+	}
+}
+
+// isRegionDir reports whether dir directly holds region files.
+func isRegionDir(dir string) bool {
+	matches, _ := filepath.Glob(filepath.Join(dir, "r.*.mca"))
+	return len(matches) > 0
+}
+
+// worldRoot returns the world directory that holds a directory given to
+// discoverDimensions, where level.dat is.
+func worldRoot(dir string) string {
+	if !isRegionDir(dir) {
+		return dir
+	}
+	parent := filepath.Dir(filepath.Clean(dir))
+	if base := filepath.Base(parent); base == "DIM-1" || base == "DIM1" {
+		return filepath.Dir(parent)
+	}
+	return parent
+}
+
+// discoverDimensions finds the dimensions of a world: the overworld in region/,
+// the nether and end in DIM-1/ and DIM1/, and any datapack dimensions in
+// dimensions/<namespace>/<name>/. A region directory can also be given
+// directly, in which case it's the only dimension.
+func discoverDimensions(worldDir string) ([]dimension, error) {
+	if isRegionDir(worldDir) {
+		// guess which dimension it is from where it lives in the world
+		id := "minecraft:overworld"
+		switch filepath.Base(filepath.Dir(filepath.Clean(worldDir))) {
+		case "DIM-1":
+			id = "minecraft:the_nether"
+		case "DIM1":
+			id = "minecraft:the_end"
+		}
+		return []dimension{newDimension(id, worldDir)}, nil
+	}
+
+	var dims []dimension
+	seen := map[string]bool{}
+	add := func(id, regionDir string) {
+		if !seen[id] && isRegionDir(regionDir) {
+			seen[id] = true
+			dims = append(dims, newDimension(id, regionDir))
+		}
+	}
+	add("minecraft:overworld", filepath.Join(worldDir, "region"))
+	add("minecraft:the_nether", filepath.Join(worldDir, "DIM-1", "region"))
+	add("minecraft:the_end", filepath.Join(worldDir, "DIM1", "region"))
+
+	dimsDir := filepath.Join(worldDir, "dimensions")
+	err := filepath.WalkDir(dimsDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || d.Name() != "region" {
+			return nil
+		}
+		rel, err := filepath.Rel(dimsDir, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		// dimensions/<namespace>/<path...>/region
+		ns, name, ok := strings.Cut(filepath.ToSlash(rel), "/")
+		if ok {
+			add(ns+":"+name, p)
+		}
+		return filepath.SkipDir
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if len(dims) == 0 {
+		if _, err := os.Stat(worldDir); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("no region files found in " + worldDir)
+	}
+	return dims, nil
+}
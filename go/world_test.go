@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscoverDimensions(t *testing.T) {
+	world := t.TempDir()
+	for _, dir := range []string{
+		"region",
+		"DIM-1/region",
+		"DIM1/region",
+		"dimensions/mypack/mining/region",
+		"dimensions/mypack/deep/caves/region",
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(world, dir), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(world, dir, "r.0.0.mca"), nil, 0644))
+	}
+	// no region files, so not a dimension
+	assert.NoError(t, os.MkdirAll(filepath.Join(world, "dimensions/mypack/empty/region"), 0755))
+
+	dims, err := discoverDimensions(world)
+	assert.NoError(t, err)
+	var keys, ids []string
+	for _, d := range dims {
+		keys = append(keys, d.key)
+		ids = append(ids, d.id)
+	}
+	assert.Equal(t, []string{"0", "-1", "1", "mypack.deep.caves", "mypack.mining"}, keys)
+	assert.Equal(t, []string{"minecraft:overworld", "minecraft:the_nether", "minecraft:the_end", "mypack:deep/caves", "mypack:mining"}, ids)
+	assert.Equal(t, 128, dims[1].ceiling)
+	assert.Equal(t, filepath.Join(world, "DIM-1", "region"), dims[1].regionDir)
+
+	// a region directory on its own
+	dims, err = discoverDimensions(filepath.Join(world, "DIM-1", "region"))
+	assert.NoError(t, err)
+	assert.Len(t, dims, 1)
+	assert.Equal(t, "-1", dims[0].key)
+	assert.Equal(t, world, worldRoot(filepath.Join(world, "DIM-1", "region")))
+	assert.Equal(t, world, worldRoot(filepath.Join(world, "region")))
+	assert.Equal(t, world, worldRoot(world))
+
+	_, err = discoverDimensions(filepath.Join(world, "dimensions"))
+	assert.Error(t, err)
+}
@@ -5,6 +5,7 @@ precision highp int;
 // PACKING FORMATS:
 // 0: basic cube, with one or two face textures, in a 256x256x256 regionlet
 // 0: 64 bits: 8b blockid, 24b position (8b x/y/z) // 2b flags 24b lighting (4b * 6 faces) 6b facevis
+// MODEL: 64 bits: 8b blockid, 24b position // 1b tint 1b blockid bit 8 16b element 2b unused 4b lighting 6b facevis
 
 // #define CUBE_SCALE 16
 
@@ -14,6 +15,11 @@ uniform mat4 modelViewMatrix; // optional
 uniform mat4 projectionMatrix; // optional
 uniform vec3 cameraPosition;
 uniform vec3 offset;
+uniform sampler2D tints;  // tint kind of each atlas entry, in the red channel
+uniform sampler2D biomes; // grass, foliage, water colors for each 4x4 column, stacked vertically
+#ifdef MODEL
+uniform sampler2D elements; // box, rotation and face UVs of each element, 16 texels each, see render/elements.go
+#endif
 
 in vec3 position;
 in vec4 color;
@@ -31,16 +37,64 @@ vec3 unpackPos(uint p) { // 26b pos (9b,8b,9b each) => vec3
     return vec3(float((p >> 16) & 255u) , float(p & 255u), float((p >> 8) & 255u));
 }
 
-vec3 unpackColor(int blockId, uint color) {
-    // TODO: read biome color from texture?
+// tint kinds, matching render.TintKind
+#define TINT_GRASS 0
+#define TINT_FOLIAGE 1
+#define TINT_WATER 2
+#define TINT_BIRCH 3
+#define TINT_SPRUCE 4
+#define TINT_LILY_PAD 5
+#define TINT_REDSTONE 6
+
+vec3 unpackColor(int block, uint color, vec3 pos) {
     if ((color & (1u << 31)) == 0u)
         return vec3(1.0);
-#ifdef WATER_ID
-    if (blockId == WATER_ID)
-        return vec3(0.2, 0.4, 0.93);
-#endif
-    return vec3(0.4,0.73,0.27);
+    int kind = int(texelFetch(tints, ivec2(block % 32, block / 32), 0).r * 255.0 + 0.5);
+    if (kind == TINT_BIRCH)
+        return vec3(0x80, 0xa7, 0x55) / 255.0;
+    if (kind == TINT_SPRUCE)
+        return vec3(0x61, 0x99, 0x61) / 255.0;
+    if (kind == TINT_LILY_PAD)
+        return vec3(0x20, 0x80, 0x30) / 255.0;
+    if (kind == TINT_REDSTONE)
+        return vec3(0xc0, 0x10, 0x00) / 255.0;
+    // each kind's map is 64 texels tall, keep the filtering from bleeding between them
+    float row = clamp((pos.z + 0.5) / 4.0, 0.5, 63.5) + float(kind * 64);
+    return texture(biomes, vec2((pos.x + 0.5) / 256.0, row / 192.0)).rgb;
+}
+
+#ifdef MODEL
+vec4 fetchElement(int element, int i) {
+    int t = element * 16 + i;
+    return texelFetch(elements, ivec2(t % 512, t / 512), 0);
+}
+
+// element coordinates are stored as (v+16)*4, in 16ths of a block
+vec3 elementCoord(int element, int i) {
+    return fetchElement(element, i).rgb * (255.0 / 4.0) - 16.0;
+}
+
+// rotateElement applies an element's rotation: r is axis<<4 | rescale<<3 | angle/22.5+2
+vec3 rotateElement(vec3 p, vec3 origin, int r, bool isNormal) {
+    int axis = r >> 4;
+    if (axis == 0)
+        return p;
+    float angle = radians(float((r & 7) - 2) * 22.5);
+    float c = cos(angle), s = sin(angle);
+    vec3 d = isNormal ? p : p - origin;
+    vec2 q = axis == 1 ? d.yz : axis == 2 ? d.zx : d.xy;
+    q = vec2(c * q.x - s * q.y, s * q.x + c * q.y);
+    if (!isNormal && (r & 8) != 0)
+        q /= c;
+    if (axis == 1)
+        d.yz = q;
+    else if (axis == 2)
+        d.zx = q;
+    else
+        d.xy = q;
+    return isNormal ? d : d + origin;
 }
+#endif
 
 bool shouldDiscard(int face, uint s) {
     return (s & uint(1 << face)) == 0u;
@@ -51,11 +105,31 @@ void main()	{
     int blockId = int(attr.x >> 24u);
 #ifdef CROSS
     float light = float(attr.y&0xFu)/15.0 * 0.7 + 0.3;
-    vColor = vec4(unpackColor(blockId, attr.y) * vec3(light), 1.0);
     // if (face >= 2)vColor = vec4(1,shouldDiscard(face, attr.y),0,1);
     bool sideSpecial = false;
     vNormal = normal;
     gl_Position = projectionMatrix * modelViewMatrix * vec4(position + unpackedPos, 1.0 );
+#elif defined(MODEL)
+    int element = int((attr.y >> 12u) & 0xFFFFu);
+    vec3 from = elementCoord(element, 0), to = elementCoord(element, 1), origin = elementCoord(element, 2);
+    vec4 rot = fetchElement(element, 3);
+    int r = int(rot.r * 255.0 + 0.5);
+    // pick the side of the (rotated) box facing the camera
+    vec3 center = rotateElement((from + to) * 0.5, origin, r, false) / 16.0;
+    vec3 rotNormal = rotateElement(normal, vec3(0), r, true);
+    bool shouldFlip = dot(rotNormal, cameraPosition - (unpackedPos + offset + center)) < 0.0;
+    int face = int(gl_VertexID / 6) * 2 + (shouldFlip ? 1 : 0);
+    if (shouldDiscard(face, attr.y)) {
+        gl_Position = vec4(1e20);
+        return;
+    }
+    float light = float((attr.y>>6u)&0xFu)/15.0 * 0.7 + 0.3;
+    bool sideSpecial = false;
+    blockId |= int(attr.y>>22) & 256;
+    vec3 boxPos = mix(from, to, shouldFlip ? vec3(1) - position : position);
+    gl_Position = projectionMatrix * modelViewMatrix *
+        vec4(rotateElement(boxPos, origin, r, false) / 16.0 + unpackedPos, 1.0 );
+    vNormal = rotNormal * vec3(shouldFlip ? -1.0 : 1.0);
 #else
     bool shouldFlip = dot(normal, cameraPosition - (unpackedPos + offset)) < 0.0;
     int face = int(gl_VertexID / 6) * 2 + (shouldFlip ? 1 : 0);
@@ -63,23 +137,32 @@ void main()	{
         gl_Position = vec4(1e20);
         return;
     }
-    float sideLight = float( (attr.y>>uint(6+face*4))&0xFu)/15.0 * 0.7 + 0.3;
+    float light = float( (attr.y>>uint(6+face*4))&0xFu)/15.0 * 0.7 + 0.3;
 #ifdef FALLBACK
     bool sideSpecial = false;
     blockId |= int(attr.y>>22) & 256;
 #else
     bool sideSpecial = face >= 4 && (attr.y & (1u<<30)) != 0u;
 #endif
-    vColor = vec4(unpackColor(blockId, attr.y) * vec3(sideLight), 1.0);
     gl_Position = projectionMatrix * modelViewMatrix *
         vec4((shouldFlip ? vec3(1) - position : position) + unpackedPos, 1.0 );
     vNormal = normal * vec3(shouldFlip ? -1.0 : 1.0);
 #endif // CROSS
     int block = (blockId + (sideSpecial ? 256 : 0));
+    vColor = vec4(unpackColor(block, attr.y, unpackedPos) * vec3(light), 1.0);
     vec2 primCoord;
     primCoord = vec2(float(uv.x), float(uv.y)) * (1.0-1./128.) + vec2(1./256.);
 #ifdef CROSS
     vTexCoord = (vec2(1) - primCoord + vec2(block % 32, block / 32)) / 32.0;
+#elif defined(MODEL)
+    // map the quad onto the face's UVs, turned by its rotation
+    vec2 faceCoord = shouldFlip ? vec2(uv) : vec2(1) - vec2(uv);
+    int turns = ((int(rot.g * 255.0 + 0.5) | int(rot.b * 255.0 + 0.5) << 8) >> (2 * face)) & 3;
+    for (int i = 0; i < turns; i++)
+        faceCoord = vec2(faceCoord.y, 1.0 - faceCoord.x);
+    vec2 uv0 = fetchElement(element, 4 + 2 * face).rg, uv1 = fetchElement(element, 5 + 2 * face).rg;
+    primCoord = mix(uv0, uv1, faceCoord) * (1.0-1./128.) + vec2(1./256.);
+    vTexCoord = (primCoord + vec2(block % 32, block / 32)) / 32.0;
 #else
     vTexCoord = ((shouldFlip ?  primCoord : vec2(1) - primCoord) + vec2(block % 32, block / 32)) / 32.0;
 #endif
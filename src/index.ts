@@ -11,6 +11,7 @@ import { Gunzip } from 'fflate';
 
 import * as renderer from './renderer';
 import { OrbitControls } from './camera';
+import { Markers } from './markers';
 
 DEBUG && new EventSource('/esbuild').addEventListener('change', () => location.reload());
 
@@ -43,11 +44,22 @@ const space = PROD ? 512 : 64;
 
 const scene: Set<renderer.Chunk> = new Set();
 
+// the chunks drawn for each tile, by "x.z.quadrant", so that they can be
+// replaced when the tile is re-rendered
+const tiles: Map<string, renderer.Chunk[]> = new Map();
+
+const markers = new Markers(document.body);
+
 const stats = new Stats();
 stats.showPanel(0); // 0: fps, 1: ms, 2: mb, 3+: custom
 document.body.appendChild(stats.dom);
 
-context.setClearColor(0x7e, 0xab, 0xff);
+// the dimension is the first part of the path, using the old DIM numbers: /0/, /-1/, /1/
+switch (location.pathname.split('/')[1]) {
+    case '-1': context.setClearColor(0x33, 0x08, 0x08); break;  // nether
+    case '1': context.setClearColor(0x14, 0x0e, 0x1e); break;   // end
+    default: context.setClearColor(0x7e, 0xab, 0xff); break;
+}
 
 let urlTimer = 0;
 
@@ -133,7 +145,7 @@ function makeMaterial(defines?: { [name: string]: any }) {
     return material;
 }
 
-function makeCubeLayer(name: string, texturePath: string, defines?: { [name: string]: any }) {
+function makeCubeLayer(name: string, texturePath: string, tintsPath: string, defines?: { [name: string]: any }) {
     const stride = 28; // vec3 pos, vec3 normal, fp16*2  => 6 * 4 + 2 * 2 => 24B
     const stridef = (stride / 4) | 0;
     const tris = 6;  // 3 faces * 2 tris each (we flip based on camera)
@@ -240,11 +252,19 @@ function makeCubeLayer(name: string, texturePath: string, defines?: { [name: str
     let material = makeMaterial(defines);
 
     let texture = context.loadTexture(texturePath, render);
+    let tints = context.loadTexture(tintsPath, render);
+
+    return new renderer.InstancedLayer(geometry, material, texture, tints, name);
+}
 
-    return new renderer.InstancedLayer(geometry, material, texture, name);
+// makeModelLayer draws each element of a block model as a cube scaled to its box
+function makeModelLayer(name: string, texturePath: string, tintsPath: string, elementsPath: string) {
+    const layer = makeCubeLayer(name, texturePath, tintsPath, {MODEL: 1});
+    layer.elements = context.loadTexture(elementsPath, render);
+    return layer;
 }
 
-function makeCrossLayer(name: string, texturePath: string, defines?: {[name: string]: any}) {
+function makeCrossLayer(name: string, texturePath: string, tintsPath: string, defines?: {[name: string]: any}) {
     const stride = 28; // vec3 pos, vec3 normal, fp16*2  => 6 * 4 + 2 * 2 => 24B
     const stridef = (stride / 4) | 0;
     const tris = 4;  // 2 faces * 2 tris each (double-sided)
@@ -350,11 +370,12 @@ function makeCrossLayer(name: string, texturePath: string, defines?: {[name: str
     let material = makeMaterial(defines);
 
     let texture = context.loadTexture(texturePath, render);
+    let tints = context.loadTexture(tintsPath, render);
 
-    return new renderer.InstancedLayer(geometry, material, texture, name);
+    return new renderer.InstancedLayer(geometry, material, texture, tints, name);
 }
 
-function makeCropLayer(name: string, texturePath: string, defines?: { [name: string]: any }) {
+function makeCropLayer(name: string, texturePath: string, tintsPath: string, defines?: { [name: string]: any }) {
     const stride = 28; // vec3 pos, vec3 normal, fp16*2  => 6 * 4 + 2 * 2 => 24B
     const stridef = (stride / 4) | 0;
     const tris = 8;  // 2 faces * 2 tris each (double-sided)
@@ -449,8 +470,9 @@ function makeCropLayer(name: string, texturePath: string, defines?: { [name: str
     let material = makeMaterial(defines);
 
     let texture = context.loadTexture(texturePath, render);
+    let tints = context.loadTexture(tintsPath, render);
 
-    return new renderer.InstancedLayer(geometry, material, texture, name);
+    return new renderer.InstancedLayer(geometry, material, texture, tints, name);
 }
 
 function makeCube() {
@@ -539,13 +561,14 @@ function makeCube() {
 let cube = makeCube();
 
 
-const layerNames = ["CUBE", "VOXEL", "CROSS", "CROP", "CUBE_FALLBACK"]
+const layerNames = ["CUBE", "VOXEL", "CROSS", "CROP", "CUBE_FALLBACK", "MODEL"]
 let layers = [
-    makeCubeLayer("CUBE", "textures/atlas0.png"),
-    makeCubeLayer("VOXEL", "textures/atlas1.png", {VOXEL: 1}),
-    makeCrossLayer("CROSS", "textures/atlas2.png", {CROSS: 1}),
-    makeCropLayer("CROP", "textures/atlas3.png", {CROSS: 1}),
-    makeCubeLayer("CUBE_FALLBACK", "textures/atlas4.png", {WATER_ID: 1, FALLBACK: 1})
+    makeCubeLayer("CUBE", "textures/atlas0.png", "textures/tints0.png"),
+    makeCubeLayer("VOXEL", "textures/atlas1.png", "textures/tints1.png", {VOXEL: 1}),
+    makeCrossLayer("CROSS", "textures/atlas2.png", "textures/tints2.png", {CROSS: 1}),
+    makeCropLayer("CROP", "textures/atlas3.png", "textures/tints3.png", {CROSS: 1}),
+    makeCubeLayer("CUBE_FALLBACK", "textures/atlas4.png", "textures/tints4.png", {FALLBACK: 1}),
+    makeModelLayer("MODEL", "textures/atlas5.png", "textures/tints5.png", "textures/elements.png")
 ];
 
 let willRender = false;
@@ -579,6 +602,7 @@ function renderFrame() {
 
 
     renderer.render(context, camera, scene, layers, cube);
+    markers.update(camera, context.canvas.width, context.canvas.height);
 
     stats.end();
 };
@@ -638,8 +662,8 @@ function fetchRegion(x: number, z: number, off: number) {
             const stream = asyncIterableFromStream(response.body);
             const header = (await stream.next()).value;
             const magic = new TextDecoder("utf-8").decode(header.subarray(0, 8));
-            if (magic != "COMTE00\n") {
-                console.error(`invalid comte data file (expected magic "COMTE00\\n", got "${magic}"`);
+            if (magic != "COMTE01\n") {
+                console.error(`invalid comte data file (expected magic "COMTE01\\n", got "${magic}"`);
                 controller.abort();
                 return;
             }
@@ -647,26 +671,37 @@ function fetchRegion(x: number, z: number, off: number) {
             let meta = JSON.parse(new TextDecoder("utf-8").decode(header.subarray(12, 12 + headerLength)));
 
             let sectionLengths : Array<number> = meta.layers.map((x: {length: number}) => x.length);
-            let length = sectionLengths.reduce((a, b) => a + b);
+            let length = sectionLengths.reduce((a, b) => a + b, 0);
 
             let value = header.subarray(12 + headerLength);
             let done = false;
 
             console.debug("streaming", response.url, (length / 1024) | 0, "KiB, sections", meta, sectionLengths);
 
-            let chunk = context.Chunk();
-
-            vec3.set(chunk.position, x * 512 + (off&1) * 256, 0, z * 512 + (off&2) * 128);
-
-            let layerSpecs: any = {};
+            // instance positions only hold 8 bits of Y, so the tile is split
+            // into bands of 256 blocks, each drawn as its own chunk
+            let chunks: Map<number, renderer.Chunk> = new Map();
+            let layerSpecs: Map<number, any> = new Map();
             for (const layer of meta.layers) {
-                layerSpecs[layer.name] = { data: new Uint32Array(layer.length/4), retain: true,
+                if (!chunks.has(layer.y)) {
+                    let chunk = context.Chunk();
+                    vec3.set(chunk.position, x * 512 + (off&1) * 256, layer.y, z * 512 + (off&2) * 128);
+                    chunks.set(layer.y, chunk);
+                    layerSpecs.set(layer.y, {});
+                }
+                layerSpecs.get(layer.y)[layer.name] = { data: new Uint32Array(layer.length/4), retain: true,
                     numComponents: CUBE_ATTRIB_STRIDE, stride: CUBE_ATTRIB_STRIDE * 4, divisor: 1 };
             }
 
-            chunk.setLayers(layerSpecs);
+            for (const [y, chunk] of chunks) {
+                chunk.setLayers(layerSpecs.get(y));
+                scene.add(chunk);
+            }
 
-            scene.add(chunk);
+            // the biome color map comes before the layers
+            const biomeLength = meta.biomes ? meta.biomes.length : 0;
+            const biomeData = new Uint8Array(biomeLength);
+            let biomeOffset = 0;
 
             let offset = 0;
             let layerNumber = 0;
@@ -677,14 +712,30 @@ function fetchRegion(x: number, z: number, off: number) {
                     continue;
                 }
 
+                if (biomeOffset < biomeLength) {
+                    let wanted = Math.min(value.length, biomeLength - biomeOffset);
+                    biomeData.set(value.subarray(0, wanted), biomeOffset);
+                    biomeOffset += wanted;
+                    value = value.subarray(wanted);
+                    if (biomeOffset == biomeLength) {
+                        const biomes = context.biomeTexture(biomeData, meta.biomes.width);
+                        for (const chunk of chunks.values()) {
+                            chunk.biomes = biomes;
+                        }
+                        render();
+                    }
+                    continue;
+                }
+
                 let wanted = Math.min(value.length, sectionLengths[layerNumber] - offset);
                 let tail = value.subarray(wanted);
                 value = value.subarray(0, wanted);
 
-                let layerName = meta.layers[layerNumber].name;
-                chunk.updateAttribute(layerName, value, offset);
+                let layer = meta.layers[layerNumber];
+                let chunk = chunks.get(layer.y);
+                chunk.updateAttribute(layer.name, value, offset);
                 offset += value.length;
-                chunk.layers[layerName].size = Math.floor(offset / (CUBE_ATTRIB_STRIDE * 4));
+                chunk.layers[layer.name].size = Math.floor(offset / (CUBE_ATTRIB_STRIDE * 4));
 
                 value = tail;
 
@@ -695,52 +746,118 @@ function fetchRegion(x: number, z: number, off: number) {
                 render();
             }
 
-            let minY = 255, maxY = 0;
-            for (const [name, value] of Object.entries(chunk.layers)) {
-                if (value.data) {
-                    const buf = new Uint8Array(value.data);
-                    for (let o = 0; o < buf.length; o += value.stride) {
-                        let y = buf[o];
-                        minY = Math.min(minY, y);
-                        maxY = Math.max(maxY, y);
+            for (const chunk of chunks.values()) {
+                let minY = 255, maxY = 0;
+                for (const [name, value] of Object.entries(chunk.layers)) {
+                    if (value.data) {
+                        const buf = new Uint8Array(value.data);
+                        for (let o = 0; o < buf.length; o += value.stride) {
+                            let y = buf[o];
+                            minY = Math.min(minY, y);
+                            maxY = Math.max(maxY, y);
+                        }
+                        value.data = null;
                     }
-                    value.data = null;
                 }
+                chunk.minY = minY;
+                chunk.maxY = maxY;
+                console.debug("done streaming", response.url, chunk.position[1], minY, maxY);
+            }
+
+            // swap out the old version of the tile, if this was an update
+            const key = `${x}.${z}.${off}`;
+            for (const old of tiles.get(key) || []) {
+                scene.delete(old);
+                old.dispose();
             }
-            chunk.minY = minY;
-            chunk.maxY = maxY;
-            console.debug("done streaming", response.url, minY, maxY);
+            tiles.set(key, [...chunks.values()]);
+            render();
         },
         reason => console.log("rejected", reason)
     );
 }
 
-// interesting coords:
-// Novigrad: r.{0..3}.{0..3} -1.5, -2.8]
+function fetchPOIs(x: number, z: number) {
+    fetch(`map/r.${x}.${z}.poi.json`).then(
+        async response => {
+            if (!response.ok) {
+                return;
+            }
+            const pois = await response.json();
+            const region = `${x}.${z}`;
+            markers.removeRegion(region);
+            markers.add(pois.block_entities, region);
+            markers.addEntities(pois.entities || [], region);
+            render();
+        },
+        reason => console.log("rejected", reason)
+    );
+}
+
+// the server re-renders regions as the game saves them, and tells us
+// which ones have changed so we can refetch the ones we're showing
+new EventSource('events').addEventListener('region', (e: MessageEvent) => {
+    const { x, z } = JSON.parse(e.data);
+    if (!tiles.has(`${x}.${z}.0`))
+        return;
+    for (let o = 0; o < 4; o++)
+        fetchRegion(x, z, o);
+    fetchPOIs(x, z);
+});
+
+interface RegionsInfo {
+    spawn: { x: number, y: number, z: number } | null;
+    y_bounds: [number, number] | null;
+    regions: { x: number, z: number, quadrants: number[] }[];
+}
 
-// fetchRegion(1,1,0,-1.2,-1.2);
+// look north at a point from above and behind it
+function lookAt(x: number, y: number, z: number) {
+    vec3.set(controls.target, x, y, z);
+    vec3.set(camera.position, x, y + 80, z + 120);
+    controls.update();
+}
 
-function fetchRange(xs: number, xe: number, zs: number, ze: number, angle: number, xo: number, zo: number) {
+function fetchRange(xs: number, xe: number, zs: number, ze: number) {
     for (let o = 0; o < 4; o++) {
         for (let x = xs; x <= xe; x++) {
             for (let z = zs; z <= ze; z++) {
                     fetchRegion(x, z, o);
+                    if (o == 0)
+                        fetchPOIs(x, z);
             }
         }
     }
-    vec3.set(camera.position, xo * 512, 120, zo * 512);
-    vec3.add(controls.target, camera.position, vec3.rotateY(vec3.create(), vec3.fromValues(256, -40, 0), vec3.create(), angle * Math.PI / 180));
-    controls.update();
 }
 
-setTimeout(function() {
-    const choice: string = 'center';
-    switch (choice) {
-        case 'novitest': fetchRange(1, 1, 1, 1, 130, 1.3, 1.4); break;
-        case 'novigrad': fetchRange(0, 3, 0, 3, 130, 2.3, 3.4); break;
-        case 'test': fetchRange(0, 1, 0, 1, 0, 0, 0); break;
-        default: case 'center': fetchRange(-1, 1, -1, 1, 90, 0, 0); break;
-    }
+// ask the server which regions exist, and load all of them,
+// starting from spawn (or the origin, outside the overworld)
+function fetchWorld() {
+    fetch('api/regions').then(
+        async response => {
+            if (!response.ok) {
+                // e.g. the test world, which has no region files
+                fetchRange(-1, 1, -1, 1);
+                lookAt(0, 64, 0);
+                maybeSetCameraFromLocstring();
+                return;
+            }
+            const info: RegionsInfo = await response.json();
+            const yMid = info.y_bounds ? (info.y_bounds[0] + info.y_bounds[1]) / 2 : 64;
+            const spawn = info.spawn || { x: 0, y: yMid, z: 0 };
+            lookAt(spawn.x, spawn.y, spawn.z);
+            maybeSetCameraFromLocstring();
+
+            const dist = (r: { x: number, z: number }) => Math.hypot(r.x * 512 + 256 - spawn.x, r.z * 512 + 256 - spawn.z);
+            info.regions.sort((a, b) => dist(a) - dist(b));
+            for (const r of info.regions) {
+                for (const o of r.quadrants)
+                    fetchRegion(r.x, r.z, o);
+                fetchPOIs(r.x, r.z);
+            }
+        },
+        reason => console.log("rejected", reason)
+    );
+}
 
-    maybeSetCameraFromLocstring();
-}, 500)
+setTimeout(fetchWorld, 500);
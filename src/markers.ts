@@ -0,0 +1,154 @@
+import { mat4, vec4 } from 'gl-matrix';
+
+import * as renderer from './renderer';
+
+// BlockEntity matches region.BlockEntity in the Go code.
+export interface BlockEntity {
+    id: string
+    x: number
+    y: number
+    z: number
+    name?: string
+    text?: string[]
+    items?: { id: string, count: number }[]
+    spawner?: string
+}
+
+// Entity matches region.Entity in the Go code.
+export interface Entity {
+    id: string
+    x: number
+    y: number
+    z: number
+    name?: string
+    tamed?: boolean
+    item?: string
+    pose?: { [part: string]: [number, number, number] }
+    profession?: string
+}
+
+// labels further than this (in blocks) from the camera are hidden
+const MAX_DISTANCE = 256;
+
+function shortId(id: string): string {
+    return id.replace(/^minecraft:/, '').replace(/_/g, ' ');
+}
+
+// markerText gives the label for a point of interest, or null if it shouldn't get one.
+// Unnamed containers are skipped, since there are far too many of them.
+function markerText(be: BlockEntity): string | null {
+    if (be.text) {
+        return be.text.filter(line => line.trim() != '').join('\n');
+    }
+    if (be.spawner) {
+        return `${shortId(be.spawner)} spawner`;
+    }
+    if (be.name) {
+        let text = be.name;
+        if (be.items) {
+            text += '\n' + be.items.slice(0, 3).map(it => `${it.count} ${shortId(it.id)}`).join(', ');
+            if (be.items.length > 3)
+                text += ', ...';
+        }
+        return text;
+    }
+    return null;
+}
+
+// entityText gives the label for an entity, or null if it shouldn't get one.
+// Unnamed armor stands and empty item frames are skipped.
+function entityText(e: Entity): string | null {
+    if (e.name) {
+        return e.profession ? `${e.name}\n${shortId(e.profession)}` : e.name;
+    }
+    if (e.item) {
+        return shortId(e.item);
+    }
+    if (e.profession) {
+        return `${shortId(e.profession)} ${shortId(e.id)}`;
+    }
+    if (e.tamed) {
+        return `tamed ${shortId(e.id)}`;
+    }
+    if (/villager|trader/.test(e.id)) {
+        return shortId(e.id);
+    }
+    return null;
+}
+
+interface Marker {
+    pos: vec4
+    el: HTMLElement
+    region: string  // the region the label came from, like "1.-2"
+}
+
+// Markers draws HTML labels for signs, named containers & banners, spawners,
+// and named or notable entities over the map canvas.
+export class Markers {
+    container: HTMLElement
+    markers: Marker[]
+
+    constructor(parent: HTMLElement) {
+        this.container = document.createElement('div');
+        Object.assign(this.container.style, {
+            position: 'absolute', left: '0', top: '0', width: '100%', height: '100%',
+            overflow: 'hidden', pointerEvents: 'none',
+        });
+        parent.appendChild(this.container);
+        this.markers = [];
+    }
+
+    add(pois: BlockEntity[], region: string) {
+        for (const be of pois) {
+            const text = markerText(be);
+            if (text)
+                this.addLabel(text, vec4.fromValues(be.x + 0.5, be.y + 1, be.z + 0.5, 1), region);
+        }
+    }
+
+    addEntities(entities: Entity[], region: string) {
+        for (const e of entities) {
+            const text = entityText(e);
+            if (text)
+                this.addLabel(text, vec4.fromValues(e.x, e.y + 2, e.z, 1), region);
+        }
+    }
+
+    // removeRegion drops the labels of a region, before its updated ones are added
+    removeRegion(region: string) {
+        for (const m of this.markers) {
+            if (m.region == region)
+                m.el.remove();
+        }
+        this.markers = this.markers.filter(m => m.region != region);
+    }
+
+    addLabel(text: string, pos: vec4, region: string) {
+        const el = document.createElement('div');
+        Object.assign(el.style, {
+            position: 'absolute', left: '0', top: '0', display: 'none',
+            padding: '2px 4px', background: 'rgba(0, 0, 0, 0.6)', color: 'white',
+            font: '12px sans-serif', whiteSpace: 'pre', textAlign: 'center',
+        });
+        el.textContent = text;
+        this.container.appendChild(el);
+        this.markers.push({ pos, el, region });
+    }
+
+    update(camera: renderer.PerspectiveCamera, width: number, height: number) {
+        const viewProj = mat4.mul(mat4.create(), camera.getProjection(), camera.getView());
+        const p = vec4.create();
+        for (const m of this.markers) {
+            vec4.transformMat4(p, m.pos, viewProj);
+            const w = p[3];
+            const x = (p[0] / w * 0.5 + 0.5) * width;
+            const y = (-p[1] / w * 0.5 + 0.5) * height;
+            if (w <= 0 || w > MAX_DISTANCE || x < 0 || x > width || y < 0 || y > height) {
+                m.el.style.display = 'none';
+                continue;
+            }
+            m.el.style.display = '';
+            m.el.style.transform = `translate(${x | 0}px, ${y | 0}px) translate(-50%, -100%)`;
+        }
+    }
+}
@@ -78,7 +78,9 @@ export class InstancedLayer {
         public geometry: Geometry,
         public material: Material,
         public texture: WebGLTexture,
+        public tints: WebGLTexture,
         public name: string,
+        public elements?: WebGLTexture,
     ) {}
 }
 
@@ -90,9 +92,11 @@ export class Chunk {
     occluded: boolean
     query: WebGLQuery
     queryInProgress: boolean
+    biomes: WebGLTexture
 
     constructor(public gl: WebGLRenderingContext) {
         this.position = vec3.create();
+        this.biomes = null;
         this.query = null;
         this.queryInProgress = false;
         this.occluded = false;
@@ -108,6 +112,18 @@ export class Chunk {
         Object.assign(this.layers, webgl_utils.createAttribsFromArrays(this.gl, { [name]: array }));
     }
 
+    // dispose frees the GPU resources of a chunk once it's been removed from the scene
+    dispose() {
+        const gl = this.gl as WebGL2RenderingContext;
+        for (const layer of Object.values(this.layers || {}))
+            gl.deleteBuffer(layer.buffer);
+        if (this.query)
+            gl.deleteQuery(this.query);
+        if (this.biomes)
+            gl.deleteTexture(this.biomes);
+        this.layers = {};
+    }
+
     updateAttribute(name: string, array: any, offset?: number) {
         if (!(name in this.layers)) {
             throw new Error("unknown attribute: " + name);
@@ -125,6 +141,7 @@ export class Context {
     canvas: HTMLCanvasElement
     gl: WebGL2RenderingContext
     clearColor: vec4
+    defaultBiomes: WebGLTexture
 
     constructor(canvas: HTMLCanvasElement) {
         this.canvas = canvas;
@@ -153,6 +170,34 @@ export class Context {
         return new Chunk(this.gl);
     }
 
+    // biomeTexture uploads a tile's biome color map: RGB rows of the given width
+    // with the grass, foliage and water maps stacked vertically.
+    biomeTexture(data: Uint8Array, width: number, filter?: number): WebGLTexture {
+        const gl = this.gl;
+        const texture = gl.createTexture();
+        gl.bindTexture(gl.TEXTURE_2D, texture);
+        gl.pixelStorei(gl.UNPACK_ALIGNMENT, 1);
+        gl.texImage2D(gl.TEXTURE_2D, 0, gl.RGB8, width, data.length / (width * 3), 0, gl.RGB, gl.UNSIGNED_BYTE, data);
+        gl.pixelStorei(gl.UNPACK_ALIGNMENT, 4);
+        gl.texParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, filter || gl.LINEAR);
+        gl.texParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, filter || gl.LINEAR);
+        gl.texParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE);
+        gl.texParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE);
+        return texture;
+    }
+
+    // getDefaultBiomes gives plains colors, for tiles without a biome map.
+    getDefaultBiomes(): WebGLTexture {
+        if (!this.defaultBiomes) {
+            this.defaultBiomes = this.biomeTexture(new Uint8Array([
+                0x91, 0xbd, 0x59,  // grass
+                0x77, 0xab, 0x2f,  // foliage
+                0x3f, 0x76, 0xe4,  // water
+            ]), 1, this.gl.NEAREST);
+        }
+        return this.defaultBiomes;
+    }
+
     loadTexture(path: string, done?: ()=>void): WebGLTexture {
         function isPowerOf2(value: number) {
             return (value & (value - 1)) === 0;
@@ -402,6 +447,10 @@ export function render(context: Context, camera: PerspectiveCamera, scene: Set<C
         bind(mat, layer.geometry)
 
         mat.uniformSetters.atlas(layer.texture);
+        if (mat.uniformSetters.tints)
+            mat.uniformSetters.tints(layer.tints);
+        if (mat.uniformSetters.elements)
+            mat.uniformSetters.elements(layer.elements);
 
         let chunkNum = 0;
         for (const chunk of culledChunks) {
@@ -445,6 +494,10 @@ export function render(context: Context, camera: PerspectiveCamera, scene: Set<C
 
                     bind(layer.material, layer.geometry);
                     layer.material.uniformSetters.atlas(layer.texture);
+                    if (layer.material.uniformSetters.tints)
+                        layer.material.uniformSetters.tints(layer.tints);
+                    if (layer.material.uniformSetters.elements)
+                        layer.material.uniformSetters.elements(layer.elements);
                 }
             }
 
@@ -455,6 +508,8 @@ export function render(context: Context, camera: PerspectiveCamera, scene: Set<C
             mat.attribSetters.attr(chunkLayer);
             if (mat.uniformSetters.offset)
                 mat.uniformSetters.offset(chunk.position);
+            if (mat.uniformSetters.biomes)
+                mat.uniformSetters.biomes(chunk.biomes || context.getDefaultBiomes());
 
             // TODO: modelViewMatrix & projectionMatrix?
             var matrix = mat4.translate(mat4.create(), camera.getView(), chunk.position);